@@ -1,40 +1,122 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"rdbms/api/auth"
 	"rdbms/api/handlers"
+	status "rdbms/api/http"
+	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetUpRouter(h handlers.Handler) (r *gin.Engine) {
+// Options configure the router.
+type Options struct {
+	// AllowedOrigins are the origins browsers may call the API from, see
+	// corsMiddleware.
+	AllowedOrigins []string
+	// MaxBodyBytes bounds request bodies; zero means no limit.
+	MaxBodyBytes int64
+	// Logging logs every request.
+	Logging bool
+}
+
+func SetUpRouter(h handlers.Handler, opts Options) (r *gin.Engine) {
 	r = gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
+	if opts.Logging {
+		r.Use(gin.Logger())
+	}
+	r.Use(gin.Recovery())
 
-	r.Use(customCORSMiddleware())
+	r.Use(corsMiddleware(opts.AllowedOrigins))
+	if opts.MaxBodyBytes > 0 {
+		r.Use(bodyLimitMiddleware(opts.MaxBodyBytes))
+	}
 
 	baseRouter := r.Group("/api/v1")
+	if h.Auth != nil {
+		baseRouter.Use(authMiddleware(h.Auth))
+	}
 
 	{
 		table := baseRouter.Group("tables")
 		table.Use().POST("create-table", h.CreateTable)
+		table.Use().GET("", h.ListTables)
+		table.Use().GET(":name", h.GetTable)
+		table.Use().DELETE(":name", h.DropTable)
+		table.Use().POST(":name/truncate", h.TruncateTable)
+		table.Use().POST(":name/rename", h.RenameTable)
+		table.Use().POST(":name/add-column", h.AddColumn)
+		table.Use().POST(":name/drop-column", h.DropColumn)
+		table.Use().POST(":name/rename-column", h.RenameColumn)
+		table.Use().POST(":name/alter-column-type", h.AlterColumnType)
+		table.Use().GET(":name/alter-column-type", h.GetAlterColumnTypeProgress)
 	}
 
 	{
 		table := baseRouter.Group("records")
 		table.Use().POST("insert", h.InsertRecord)
+		table.Use().POST("bulk-insert", h.BulkInsertRecords)
 		table.Use().POST("query", h.GetAllRecords)
+		table.Use().POST("import-csv", h.ImportCSV)
+		table.Use().POST("export-csv", h.ExportCSV)
+		table.Use().POST("import-parquet", h.ImportParquet)
+		table.Use().POST("export-parquet", h.ExportParquet)
+	}
+
+	{
+		admin := baseRouter.Group("admin")
+		admin.Use().GET("tables/:name/pages/:page", h.InspectPage)
+	}
+
+	if h.Auth != nil {
+		authRouter := baseRouter.Group("auth")
+		authRouter.Use().GET("whoami", h.WhoAmI)
+		authRouter.Use().POST("keys", h.CreateAPIKey)
+		authRouter.Use().GET("keys", h.ListAPIKeys)
+		authRouter.Use().DELETE("keys/:id", h.RevokeAPIKey)
+		authRouter.Use().GET("grants", h.ListGrants)
+		authRouter.Use().POST("grant", h.Grant)
+		authRouter.Use().POST("revoke", h.Revoke)
+		authRouter.Use().POST("grant-role", h.GrantRole)
+		authRouter.Use().POST("revoke-role", h.RevokeRole)
+		authRouter.Use().GET("policies", h.ListPolicies)
+		authRouter.Use().POST("policies", h.CreatePolicy)
+		authRouter.Use().DELETE("policies/:table/:policy", h.DropPolicy)
 	}
 	return
 }
 
-func customCORSMiddleware() gin.HandlerFunc {
+// corsMiddleware lets browsers call the API from the allowed origins, with
+// credentials. "*" allows every origin without credentials, as browsers
+// refuse a wildcard with them. Requests from other origins get no CORS
+// headers.
+func corsMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	wildcard := slices.Contains(allowedOrigins, "*")
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Credentials", "true")
+		origin := c.GetHeader("Origin")
+		c.Header("Vary", "Origin")
+		switch {
+		case origin != "" && allowed[strings.ToLower(origin)]:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		case origin != "" && wildcard:
+			c.Header("Access-Control-Allow-Origin", "*")
+		default:
+			c.Next()
+			return
+		}
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept, Origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, Accept, Origin, Cache-Control, X-Requested-With")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -44,3 +126,44 @@ func customCORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// bodyLimitMiddleware rejects requests announcing a body longer than limit
+// and cuts off longer chunked bodies.
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(status.RequestEntityTooLarge.Code, status.Response{
+				Status:      status.RequestEntityTooLarge.Status,
+				Description: status.RequestEntityTooLarge.Description,
+				Data:        fmt.Sprintf("request body is larger than %d bytes", limit),
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// authMiddleware rejects requests without valid credentials and stores the
+// caller in the request context for the handlers.
+func authMiddleware(a *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := a.Authenticate(c.Request)
+		if err != nil {
+			s := status.InternalServerError
+			if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+				s = status.Unauthorized
+				c.Header("WWW-Authenticate", `Bearer realm="rdbms"`)
+			}
+			c.AbortWithStatusJSON(s.Code, status.Response{
+				Status:      s.Status,
+				Description: s.Description,
+				Data:        err.Error(),
+			})
+			return
+		}
+
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+		c.Next()
+	}
+}
@@ -0,0 +1,191 @@
+// Package auth authenticates API requests. Callers present an API key,
+// created through the key endpoints or cmd/apikey and stored hashed in the
+// data directory, or a JWT bearer token signed with HS256 or RS256:
+//
+//	Authorization: Bearer rk_<id>_<secret>
+//	X-API-Key: rk_<id>_<secret>
+//	Authorization: Bearer <jwt>
+//
+// gRPC calls carry the same credentials as x-api-key or authorization
+// metadata, and PostgreSQL protocol clients send them as the password of
+// the user they belong to.
+//
+// Behind an HTTPS listener verifying client certificates, a request without
+// a key or token is authenticated by its certificate, whose subject is
+// mapped to a user as set by ClientCertUser.
+//
+// Callers are authorized by the grants of a GrantStore: privileges on
+// tables, given to users and to roles users are members of. Tokens may name
+// further roles in a roles claim. Members of SuperuserRole pass every
+// check.
+//
+// Row-level security policies of a PolicyStore restrict the rows of a
+// table to those matching predicates on attributes of the caller, taken
+// from the API key, the token claims or, for proxies, X-Caller-* headers.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+	// MethodClientCert authenticates with a client certificate verified by
+	// the TLS handshake.
+	MethodClientCert = "client_certificate"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// User is the user of the API key or the sub claim of the token.
+	User   string `json:"user"`
+	Method string `json:"method"`
+	// KeyID is the ID of the API key, empty for tokens.
+	KeyID string `json:"key_id,omitempty"`
+	// Claims are the claims of the token, nil for API keys.
+	Claims Claims `json:"claims,omitempty"`
+	// CertificateSubject is the subject of the client certificate, for
+	// MethodClientCert.
+	CertificateSubject string `json:"certificate_subject,omitempty"`
+	// Attributes are the attributes of the API key, or those a trusted
+	// proxy sent in X-Caller-* headers.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	trustHeaders bool
+}
+
+// CallerHeaderPrefix starts the headers a proxy trusted with TrustHeaders
+// sets caller attributes with: X-Caller-Tenant sets the tenant attribute.
+const CallerHeaderPrefix = "X-Caller-"
+
+// Attribute returns an attribute of the caller: one of Attributes, or a
+// string or number claim of the token.
+func (p *Principal) Attribute(name string) (string, bool) {
+	if v, ok := p.Attributes[name]; ok {
+		return v, true
+	}
+	switch v := p.Claims[name].(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// Authenticator checks the credentials of requests, and holds the grants
+// and row-level security policies the handlers check them against.
+type Authenticator struct {
+	Keys     *KeyStore
+	Grants   *GrantStore
+	Policies *PolicyStore
+	// JWT is nil when bearer tokens are not accepted.
+	JWT *JWTConfig
+	// ClientCertUser is the field of verified client certificates the user
+	// is taken from, one of CertUserFields. Empty ignores certificates.
+	ClientCertUser string
+}
+
+func NewAuthenticator(keys *KeyStore, grants *GrantStore, policies *PolicyStore, jwt *JWTConfig) *Authenticator {
+	return &Authenticator{Keys: keys, Grants: grants, Policies: policies, JWT: jwt}
+}
+
+// Authenticate returns the caller of r. Errors wrapping ErrNoCredentials
+// or ErrInvalidCredentials mean the request is unauthorized; others are
+// failures to read the key store. A key or token takes precedence over a
+// client certificate.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	principal, err := a.AuthenticateHeader(r.Header)
+	if errors.Is(err, ErrNoCredentials) {
+		if p, certErr := a.certificatePrincipal(r); p != nil || certErr != nil {
+			return p, certErr
+		}
+	}
+	return principal, err
+}
+
+// AuthenticateHeader returns the caller presenting the API key or token of
+// the X-API-Key or Authorization header of h, like Authenticate. The gRPC
+// server passes its request metadata.
+func (a *Authenticator) AuthenticateHeader(h http.Header) (*Principal, error) {
+	credential := h.Get("X-API-Key")
+	if credential == "" {
+		scheme, token, ok := strings.Cut(h.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, ErrNoCredentials
+		}
+		credential = strings.TrimSpace(token)
+	}
+
+	principal, err := a.Verify(credential)
+	if err != nil || !principal.trustHeaders {
+		return principal, err
+	}
+
+	for header, values := range h {
+		name, ok := strings.CutPrefix(http.CanonicalHeaderKey(header), CallerHeaderPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if principal.Attributes == nil {
+			principal.Attributes = make(map[string]string)
+		}
+		principal.Attributes[strings.ReplaceAll(strings.ToLower(name), "-", "_")] = values[0]
+	}
+	return principal, nil
+}
+
+// Verify authenticates an API key or a JWT.
+func (a *Authenticator) Verify(credential string) (*Principal, error) {
+	if credential == "" {
+		return nil, ErrNoCredentials
+	}
+
+	if strings.HasPrefix(credential, KeyPrefix) {
+		key, err := a.Keys.Verify(credential)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{
+			User:         key.User,
+			Method:       MethodAPIKey,
+			KeyID:        key.ID,
+			Attributes:   maps.Clone(key.Attributes),
+			trustHeaders: key.TrustHeaders,
+		}, nil
+	}
+
+	if a.JWT == nil {
+		return nil, ErrInvalidCredentials
+	}
+	claims, err := a.JWT.VerifyJWT(credential, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{User: claims["sub"].(string), Method: MethodJWT, Claims: claims}, nil
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored by NewContext, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// The fields of a client certificate its user can be taken from.
+const (
+	// CertUserCN is the common name of the subject.
+	CertUserCN = "cn"
+	// CertUserEmail is the first email address of the subject alternative
+	// names.
+	CertUserEmail = "email"
+	// CertUserDN is the whole subject, as in CN=app,O=Acme.
+	CertUserDN = "dn"
+)
+
+var CertUserFields = []string{CertUserCN, CertUserEmail, CertUserDN}
+
+// CertificateUser returns the user a client certificate maps to, taken from
+// field, one of CertUserFields.
+func CertificateUser(cert *x509.Certificate, field string) (string, error) {
+	var user string
+	switch field {
+	case CertUserCN:
+		user = cert.Subject.CommonName
+	case CertUserEmail:
+		if len(cert.EmailAddresses) > 0 {
+			user = cert.EmailAddresses[0]
+		}
+	case CertUserDN:
+		user = cert.Subject.String()
+	default:
+		return "", fmt.Errorf("unknown certificate user field %q, expected one of %v", field, CertUserFields)
+	}
+	if user == "" {
+		return "", fmt.Errorf("%w: client certificate %s has no %s", ErrInvalidCredentials, cert.Subject, field)
+	}
+	return user, nil
+}
+
+// ValidCertUserField reports whether field is one of CertUserFields.
+func ValidCertUserField(field string) bool {
+	return slices.Contains(CertUserFields, field)
+}
+
+// certificatePrincipal returns the caller of a request authenticated by a
+// verified client certificate, or nil when there is none or ClientCertUser
+// is not set.
+func (a *Authenticator) certificatePrincipal(r *http.Request) (*Principal, error) {
+	if a.ClientCertUser == "" || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	user, err := CertificateUser(cert, a.ClientCertUser)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{User: user, Method: MethodClientCert, CertificateSubject: cert.Subject.String()}, nil
+}
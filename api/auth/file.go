@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// jsonFile is a JSON file of the auth directory that may also be changed
+// by another process, like cmd/apikey. It remembers the version it last
+// read so unchanged files are not decoded again.
+type jsonFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// load decodes the file into v when it changed since the last load or
+// save, and reports whether it did. A missing file loads as null.
+func (f *jsonFile) load(v any) (bool, error) {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		changed := !f.modTime.IsZero()
+		f.modTime, f.size = time.Time{}, 0
+		if changed {
+			return true, json.Unmarshal([]byte("null"), v)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, err
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// save writes v to a temporary file renamed over the file, so a crash
+// never leaves a partial file behind.
+func (f *jsonFile) save(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return nil
+}
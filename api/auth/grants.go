@@ -0,0 +1,454 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const grantsFileName = "grants.json"
+
+// Privilege is an operation a grant allows on a table.
+type Privilege string
+
+const (
+	// Select allows queries and exports. Granted with columns, it allows
+	// reading and filtering on those columns only.
+	Select Privilege = "SELECT"
+	// Insert allows inserts, bulk inserts and imports.
+	Insert Privilege = "INSERT"
+	// Update is reserved for record updates.
+	Update Privilege = "UPDATE"
+	// Delete allows truncating the table.
+	Delete Privilege = "DELETE"
+	// Create allows creating the table; granted on AllTables, creating any
+	// table.
+	Create Privilege = "CREATE"
+	// Alter allows renaming and dropping the table and changing its
+	// columns.
+	Alter Privilege = "ALTER"
+)
+
+// AllPrivileges is what ALL expands to, and what the creator of a table
+// is granted on it.
+var AllPrivileges = []Privilege{Select, Insert, Update, Delete, Create, Alter}
+
+const (
+	// AllTables is the table of grants that apply to every table.
+	AllTables = "*"
+	// SuperuserRole is the built-in role whose members pass every check
+	// and manage keys and grants.
+	SuperuserRole = "admin"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotGranted       = errors.New("grant does not exist")
+)
+
+// ParsePrivileges parses privilege names, case insensitively. ALL stands
+// for every privilege.
+func ParsePrivileges(names []string) ([]Privilege, error) {
+	if len(names) == 0 {
+		return nil, errors.New("privileges are required")
+	}
+
+	var privileges []Privilege
+	for _, name := range names {
+		p := Privilege(strings.ToUpper(strings.TrimSpace(name)))
+		switch {
+		case p == "ALL":
+			privileges = append(privileges, AllPrivileges...)
+		case slices.Contains(AllPrivileges, p):
+			privileges = append(privileges, p)
+		default:
+			return nil, fmt.Errorf("unknown privilege %q", name)
+		}
+	}
+	return privileges, nil
+}
+
+// TableGrant holds the privileges of a grantee on one table.
+type TableGrant struct {
+	Privileges []Privilege `json:"privileges,omitempty"`
+	// Columns are readable through a column-level SELECT grant, when
+	// Privileges lacks SELECT.
+	Columns []string `json:"columns,omitempty"`
+}
+
+// Grantee is a user or a role. Users are the users of API keys and the
+// subjects of tokens; a user may also be used as a role.
+type Grantee struct {
+	// Roles are the roles the grantee is a member of.
+	Roles  []string               `json:"roles,omitempty"`
+	Tables map[string]*TableGrant `json:"tables,omitempty"`
+}
+
+// GrantStore is the catalog of roles and grants, stored in the grants file
+// of the auth directory.
+type GrantStore struct {
+	mu       sync.Mutex
+	file     jsonFile
+	grantees map[string]*Grantee
+}
+
+func OpenGrantStore(dataDir string) (*GrantStore, error) {
+	dir := filepath.Join(dataDir, Dir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &GrantStore{file: jsonFile{path: filepath.Join(dir, grantsFileName)}, grantees: make(map[string]*Grantee)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the grants file again when it changed. The caller holds
+// s.mu, except in OpenGrantStore.
+func (s *GrantStore) reload() error {
+	var grantees map[string]*Grantee
+	changed, err := s.file.load(&grantees)
+	if err != nil || !changed {
+		return err
+	}
+	if grantees == nil {
+		grantees = make(map[string]*Grantee)
+	}
+	s.grantees = grantees
+	return nil
+}
+
+// update applies fn to the catalog and saves it. fn must not change the
+// catalog when it returns an error.
+func (s *GrantStore) update(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	for name, g := range s.grantees {
+		for table, tg := range g.Tables {
+			if len(tg.Privileges) == 0 && len(tg.Columns) == 0 {
+				delete(g.Tables, table)
+			}
+		}
+		if len(g.Roles) == 0 && len(g.Tables) == 0 {
+			delete(s.grantees, name)
+		}
+	}
+	return s.file.save(s.grantees)
+}
+
+func (s *GrantStore) grantee(name string) *Grantee {
+	g, ok := s.grantees[name]
+	if !ok {
+		g = &Grantee{}
+		s.grantees[name] = g
+	}
+	if g.Tables == nil {
+		g.Tables = make(map[string]*TableGrant)
+	}
+	return g
+}
+
+// Grant gives grantee privileges on table. Columns restrict a SELECT grant
+// to those columns and are not allowed with other privileges.
+func (s *GrantStore) Grant(grantee, table string, privileges []Privilege, columns []string) error {
+	if grantee == "" || table == "" {
+		return errors.New("grantee and table are required")
+	}
+	if len(columns) > 0 && slices.ContainsFunc(privileges, func(p Privilege) bool { return p != Select }) {
+		return errors.New("column privileges are only supported for SELECT")
+	}
+
+	return s.update(func() error {
+		g := s.grantee(grantee)
+		tg, ok := g.Tables[table]
+		if !ok {
+			tg = &TableGrant{}
+			g.Tables[table] = tg
+		}
+		if len(columns) > 0 {
+			tg.Columns = union(tg.Columns, columns)
+		} else {
+			tg.Privileges = union(tg.Privileges, privileges)
+		}
+		return nil
+	})
+}
+
+// Revoke takes privileges on table back from grantee. With columns, only
+// SELECT on those columns is revoked; revoking SELECT on the table also
+// revokes it on every column.
+func (s *GrantStore) Revoke(grantee, table string, privileges []Privilege, columns []string) error {
+	if len(columns) > 0 && slices.ContainsFunc(privileges, func(p Privilege) bool { return p != Select }) {
+		return errors.New("column privileges are only supported for SELECT")
+	}
+
+	return s.update(func() error {
+		g, ok := s.grantees[grantee]
+		if !ok || g.Tables[table] == nil {
+			return ErrNotGranted
+		}
+		tg := g.Tables[table]
+		if len(columns) > 0 {
+			tg.Columns = remove(tg.Columns, columns)
+			return nil
+		}
+		tg.Privileges = remove(tg.Privileges, privileges)
+		if slices.Contains(privileges, Select) {
+			tg.Columns = nil
+		}
+		return nil
+	})
+}
+
+// GrantRole makes member a member of role.
+func (s *GrantStore) GrantRole(role, member string) error {
+	if role == "" || member == "" {
+		return errors.New("role and member are required")
+	}
+	if role == member {
+		return errors.New("a role can not be a member of itself")
+	}
+
+	return s.update(func() error {
+		if s.memberOf(role, member) {
+			return fmt.Errorf("granting %s to %s would make a cycle", role, member)
+		}
+		g := s.grantee(member)
+		g.Roles = union(g.Roles, []string{role})
+		return nil
+	})
+}
+
+// RevokeRole removes member from role.
+func (s *GrantStore) RevokeRole(role, member string) error {
+	return s.update(func() error {
+		g, ok := s.grantees[member]
+		if !ok || !slices.Contains(g.Roles, role) {
+			return ErrNotGranted
+		}
+		g.Roles = remove(g.Roles, []string{role})
+		return nil
+	})
+}
+
+// memberOf reports whether name is member of role, directly or through
+// other roles.
+func (s *GrantStore) memberOf(name, role string) bool {
+	return s.roles([]string{name})[role]
+}
+
+// roles returns names and every role they are members of.
+func (s *GrantStore) roles(names []string) map[string]bool {
+	seen := make(map[string]bool)
+	for len(names) > 0 {
+		name := names[len(names)-1]
+		names = names[:len(names)-1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if g, ok := s.grantees[name]; ok {
+			names = append(names, g.Roles...)
+		}
+	}
+	return seen
+}
+
+// principalRoles returns the user of p and its roles, including the roles
+// listed in the roles claim of a token.
+func (s *GrantStore) principalRoles(p *Principal) map[string]bool {
+	names := []string{p.User}
+	if roles, ok := p.Claims["roles"].([]any); ok {
+		for _, role := range roles {
+			if name, ok := role.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return s.roles(names)
+}
+
+// Grantees returns the catalog.
+func (s *GrantStore) Grantees() (map[string]*Grantee, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	grantees := make(map[string]*Grantee, len(s.grantees))
+	for name, g := range s.grantees {
+		copied := &Grantee{Roles: slices.Clone(g.Roles), Tables: make(map[string]*TableGrant, len(g.Tables))}
+		for table, tg := range g.Tables {
+			copied.Tables[table] = &TableGrant{Privileges: slices.Clone(tg.Privileges), Columns: slices.Clone(tg.Columns)}
+		}
+		grantees[name] = copied
+	}
+	return grantees, nil
+}
+
+// IsSuperuser reports whether p is a member of SuperuserRole.
+func (s *GrantStore) IsSuperuser(p *Principal) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	return s.principalRoles(p)[SuperuserRole], nil
+}
+
+// Check returns an error wrapping ErrPermissionDenied unless p holds
+// privilege on table. For SELECT, a column-level grant on every one of
+// columns is enough.
+func (s *GrantStore) Check(p *Principal, privilege Privilege, table string, columns []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	roles := s.principalRoles(p)
+	if roles[SuperuserRole] {
+		return nil
+	}
+
+	readable := make(map[string]bool)
+	for name := range roles {
+		g, ok := s.grantees[name]
+		if !ok {
+			continue
+		}
+		for _, t := range []string{table, AllTables} {
+			tg, ok := g.Tables[t]
+			if !ok {
+				continue
+			}
+			if slices.Contains(tg.Privileges, privilege) {
+				return nil
+			}
+			for _, column := range tg.Columns {
+				readable[column] = true
+			}
+		}
+	}
+
+	if privilege == Select && len(columns) > 0 && len(readable) > 0 {
+		for _, column := range columns {
+			if !readable[column] {
+				return fmt.Errorf("%w for column %s of table %s", ErrPermissionDenied, column, table)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w for table %s", ErrPermissionDenied, table)
+}
+
+// CanAccess reports whether p holds any privilege on table, which allows
+// seeing the table in listings and describing it.
+func (s *GrantStore) CanAccess(p *Principal, table string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	roles := s.principalRoles(p)
+	if roles[SuperuserRole] {
+		return true, nil
+	}
+	for name := range roles {
+		g, ok := s.grantees[name]
+		if !ok {
+			continue
+		}
+		if g.Tables[table] != nil {
+			return true, nil
+		}
+		// CREATE on every table is the right to create tables, not to see
+		// the ones of others.
+		if all := g.Tables[AllTables]; all != nil && slices.ContainsFunc(all.Privileges, func(p Privilege) bool { return p != Create }) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RenameTable moves the grants on a table to its new name.
+func (s *GrantStore) RenameTable(from, to string) error {
+	return s.update(func() error {
+		for _, g := range s.grantees {
+			if tg, ok := g.Tables[from]; ok {
+				delete(g.Tables, from)
+				g.Tables[to] = tg
+			}
+		}
+		return nil
+	})
+}
+
+// DropTable removes the grants on a dropped table.
+func (s *GrantStore) DropTable(table string) error {
+	return s.update(func() error {
+		for _, g := range s.grantees {
+			delete(g.Tables, table)
+		}
+		return nil
+	})
+}
+
+// RenameColumn renames a column in the column-level grants on table.
+func (s *GrantStore) RenameColumn(table, from, to string) error {
+	return s.update(func() error {
+		for _, g := range s.grantees {
+			if tg, ok := g.Tables[table]; ok {
+				for i, column := range tg.Columns {
+					if column == from {
+						tg.Columns[i] = to
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// DropColumn removes a dropped column from the column-level grants.
+func (s *GrantStore) DropColumn(table, column string) error {
+	return s.update(func() error {
+		for _, g := range s.grantees {
+			if tg, ok := g.Tables[table]; ok {
+				tg.Columns = remove(tg.Columns, []string{column})
+			}
+		}
+		return nil
+	})
+}
+
+func union[T ~string](a, b []T) []T {
+	for _, v := range b {
+		if !slices.Contains(a, v) {
+			a = append(a, v)
+		}
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	return a
+}
+
+func remove[T ~string](a, b []T) []T {
+	return slices.DeleteFunc(a, func(v T) bool { return slices.Contains(b, v) })
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWTConfig configures the bearer tokens the server accepts. A token must
+// be signed with HS256 under Secret or with RS256 under PublicKey; the
+// algorithm without a key configured is rejected, whatever the token
+// header says. Tokens need a sub and an exp claim.
+type JWTConfig struct {
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// LoadRSAPublicKey reads a PEM encoded RSA public key or certificate.
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in " + path)
+	}
+
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key in " + path + " is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Claims are the claims of a verified token.
+type Claims map[string]any
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// VerifyJWT checks the signature and the registered claims of a compact
+// serialized token and returns its claims.
+func (cfg *JWTConfig) VerifyJWT(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && len(cfg.Secret) > 0:
+		mac := hmac.New(sha256.New, cfg.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, invalidToken("invalid signature")
+		}
+	case header.Alg == "RS256" && cfg.PublicKey != nil:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(cfg.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, invalidToken("invalid signature")
+		}
+	default:
+		return nil, invalidToken(fmt.Sprintf("unsupported algorithm %q", header.Alg))
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+	if err := cfg.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (cfg *JWTConfig) checkClaims(claims Claims, now time.Time) error {
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return invalidToken("missing sub claim")
+	}
+
+	exp, ok := claims.time("exp")
+	if !ok {
+		return invalidToken("missing exp claim")
+	}
+	if now.After(exp.Add(cfg.Leeway)) {
+		return invalidToken("token is expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(cfg.Leeway).Before(nbf) {
+		return invalidToken("token is not valid yet")
+	}
+
+	if cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != cfg.Issuer {
+			return invalidToken("unexpected issuer")
+		}
+	}
+	if cfg.Audience != "" && !claims.hasAudience(cfg.Audience) {
+		return invalidToken("unexpected audience")
+	}
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true
+}
+
+// hasAudience reports whether aud, a string or an array of strings,
+// contains audience.
+func (c Claims) hasAudience(audience string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(strings.NewReader(string(data)))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func invalidToken(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, reason)
+}
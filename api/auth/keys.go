@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dir is the directory of the data directory holding the auth files. The
+// storage engine ignores subdirectories.
+const Dir = "auth"
+
+const (
+	keysFileName = "keys.json"
+
+	// KeyPrefix starts every API key, so keys can be told apart from JWTs
+	// in an Authorization header.
+	KeyPrefix = "rk_"
+)
+
+var ErrKeyNotFound = errors.New("api key does not exist")
+
+// APIKey describes a key without its secret.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	User      string     `json:"user"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Attributes describe the caller to row-level security policies.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// TrustHeaders lets a proxy authenticated with the key set the
+	// attributes of the caller it acts for with X-Caller-* headers.
+	TrustHeaders bool `json:"trust_headers,omitempty"`
+}
+
+type storedKey struct {
+	APIKey
+	// Hash is the hex SHA-256 of the whole key. Keys carry 256 random bits,
+	// so a fast hash is enough.
+	Hash string `json:"hash"`
+}
+
+// KeyStore keeps API keys hashed in the keys file of the auth directory.
+// Changes made by another process, like cmd/apikey, are picked up on the
+// next lookup.
+type KeyStore struct {
+	mu   sync.Mutex
+	file jsonFile
+	keys map[string]*storedKey
+}
+
+func OpenKeyStore(dataDir string) (*KeyStore, error) {
+	dir := filepath.Join(dataDir, Dir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &KeyStore{file: jsonFile{path: filepath.Join(dir, keysFileName)}, keys: make(map[string]*storedKey)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the keys file again when it changed. The caller holds s.mu,
+// except in OpenKeyStore.
+func (s *KeyStore) reload() error {
+	var list []*storedKey
+	changed, err := s.file.load(&list)
+	if err != nil || !changed {
+		return err
+	}
+
+	s.keys = make(map[string]*storedKey, len(list))
+	for _, k := range list {
+		s.keys[k.ID] = k
+	}
+	return nil
+}
+
+func (s *KeyStore) save() error {
+	list := make([]*storedKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return s.file.save(list)
+}
+
+// Create adds a key described by key, whose ID and creation time are
+// assigned, and returns it with the secret key, which is not stored and
+// cannot be shown again.
+func (s *KeyStore) Create(key APIKey) (APIKey, string, error) {
+	if key.User == "" {
+		return APIKey{}, "", errors.New("user is required")
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+
+	key.ID = hex.EncodeToString(id)
+	key.CreatedAt = time.Now().UTC()
+	key.RevokedAt = nil
+	k := &storedKey{APIKey: key}
+	plain := KeyPrefix + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hashKey(plain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return APIKey{}, "", err
+	}
+	s.keys[k.ID] = k
+	if err := s.save(); err != nil {
+		delete(s.keys, k.ID)
+		return APIKey{}, "", err
+	}
+	return k.APIKey, plain, nil
+}
+
+// Revoke marks a key as revoked. Revoked keys stay listed.
+func (s *KeyStore) Revoke(id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return APIKey{}, err
+	}
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now().UTC()
+		k.RevokedAt = &now
+		if err := s.save(); err != nil {
+			k.RevokedAt = nil
+			return APIKey{}, err
+		}
+	}
+	return k.APIKey, nil
+}
+
+// List returns the keys, oldest first.
+func (s *KeyStore) List() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	list := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k.APIKey)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Verify returns the key a secret key belongs to, if it is not revoked.
+func (s *KeyStore) Verify(key string) (APIKey, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, KeyPrefix), "_")
+	if !ok || !strings.HasPrefix(key, KeyPrefix) {
+		return APIKey{}, ErrInvalidCredentials
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return APIKey{}, err
+	}
+	k, found := s.keys[id]
+	if !found || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashKey(key))) != 1 {
+		return APIKey{}, ErrInvalidCredentials
+	}
+	if k.RevokedAt != nil {
+		return APIKey{}, fmt.Errorf("%w: api key is revoked", ErrInvalidCredentials)
+	}
+	return k.APIKey, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
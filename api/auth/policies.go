@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const policiesFileName = "policies.json"
+
+// CallerPrefix starts a policy value bound to an attribute of the caller,
+// as in tenant_id = $caller.tenant.
+const CallerPrefix = "$caller."
+
+var (
+	ErrPolicyNotFound = errors.New("policy does not exist")
+	// ErrPolicyViolation is returned for inserted rows a policy of the
+	// table would hide from the caller.
+	ErrPolicyViolation = errors.New("new row violates row-level security policy")
+)
+
+// Policy is a row-level security policy. A caller sees, and may insert,
+// only the rows of the table whose Column compares to Value with Operator;
+// the policies of a table all apply. Superusers are exempt.
+type Policy struct {
+	Name     string `json:"name"`
+	Column   string `json:"column"`
+	Operator string `json:"operator"`
+	// Value is the text of a literal, or CallerPrefix and the name of an
+	// attribute of the caller.
+	Value string `json:"value"`
+}
+
+var predicatePattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*(!=|<>|=)\s*(.*?)\s*$`)
+
+// ParsePolicy parses a predicate like `tenant_id = $caller.tenant` or
+// `region != 'eu'`. Literals are quoted strings or bare numbers and words.
+func ParsePolicy(name, predicate string) (Policy, error) {
+	if name == "" {
+		return Policy{}, errors.New("policy name is required")
+	}
+
+	m := predicatePattern.FindStringSubmatch(predicate)
+	if m == nil {
+		return Policy{}, fmt.Errorf("invalid predicate %q: expected column = value or column != value", predicate)
+	}
+	p := Policy{Name: name, Column: m[1], Operator: m[2], Value: m[3]}
+	if p.Operator == "<>" {
+		p.Operator = "!="
+	}
+
+	switch {
+	case strings.HasPrefix(p.Value, CallerPrefix):
+		if attribute := strings.TrimPrefix(p.Value, CallerPrefix); attribute == "" || strings.ContainsAny(attribute, " \t'") {
+			return Policy{}, fmt.Errorf("invalid caller attribute in %q", predicate)
+		}
+	case len(p.Value) >= 2 && strings.HasPrefix(p.Value, "'") && strings.HasSuffix(p.Value, "'"):
+		p.Value = strings.ReplaceAll(p.Value[1:len(p.Value)-1], "''", "'")
+	case p.Value == "" || strings.ContainsAny(p.Value, " \t'"):
+		return Policy{}, fmt.Errorf("invalid value in %q: quote string literals", predicate)
+	}
+	return p, nil
+}
+
+// String returns the predicate of the policy.
+func (p Policy) String() string {
+	value := p.Value
+	if !strings.HasPrefix(value, CallerPrefix) {
+		value = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return p.Column + " " + p.Operator + " " + value
+}
+
+// ValueFor returns the text of the value of the policy for a caller. A
+// caller without the attribute the policy is bound to is denied.
+func (p Policy) ValueFor(principal *Principal) (string, error) {
+	attribute, ok := strings.CutPrefix(p.Value, CallerPrefix)
+	if !ok {
+		return p.Value, nil
+	}
+	if v, ok := principal.Attribute(attribute); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("%w: policy %s needs the %s attribute of the caller", ErrPermissionDenied, p.Name, attribute)
+}
+
+// PolicyStore keeps the row-level security policies by table in the
+// policies file of the auth directory.
+type PolicyStore struct {
+	mu       sync.Mutex
+	file     jsonFile
+	policies map[string][]Policy
+}
+
+func OpenPolicyStore(dataDir string) (*PolicyStore, error) {
+	dir := filepath.Join(dataDir, Dir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &PolicyStore{file: jsonFile{path: filepath.Join(dir, policiesFileName)}, policies: make(map[string][]Policy)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the policies file again when it changed. The caller holds
+// s.mu, except in OpenPolicyStore.
+func (s *PolicyStore) reload() error {
+	var policies map[string][]Policy
+	changed, err := s.file.load(&policies)
+	if err != nil || !changed {
+		return err
+	}
+	if policies == nil {
+		policies = make(map[string][]Policy)
+	}
+	s.policies = policies
+	return nil
+}
+
+// update applies fn to the policies and saves them. fn must not change
+// them when it returns an error.
+func (s *PolicyStore) update(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	for table, policies := range s.policies {
+		if len(policies) == 0 {
+			delete(s.policies, table)
+		}
+	}
+	return s.file.save(s.policies)
+}
+
+// Policies returns the policies of table.
+func (s *PolicyStore) Policies(table string) ([]Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.policies[table]), nil
+}
+
+// All returns the policies of every table.
+func (s *PolicyStore) All() (map[string][]Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	all := make(map[string][]Policy, len(s.policies))
+	for table, policies := range s.policies {
+		all[table] = slices.Clone(policies)
+	}
+	return all, nil
+}
+
+// Create adds a policy to table.
+func (s *PolicyStore) Create(table string, policy Policy) error {
+	return s.update(func() error {
+		if slices.ContainsFunc(s.policies[table], func(p Policy) bool { return p.Name == policy.Name }) {
+			return fmt.Errorf("policy %s already exists on table %s", policy.Name, table)
+		}
+		s.policies[table] = append(s.policies[table], policy)
+		return nil
+	})
+}
+
+// Drop removes a policy of table.
+func (s *PolicyStore) Drop(table, name string) error {
+	return s.update(func() error {
+		i := slices.IndexFunc(s.policies[table], func(p Policy) bool { return p.Name == name })
+		if i < 0 {
+			return ErrPolicyNotFound
+		}
+		s.policies[table] = slices.Delete(s.policies[table], i, i+1)
+		return nil
+	})
+}
+
+// RenameTable moves the policies of a table to its new name.
+func (s *PolicyStore) RenameTable(from, to string) error {
+	return s.update(func() error {
+		if policies, ok := s.policies[from]; ok {
+			delete(s.policies, from)
+			s.policies[to] = policies
+		}
+		return nil
+	})
+}
+
+// DropTable removes the policies of a dropped table.
+func (s *PolicyStore) DropTable(table string) error {
+	return s.update(func() error {
+		delete(s.policies, table)
+		return nil
+	})
+}
+
+// RenameColumn renames a column in the policies of table.
+func (s *PolicyStore) RenameColumn(table, from, to string) error {
+	return s.update(func() error {
+		for i := range s.policies[table] {
+			if s.policies[table][i].Column == from {
+				s.policies[table][i].Column = to
+			}
+		}
+		return nil
+	})
+}
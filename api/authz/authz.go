@@ -0,0 +1,246 @@
+// Package authz checks the calls the front ends make on the tables
+// against the grants and row-level security policies of the caller, so
+// the HTTP API, gRPC and pgwire enforce the same privileges and show the
+// same rows. It also keeps the grants and policies in step with the
+// tables they name as those are renamed and dropped.
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"rdbms/api/auth"
+	"rdbms/src/storage"
+)
+
+// Table returns t with every call checked against the grants of
+// principal, refused with an error wrapping auth.ErrPermissionDenied, and
+// the policies of principal applying to the rows, see Policies. Without
+// a, t is returned as is.
+func Table(t storage.TableI, a *auth.Authenticator, principal *auth.Principal) storage.TableI {
+	if a == nil {
+		return t
+	}
+	return &grantTable{TableI: Policies(t, a, principal), auth: a, principal: principal}
+}
+
+type grantTable struct {
+	storage.TableI
+	auth      *auth.Authenticator
+	principal *auth.Principal
+}
+
+func (t *grantTable) check(privilege auth.Privilege, table string, columns ...string) error {
+	return t.auth.Grants.Check(t.principal, privilege, table, columns)
+}
+
+// checkAccess requires some privilege on table, which is what seeing it
+// in listings and reading its schema takes.
+func (t *grantTable) checkAccess(table string) error {
+	ok, err := t.auth.Grants.CanAccess(t.principal, table)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w for table %s", auth.ErrPermissionDenied, table)
+	}
+	return nil
+}
+
+// catalogError logs a failure to update the grants or policies after a
+// table change that succeeded; the change itself is still reported.
+func catalogError(err error) {
+	if err != nil {
+		log.Printf("authz: %v", err)
+	}
+}
+
+// CreateTable grants the caller every privilege on the table it created,
+// unless it is a superuser already.
+func (t *grantTable) CreateTable(name string, schema *storage.Schema) error {
+	if err := t.check(auth.Create, name); err != nil {
+		return err
+	}
+	if err := t.TableI.CreateTable(name, schema); err != nil {
+		return err
+	}
+
+	superuser, err := t.auth.Grants.IsSuperuser(t.principal)
+	if err == nil && !superuser {
+		err = t.auth.Grants.Grant(t.principal.User, name, auth.AllPrivileges, nil)
+	}
+	catalogError(err)
+	return nil
+}
+
+func (t *grantTable) Insert(tableName string, record storage.Record) error {
+	if err := t.check(auth.Insert, tableName); err != nil {
+		return err
+	}
+	return t.TableI.Insert(tableName, record)
+}
+
+func (t *grantTable) InsertBatch(tableName string, records []storage.Record) (map[int]error, error) {
+	if err := t.check(auth.Insert, tableName); err != nil {
+		return nil, err
+	}
+	return t.TableI.InsertBatch(tableName, records)
+}
+
+// GetAllData needs SELECT on the selected and filtered columns, every
+// column when none are selected.
+func (t *grantTable) GetAllData(tableName string, filters []storage.Filter, selectedColumns storage.SelectedColumns) ([]map[string]any, error) {
+	read := make([]string, 0, len(selectedColumns.Columns)+len(filters))
+	read = append(read, selectedColumns.Columns...)
+	if len(read) == 0 {
+		schema, err := t.TableI.GetTableSchema(tableName + ".schema")
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range schema.Columns {
+			read = append(read, column.Name)
+		}
+	}
+	for _, f := range filters {
+		read = append(read, f.Column)
+	}
+
+	if err := t.check(auth.Select, tableName, read...); err != nil {
+		return nil, err
+	}
+	return t.TableI.GetAllData(tableName, filters, selectedColumns)
+}
+
+func (t *grantTable) GetTableSchema(schemaName string) (storage.Schema, error) {
+	if err := t.checkAccess(strings.TrimSuffix(schemaName, ".schema")); err != nil {
+		return storage.Schema{}, err
+	}
+	return t.TableI.GetTableSchema(schemaName)
+}
+
+// ListTables leaves out the tables the caller has no privilege on.
+func (t *grantTable) ListTables() []storage.TableInfo {
+	tables := t.TableI.ListTables()
+	visible := tables[:0]
+	for _, info := range tables {
+		if t.checkAccess(info.Name) == nil {
+			visible = append(visible, info)
+		}
+	}
+	return visible
+}
+
+func (t *grantTable) DescribeTable(name string) (storage.TableInfo, error) {
+	if err := t.checkAccess(name); err != nil {
+		return storage.TableInfo{}, err
+	}
+	return t.TableI.DescribeTable(name)
+}
+
+func (t *grantTable) DropTable(name string) error {
+	if err := t.check(auth.Alter, name); err != nil {
+		return err
+	}
+	if err := t.TableI.DropTable(name); err != nil {
+		return err
+	}
+
+	catalogError(t.auth.Grants.DropTable(name))
+	catalogError(t.auth.Policies.DropTable(name))
+	return nil
+}
+
+func (t *grantTable) Truncate(name string) error {
+	if err := t.check(auth.Delete, name); err != nil {
+		return err
+	}
+	return t.TableI.Truncate(name)
+}
+
+func (t *grantTable) RenameTable(oldName string, newName string) error {
+	if err := t.check(auth.Alter, oldName); err != nil {
+		return err
+	}
+	// a pending rename is completed by the next start, so the grants and
+	// policies follow it already
+	err := t.TableI.RenameTable(oldName, newName)
+	if err != nil && !errors.Is(err, storage.ErrRenamePending) {
+		return err
+	}
+
+	catalogError(t.auth.Grants.RenameTable(oldName, newName))
+	catalogError(t.auth.Policies.RenameTable(oldName, newName))
+	return err
+}
+
+func (t *grantTable) AddColumn(tableName string, column storage.Column) error {
+	if err := t.check(auth.Alter, tableName); err != nil {
+		return err
+	}
+	return t.TableI.AddColumn(tableName, column)
+}
+
+// DropColumn refuses to drop a column a row-level security policy filters
+// on, which would silently lift the policy.
+func (t *grantTable) DropColumn(tableName string, columnName string) error {
+	if err := t.check(auth.Alter, tableName); err != nil {
+		return err
+	}
+	policies, err := t.auth.Policies.Policies(tableName)
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if p.Column == columnName {
+			return fmt.Errorf("column %s is used by policy %s", columnName, p.Name)
+		}
+	}
+	if err := t.TableI.DropColumn(tableName, columnName); err != nil {
+		return err
+	}
+
+	catalogError(t.auth.Grants.DropColumn(tableName, columnName))
+	return nil
+}
+
+func (t *grantTable) RenameColumn(tableName string, columnName string, newName string) error {
+	if err := t.check(auth.Alter, tableName); err != nil {
+		return err
+	}
+	if err := t.TableI.RenameColumn(tableName, columnName, newName); err != nil {
+		return err
+	}
+
+	catalogError(t.auth.Grants.RenameColumn(tableName, columnName, newName))
+	catalogError(t.auth.Policies.RenameColumn(tableName, columnName, newName))
+	return nil
+}
+
+func (t *grantTable) AlterColumnType(tableName string, columnName string, newType storage.ColumnType, length int) (storage.RewriteJob, error) {
+	if err := t.check(auth.Alter, tableName); err != nil {
+		return storage.RewriteJob{}, err
+	}
+	return t.TableI.AlterColumnType(tableName, columnName, newType, length)
+}
+
+func (t *grantTable) GetRewriteJob(tableName string) (storage.RewriteJob, error) {
+	if err := t.checkAccess(tableName); err != nil {
+		return storage.RewriteJob{}, err
+	}
+	return t.TableI.GetRewriteJob(tableName)
+}
+
+// InspectPage shows raw pages, which bypasses column grants and policies,
+// so it is for superusers only.
+func (t *grantTable) InspectPage(tableName string, pageNo int, withHex bool) (storage.PageInspection, error) {
+	superuser, err := t.auth.Grants.IsSuperuser(t.principal)
+	if err != nil {
+		return storage.PageInspection{}, err
+	}
+	if !superuser {
+		return storage.PageInspection{}, fmt.Errorf("%w: superuser required", auth.ErrPermissionDenied)
+	}
+	return t.TableI.InspectPage(tableName, pageNo, withHex)
+}
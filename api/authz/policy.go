@@ -0,0 +1,233 @@
+package authz
+
+import (
+	"fmt"
+	"time"
+
+	"rdbms/api/auth"
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+// Policies returns t with the row-level security policies of a applying
+// to principal: they are ANDed into every scan, and inserted rows they
+// would hide from principal are rejected with an error wrapping
+// auth.ErrPolicyViolation. Row counts only count the rows principal can
+// see, and Truncate, which would remove the others too, is refused.
+// Superusers are exempt. Without a, t is returned as is.
+func Policies(t storage.TableI, a *auth.Authenticator, principal *auth.Principal) storage.TableI {
+	if a == nil {
+		return t
+	}
+	return &policyTable{TableI: t, auth: a, principal: principal}
+}
+
+type policyTable struct {
+	storage.TableI
+	auth      *auth.Authenticator
+	principal *auth.Principal
+}
+
+// rowPolicies are the policies of a table for one caller, with the filters
+// they resolve to in the same order.
+type rowPolicies struct {
+	schema   storage.Schema
+	policies []auth.Policy
+	filters  []storage.Filter
+}
+
+// policies returns the policies of a table that apply to the caller, none
+// for a superuser.
+func (t *policyTable) policies(table string) ([]auth.Policy, error) {
+	policies, err := t.auth.Policies.Policies(table)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	superuser, err := t.auth.Grants.IsSuperuser(t.principal)
+	if err != nil || superuser {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// rowPolicies returns nil when the table has no policies or the caller is
+// a superuser.
+func (t *policyTable) rowPolicies(table string) (*rowPolicies, error) {
+	policies, err := t.policies(table)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+
+	schema, err := t.TableI.GetTableSchema(table + ".schema")
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &rowPolicies{schema: schema, policies: policies}
+	for _, p := range policies {
+		column, ok := schemaColumn(schema, p.Column)
+		if !ok {
+			return nil, fmt.Errorf("%w: policy %s refers to missing column %s", auth.ErrPermissionDenied, p.Name, p.Column)
+		}
+		text, err := p.ValueFor(t.principal)
+		if err != nil {
+			return nil, err
+		}
+		value, err := utils.ParseTextValue(column, text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: policy %s: %v", auth.ErrPermissionDenied, p.Name, err)
+		}
+		rp.filters = append(rp.filters, storage.Filter{Column: p.Column, Operator: p.Operator, Value: value})
+	}
+
+	if rp.filters, err = utils.SetFilterColumnIndexes(schema, rp.filters); err != nil {
+		return nil, fmt.Errorf("%w: %v", auth.ErrPermissionDenied, err)
+	}
+	return rp, nil
+}
+
+// check returns an error wrapping auth.ErrPolicyViolation unless record
+// passes the filter of every policy.
+func (rp *rowPolicies) check(record storage.Record) error {
+	for i, f := range rp.filters {
+		column := rp.schema.Columns[f.ColumnIndex]
+		equal := literalEqual(column.Type, record.Items[f.ColumnIndex].Literal, f.Value)
+		if equal != (f.Operator == string(storage.OpEq)) {
+			return fmt.Errorf("%w %s", auth.ErrPolicyViolation, rp.policies[i].Name)
+		}
+	}
+	return nil
+}
+
+// literalEqual compares a literal of a record built by ToStorageRecord with
+// a filter value converted by SetFilterColumnIndexes.
+func literalEqual(columnType storage.ColumnType, literal, value any) bool {
+	switch columnType {
+	case storage.TypeInt:
+		if n, ok := literal.(int); ok {
+			literal = int64(n)
+		}
+	case storage.TypeTimestamp:
+		a, errA := time.Parse(time.RFC3339Nano, fmt.Sprint(literal))
+		b, errB := time.Parse(time.RFC3339Nano, fmt.Sprint(value))
+		return errA == nil && errB == nil && a.Equal(b)
+	}
+	return literal == value
+}
+
+func (t *policyTable) GetAllData(tableName string, filters []storage.Filter, selectedColumns storage.SelectedColumns) ([]map[string]any, error) {
+	rp, err := t.rowPolicies(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if rp != nil {
+		filters = append(filters[:len(filters):len(filters)], rp.filters...)
+	}
+	return t.TableI.GetAllData(tableName, filters, selectedColumns)
+}
+
+func (t *policyTable) Insert(tableName string, record storage.Record) error {
+	rp, err := t.rowPolicies(tableName)
+	if err != nil {
+		return err
+	}
+	if rp != nil {
+		if err := rp.check(record); err != nil {
+			return err
+		}
+	}
+	return t.TableI.Insert(tableName, record)
+}
+
+// InsertBatch reports the records violating a policy as rejected, along
+// with those the table rejects.
+func (t *policyTable) InsertBatch(tableName string, records []storage.Record) (map[int]error, error) {
+	rp, err := t.rowPolicies(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if rp == nil {
+		return t.TableI.InsertBatch(tableName, records)
+	}
+
+	rejected := make(map[int]error)
+	allowed := make([]storage.Record, 0, len(records))
+	positions := make([]int, 0, len(records))
+	for i, record := range records {
+		if err := rp.check(record); err != nil {
+			rejected[i] = err
+			continue
+		}
+		allowed = append(allowed, record)
+		positions = append(positions, i)
+	}
+
+	if len(allowed) > 0 {
+		inner, err := t.TableI.InsertBatch(tableName, allowed)
+		if err != nil {
+			return nil, err
+		}
+		for i, err := range inner {
+			rejected[positions[i]] = err
+		}
+	}
+	return rejected, nil
+}
+
+func (t *policyTable) Truncate(name string) error {
+	policies, err := t.policies(name)
+	if err != nil {
+		return err
+	}
+	if len(policies) > 0 {
+		return fmt.Errorf("%w: table %s has row-level security policies, truncate removes rows they hide", auth.ErrPermissionDenied, name)
+	}
+	return t.TableI.Truncate(name)
+}
+
+func (t *policyTable) DescribeTable(name string) (storage.TableInfo, error) {
+	info, err := t.TableI.DescribeTable(name)
+	if err != nil {
+		return info, err
+	}
+	if err := t.countVisibleRows(&info); err != nil {
+		return storage.TableInfo{}, err
+	}
+	return info, nil
+}
+
+// ListTables reports a row count of 0 for the tables whose visible rows
+// could not be counted.
+func (t *policyTable) ListTables() []storage.TableInfo {
+	tables := t.TableI.ListTables()
+	for i := range tables {
+		if err := t.countVisibleRows(&tables[i]); err != nil {
+			tables[i].RowCount = 0
+		}
+	}
+	return tables
+}
+
+// countVisibleRows replaces the row count of a table, which counts the
+// rows of every tenant, with the number of rows the caller can see.
+func (t *policyTable) countVisibleRows(info *storage.TableInfo) error {
+	rp, err := t.rowPolicies(info.Name)
+	if err != nil || rp == nil {
+		return err
+	}
+	rows, err := t.TableI.GetAllData(info.Name, rp.filters, storage.SelectedColumns{Columns: []string{rp.filters[0].Column}})
+	if err != nil {
+		return err
+	}
+	info.RowCount = int64(len(rows))
+	return nil
+}
+
+func schemaColumn(schema storage.Schema, name string) (storage.Column, bool) {
+	for _, column := range schema.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return storage.Column{}, false
+}
@@ -0,0 +1,101 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+
+	"rdbms/api/auth"
+	"rdbms/src/storage"
+)
+
+func newAuthenticator(t *testing.T) *auth.Authenticator {
+	t.Helper()
+
+	dir := t.TempDir()
+	keys, err := auth.OpenKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := auth.OpenGrantStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := auth.OpenPolicyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.NewAuthenticator(keys, grants, policies, nil)
+}
+
+// TestPolicyRowCounts checks a caller limited by a policy can not learn
+// about, or remove, the rows of other tenants through the table metadata
+// or Truncate.
+func TestPolicyRowCounts(t *testing.T) {
+	tm, err := storage.NewTableManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tm.Close()
+
+	a := newAuthenticator(t)
+	if err := a.Grants.GrantRole(auth.SuperuserRole, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grants.Grant("alice", "t", auth.AllPrivileges, nil); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := auth.ParsePolicy("tenant_isolation", "tenant = $caller.tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Policies.Create("t", policy); err != nil {
+		t.Fatal(err)
+	}
+
+	root := Table(tm, a, &auth.Principal{User: "root"})
+	alice := Table(tm, a, &auth.Principal{User: "alice", Attributes: map[string]string{"tenant": "a"}})
+
+	schema := storage.Schema{Columns: []storage.Column{{Name: "tenant", Type: storage.TypeVarchar, Length: 8}}}
+	if err := root.CreateTable("t", &schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, tenant := range []string{"a", "b", "b"} {
+		if err := root.Insert("t", storage.Record{Items: []storage.Item{{Literal: tenant}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		table storage.TableI
+		rows  int64
+	}{
+		{"alice", alice, 1},
+		{"root", root, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := tt.table.DescribeTable("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.RowCount != tt.rows {
+				t.Errorf("DescribeTable: got %d rows, want %d", info.RowCount, tt.rows)
+			}
+			tables := tt.table.ListTables()
+			if len(tables) != 1 || tables[0].RowCount != tt.rows {
+				t.Errorf("ListTables: got %+v, want t with %d rows", tables, tt.rows)
+			}
+		})
+	}
+
+	if err := alice.Truncate("t"); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("Truncate as alice: got %v, want %v", err, auth.ErrPermissionDenied)
+	}
+	if info, _ := root.DescribeTable("t"); info.RowCount != 3 {
+		t.Errorf("after Truncate as alice: got %d rows, want 3", info.RowCount)
+	}
+	if err := root.Truncate("t"); err != nil {
+		t.Errorf("Truncate as root: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Package certs serves the TLS certificate of the HTTP server and the CA
+// client certificates are verified with, reloading them when their files
+// change so certificates can be renewed without a restart:
+//
+//	r, err := certs.NewReloader(certs.Options{CertFile: "cert.pem", KeyFile: "key.pem"})
+//	server.TLSConfig = r.TLSConfig()
+//	server.ListenAndServeTLS("", "")
+//
+// The files are checked at most once per CheckInterval, when a client
+// connects. A certificate and key that do not load, as while only one of
+// them was replaced, keep the previous ones in use until they do.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckInterval is how often the files are checked for changes.
+const CheckInterval = time.Second
+
+// Options name the files of a Reloader.
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM file of the CA certificates client certificates
+	// are verified with. Required unless ClientAuth is tls.NoClientCert.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+}
+
+// Reloader holds the certificate and client CAs loaded from the files of
+// Options.
+type Reloader struct {
+	opts Options
+
+	mu        sync.Mutex
+	config    *tls.Config
+	stamps    map[string]stamp
+	lastCheck time.Time
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader loads the files of opts, failing when they do not.
+func NewReloader(opts Options) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("tls needs both a certificate and a key file")
+	}
+	if opts.ClientAuth != tls.NoClientCert && opts.ClientCAFile == "" {
+		return nil, errors.New("client certificate authentication needs a client CA file")
+	}
+
+	r := &Reloader{opts: opts}
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.config, err = Load(opts); err != nil {
+		return nil, err
+	}
+	r.stamps, r.lastCheck = stamps, time.Now()
+	return r, nil
+}
+
+// Load reads the files of opts into a server configuration.
+func Load(opts Options) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls key pair: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+		ClientAuth:   opts.ClientAuth,
+	}
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("client CA: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA: no certificates in %s", opts.ClientCAFile)
+		}
+	}
+	return config, nil
+}
+
+// TLSConfig returns a server configuration that hands out the current
+// certificate and client CAs to every connection.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// current returns the configuration, reloading it first when the files
+// changed since they were last loaded.
+func (r *Reloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < CheckInterval {
+		return r.config
+	}
+	r.lastCheck = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil {
+		log.Printf("certs: %v; keeping the loaded certificate", err)
+		return r.config
+	}
+	if stampsEqual(stamps, r.stamps) {
+		return r.config
+	}
+	config, err := Load(r.opts)
+	if err != nil {
+		log.Printf("certs: %v; keeping the loaded certificate", err)
+		return r.config
+	}
+	log.Printf("certs: reloaded %s", r.opts.CertFile)
+	r.config, r.stamps = config, stamps
+	return r.config
+}
+
+func (r *Reloader) stat() (map[string]stamp, error) {
+	stamps := make(map[string]stamp, 3)
+	for _, path := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps[path] = stamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+func stampsEqual(a, b map[string]stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, s := range a {
+		if t, ok := b[path]; !ok || !s.modTime.Equal(t.modTime) || s.size != t.size {
+			return false
+		}
+	}
+	return true
+}
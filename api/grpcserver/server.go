@@ -0,0 +1,346 @@
+// Package grpcserver serves the storage engine over gRPC. Values travel as
+// typed protobuf messages, so integers keep their full 64-bit precision
+// instead of going through JSON numbers.
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"rdbms/api/auth"
+	"rdbms/api/authz"
+	"rdbms/api/grpcserver/pb"
+	"rdbms/api/models"
+	"rdbms/src"
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+type Server struct {
+	pb.UnimplementedStorageServer
+	Stg src.StorageI
+	// Auth, when set, requires credentials on every call.
+	Auth *auth.Authenticator
+}
+
+// NewGRPCServer returns a gRPC server with the storage service registered.
+// With a, calls must carry credentials in their metadata, see authenticate.
+func NewGRPCServer(stg src.StorageI, a *auth.Authenticator, opts ...grpc.ServerOption) *grpc.Server {
+	srv := &Server{Stg: stg, Auth: a}
+	if a != nil {
+		opts = append(opts, grpc.ChainUnaryInterceptor(srv.unaryAuth), grpc.ChainStreamInterceptor(srv.streamAuth))
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterStorageServer(s, srv)
+	return s
+}
+
+func (s *Server) CreateTable(ctx context.Context, req *pb.CreateTableRequest) (*pb.CreateTableResponse, error) {
+	create := models.CreateTableRequest{Name: req.GetName()}
+	for _, c := range req.GetColumns() {
+		length := int(c.GetLength())
+		column := models.CreateColumn{Name: c.GetName(), Type: int(c.GetType())}
+		if c.GetType() == pb.ColumnType_COLUMN_TYPE_VARCHAR && length > 0 {
+			column.Length = &length
+		}
+		create.Columns = append(create.Columns, column)
+	}
+
+	schema, err := utils.ToStorageSchema(create)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.table(ctx).CreateTable(req.GetName(), &schema); err != nil {
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			return nil, storageError(err)
+		}
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	return &pb.CreateTableResponse{}, nil
+}
+
+func (s *Server) Insert(ctx context.Context, req *pb.InsertRequest) (*pb.InsertResponse, error) {
+	schema, err := s.schema(ctx, req.GetTable())
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range req.GetValues() {
+		if columnIndex(schema, name) < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "column %s does not exist", name)
+		}
+	}
+
+	items := make([]storage.Item, 0, len(schema.Columns))
+	for _, column := range schema.Columns {
+		value, ok := req.GetValues()[column.Name]
+		var item storage.Item
+		if ok && !isNull(value) {
+			item, err = toItem(column, value)
+		} else if column.Default != nil {
+			item, err = utils.ToStorageItem(column, column.Default)
+		} else {
+			err = errors.New("missing column: " + column.Name)
+		}
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		items = append(items, item)
+	}
+
+	if err := s.table(ctx).Insert(req.GetTable(), storage.Record{Items: items}); err != nil {
+		return nil, storageError(err)
+	}
+	return &pb.InsertResponse{}, nil
+}
+
+func (s *Server) Query(req *pb.QueryRequest, stream grpc.ServerStreamingServer[pb.Row]) error {
+	schema, err := s.schema(stream.Context(), req.GetTable())
+	if err != nil {
+		return err
+	}
+
+	columns := schema.Columns
+	if len(req.GetColumns()) > 0 {
+		columns = make([]storage.Column, 0, len(req.GetColumns()))
+		for _, name := range req.GetColumns() {
+			i := columnIndex(schema, name)
+			if i < 0 {
+				return status.Errorf(codes.InvalidArgument, "column %s does not exist", name)
+			}
+			columns = append(columns, schema.Columns[i])
+		}
+	}
+
+	filters := make([]storage.Filter, 0, len(req.GetFilters()))
+	for _, f := range req.GetFilters() {
+		i := columnIndex(schema, f.GetColumn())
+		if i < 0 {
+			return status.Errorf(codes.InvalidArgument, "column %s does not exist", f.GetColumn())
+		}
+		if f.GetOperator() != string(storage.OpEq) && f.GetOperator() != string(storage.OpNe) {
+			return status.Errorf(codes.InvalidArgument, "unsupported operator %q", f.GetOperator())
+		}
+		value, err := filterValue(schema.Columns[i], f.GetValue())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		filters = append(filters, storage.Filter{Column: f.GetColumn(), Operator: f.GetOperator(), Value: value, ColumnIndex: i})
+	}
+
+	selected := storage.SelectedColumns{}
+	for _, column := range columns {
+		selected.Columns = append(selected.Columns, column.Name)
+	}
+	data, err := s.table(stream.Context()).GetAllData(req.GetTable(), filters, selected)
+	if err != nil {
+		return storageError(err)
+	}
+
+	for _, row := range data {
+		values := make([]*pb.Value, len(columns))
+		for i, column := range columns {
+			value, err := toValue(column, row[column.Name])
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			values[i] = value
+		}
+		if err := stream.Send(&pb.Row{Values: values}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) GetTableSchema(ctx context.Context, req *pb.GetTableSchemaRequest) (*pb.TableSchema, error) {
+	schema, err := s.schema(ctx, req.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	out := &pb.TableSchema{Name: req.GetName(), Version: uint32(schema.Version)}
+	for _, column := range schema.Columns {
+		out.Columns = append(out.Columns, &pb.Column{
+			Name:   column.Name,
+			Type:   pb.ColumnType(column.Type),
+			Length: int32(column.Length),
+		})
+	}
+	return out, nil
+}
+
+// table returns the tables a call reads and writes, checked against the
+// grants and row-level security policies of the caller when Auth is set.
+func (s *Server) table(ctx context.Context) storage.TableI {
+	principal, _ := auth.FromContext(ctx)
+	return authz.Table(s.Stg.Table(), s.Auth, principal)
+}
+
+func (s *Server) schema(ctx context.Context, table string) (storage.Schema, error) {
+	schema, err := s.table(ctx).GetTableSchema(table + ".schema")
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		return storage.Schema{}, storageError(err)
+	}
+	if err != nil {
+		return storage.Schema{}, status.Errorf(codes.NotFound, "table %s does not exist", table)
+	}
+	return schema, nil
+}
+
+func storageError(err error) error {
+	var corrupt *storage.CorruptPageError
+	if errors.As(err, &corrupt) {
+		return status.Error(codes.DataLoss, err.Error())
+	}
+	if errors.Is(err, auth.ErrPermissionDenied) || errors.Is(err, auth.ErrPolicyViolation) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func columnIndex(schema storage.Schema, name string) int {
+	for i, column := range schema.Columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNull(value *pb.Value) bool {
+	_, null := value.GetKind().(*pb.Value_NullValue)
+	return value.GetKind() == nil || null
+}
+
+// toItem converts a value to the literal SerializeRecord expects for the
+// column, checking that the value kind matches the column type.
+func toItem(column storage.Column, value *pb.Value) (storage.Item, error) {
+	mismatch := fmt.Errorf("column %s has type %s", column.Name, column.Type)
+
+	switch column.Type {
+	case storage.TypeInt:
+		v, ok := value.GetKind().(*pb.Value_IntValue)
+		if !ok {
+			return storage.Item{}, mismatch
+		}
+		return storage.Item{Literal: int(v.IntValue)}, nil
+	case storage.TypeFloat:
+		v, ok := value.GetKind().(*pb.Value_FloatValue)
+		if !ok {
+			return storage.Item{}, mismatch
+		}
+		return storage.Item{Literal: v.FloatValue}, nil
+	case storage.TypeVarchar:
+		v, ok := value.GetKind().(*pb.Value_VarcharValue)
+		if !ok {
+			return storage.Item{}, mismatch
+		}
+		if len(v.VarcharValue) > column.Length {
+			return storage.Item{}, errors.New("column " + column.Name + " exceeds length")
+		}
+		return storage.Item{Literal: v.VarcharValue}, nil
+	case storage.TypeDate:
+		v, ok := value.GetKind().(*pb.Value_DateValue)
+		if !ok {
+			return storage.Item{}, mismatch
+		}
+		return storage.Item{Literal: dateString(v.DateValue)}, nil
+	case storage.TypeTimestamp:
+		v, ok := value.GetKind().(*pb.Value_TimestampValue)
+		if !ok {
+			return storage.Item{}, mismatch
+		}
+		if err := v.TimestampValue.CheckValid(); err != nil {
+			return storage.Item{}, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		return storage.Item{Literal: v.TimestampValue.AsTime().UTC().Format(time.RFC3339Nano)}, nil
+	case storage.TypeJSON:
+		v, ok := value.GetKind().(*pb.Value_JsonValue)
+		if !ok {
+			return storage.Item{}, mismatch
+		}
+		if !json.Valid([]byte(v.JsonValue)) {
+			return storage.Item{}, errors.New("invalid json for " + column.Name)
+		}
+		return storage.Item{Literal: v.JsonValue}, nil
+	}
+
+	return storage.Item{}, errors.New("unsupported type for column " + column.Name)
+}
+
+// filterValue converts a filter value to the representation the scan
+// compares with, which is what DeserializeRecord produces for the column.
+func filterValue(column storage.Column, value *pb.Value) (any, error) {
+	if column.Type == storage.TypeJSON {
+		return nil, fmt.Errorf("column %s: filtering on json columns is not supported", column.Name)
+	}
+
+	item, err := toItem(column, value)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := item.Literal.(int); ok {
+		return int64(n), nil
+	}
+	return item.Literal, nil
+}
+
+// toValue converts a value as returned by TableI.GetAllData.
+func toValue(column storage.Column, value any) (*pb.Value, error) {
+	if value == nil {
+		return &pb.Value{Kind: &pb.Value_NullValue{}}, nil
+	}
+
+	switch column.Type {
+	case storage.TypeInt:
+		if n, ok := value.(int64); ok {
+			return &pb.Value{Kind: &pb.Value_IntValue{IntValue: n}}, nil
+		}
+	case storage.TypeFloat:
+		if f, ok := value.(float64); ok {
+			return &pb.Value{Kind: &pb.Value_FloatValue{FloatValue: f}}, nil
+		}
+	case storage.TypeVarchar:
+		if s, ok := value.(string); ok {
+			return &pb.Value{Kind: &pb.Value_VarcharValue{VarcharValue: s}}, nil
+		}
+	case storage.TypeDate:
+		if s, ok := value.(string); ok {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				return nil, err
+			}
+			return &pb.Value{Kind: &pb.Value_DateValue{DateValue: int32(t.Unix() / 86400)}}, nil
+		}
+	case storage.TypeTimestamp:
+		if s, ok := value.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, err
+			}
+			return &pb.Value{Kind: &pb.Value_TimestampValue{TimestampValue: timestamppb.New(t)}}, nil
+		}
+	case storage.TypeJSON:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Value{Kind: &pb.Value_JsonValue{JsonValue: string(b)}}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected value %v for column %s", value, column.Name)
+}
+
+func dateString(days int32) string {
+	return time.Unix(int64(days)*86400, 0).UTC().Format("2006-01-02")
+}
@@ -0,0 +1,220 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"rdbms/api/auth"
+	"rdbms/api/grpcserver/pb"
+	"rdbms/src"
+)
+
+// newServer serves a fresh data directory requiring credentials, and
+// returns a client of it.
+func newServer(t *testing.T) (pb.StorageClient, *auth.Authenticator) {
+	t.Helper()
+
+	dir := t.TempDir()
+	stg, err := src.NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stg.Close() })
+
+	keys, err := auth.OpenKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := auth.OpenGrantStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := auth.OpenPolicyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := auth.NewAuthenticator(keys, grants, policies, nil)
+
+	l := bufconn.Listen(1 << 20)
+	s := NewGRPCServer(stg, a)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return pb.NewStorageClient(cc), a
+}
+
+// newKey returns a context calling as a new API key of user.
+func newKey(t *testing.T, a *auth.Authenticator, user string) context.Context {
+	t.Helper()
+
+	_, key, err := a.Keys.Create(auth.APIKey{User: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
+func query(ctx context.Context, c pb.StorageClient, req *pb.QueryRequest) ([]*pb.Row, error) {
+	stream, err := c.Query(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*pb.Row
+	for {
+		row, err := stream.Recv()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	c, a := newServer(t)
+	if err := a.Grants.GrantRole(auth.SuperuserRole, "root"); err != nil {
+		t.Fatal(err)
+	}
+	root := newKey(t, a, "root")
+
+	create := &pb.CreateTableRequest{Name: "t", Columns: []*pb.Column{{Name: "id", Type: pb.ColumnType_COLUMN_TYPE_INT}}}
+	for name, ctx := range map[string]context.Context{
+		"no credentials":  context.Background(),
+		"invalid key":     metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "rk_nope_nope"),
+		"invalid bearer":  metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer nope"),
+		"wrong auth type": metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic cm9vdA=="),
+	} {
+		if _, err := c.CreateTable(ctx, create); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("CreateTable with %s: got %v, want Unauthenticated", name, err)
+		}
+		if _, err := query(ctx, c, &pb.QueryRequest{Table: "t"}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Query with %s: got %v, want Unauthenticated", name, err)
+		}
+	}
+
+	if _, err := c.CreateTable(root, create); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := query(root, c, &pb.QueryRequest{Table: "t"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGrants(t *testing.T) {
+	c, a := newServer(t)
+	if err := a.Grants.GrantRole(auth.SuperuserRole, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grants.Grant("reader", "t", []auth.Privilege{auth.Select}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grants.GrantRole("reader", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	root, alice, bob := newKey(t, a, "root"), newKey(t, a, "alice"), newKey(t, a, "bob")
+
+	create := &pb.CreateTableRequest{Name: "t", Columns: []*pb.Column{{Name: "id", Type: pb.ColumnType_COLUMN_TYPE_INT}}}
+	if _, err := c.CreateTable(root, create); err != nil {
+		t.Fatal(err)
+	}
+	insert := &pb.InsertRequest{Table: "t", Values: map[string]*pb.Value{"id": {Kind: &pb.Value_IntValue{IntValue: 1}}}}
+	if _, err := c.Insert(root, insert); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice reads through the reader role, bob holds no grant at all
+	if rows, err := query(alice, c, &pb.QueryRequest{Table: "t"}); err != nil || len(rows) != 1 {
+		t.Fatalf("Query as alice: got %d rows, %v", len(rows), err)
+	}
+	if _, err := c.Insert(alice, insert); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Insert as alice: got %v, want PermissionDenied", err)
+	}
+	if _, err := c.CreateTable(alice, &pb.CreateTableRequest{Name: "u", Columns: create.Columns}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateTable as alice: got %v, want PermissionDenied", err)
+	}
+	if _, err := query(bob, c, &pb.QueryRequest{Table: "t"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Query as bob: got %v, want PermissionDenied", err)
+	}
+	if _, err := c.GetTableSchema(bob, &pb.GetTableSchemaRequest{Name: "t"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetTableSchema as bob: got %v, want PermissionDenied", err)
+	}
+
+	// revoking the role takes effect on the next call
+	if err := a.Grants.RevokeRole("reader", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := query(alice, c, &pb.QueryRequest{Table: "t"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Query as alice without the role: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	c, a := newServer(t)
+	if err := a.Grants.GrantRole(auth.SuperuserRole, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grants.Grant("alice", "t", auth.AllPrivileges, nil); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := auth.ParsePolicy("tenant_isolation", "tenant = $caller.tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Policies.Create("t", policy); err != nil {
+		t.Fatal(err)
+	}
+	root := newKey(t, a, "root")
+	_, key, err := a.Keys.Create(auth.APIKey{User: "alice", Attributes: map[string]string{"tenant": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+
+	create := &pb.CreateTableRequest{Name: "t", Columns: []*pb.Column{{Name: "tenant", Type: pb.ColumnType_COLUMN_TYPE_VARCHAR, Length: 8}}}
+	if _, err := c.CreateTable(root, create); err != nil {
+		t.Fatal(err)
+	}
+	insert := func(tenant string) *pb.InsertRequest {
+		return &pb.InsertRequest{Table: "t", Values: map[string]*pb.Value{"tenant": {Kind: &pb.Value_VarcharValue{VarcharValue: tenant}}}}
+	}
+	for _, tenant := range []string{"a", "b", "b"} {
+		if _, err := c.Insert(root, insert(tenant)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := query(alice, c, &pb.QueryRequest{Table: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].GetValues()[0].GetVarcharValue() != "a" {
+		t.Errorf("Query as alice: got %v, want the row of tenant a only", rows)
+	}
+	if _, err := c.Insert(alice, insert("b")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Insert of another tenant as alice: got %v, want PermissionDenied", err)
+	}
+	if _, err := c.Insert(alice, insert("a")); err != nil {
+		t.Errorf("Insert of own tenant as alice: %v", err)
+	}
+	if rows, err := query(root, c, &pb.QueryRequest{Table: "t"}); err != nil || len(rows) != 4 {
+		t.Errorf("Query as root: got %d rows, %v, want every row", len(rows), err)
+	}
+}
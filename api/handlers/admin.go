@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"rdbms/api/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) InspectPage(c *gin.Context) {
+	pageNo, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, "page must be a number")
+		return
+	}
+	withHex := c.Query("hex") == "true"
+
+	inspection, err := h.table(c).InspectPage(c.Param("name"), pageNo, withHex)
+	if err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, inspection)
+}
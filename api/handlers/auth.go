@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"rdbms/api/auth"
+	"rdbms/api/http"
+	"rdbms/api/models"
+	"rdbms/src/storage"
+	"rdbms/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) WhoAmI(c *gin.Context) {
+	principal, _ := auth.FromContext(c.Request.Context())
+	h.handleResponse(c, http.OK, principal)
+}
+
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	key, secret, err := h.Auth.Keys.Create(auth.APIKey{
+		Name:         req.Name,
+		User:         req.User,
+		Attributes:   req.Attributes,
+		TrustHeaders: req.TrustHeaders,
+	})
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.Created, models.CreateAPIKeyResponse{APIKey: key, Key: secret})
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	keys, err := h.Auth.Keys.List()
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.OK, keys)
+}
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	key, err := h.Auth.Keys.Revoke(c.Param("id"))
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		h.handleResponse(c, http.NOT_FOUND, err.Error())
+		return
+	}
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.OK, key)
+}
+
+func (h *Handler) ListGrants(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	grantees, err := h.Auth.Grants.Grantees()
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.OK, grantees)
+}
+
+func (h *Handler) Grant(c *gin.Context) {
+	h.changeGrant(c, h.Auth.Grants.Grant, "Privileges granted")
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	h.changeGrant(c, h.Auth.Grants.Revoke, "Privileges revoked")
+}
+
+func (h *Handler) changeGrant(c *gin.Context, change func(grantee, table string, privileges []auth.Privilege, columns []string) error, message string) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	var req models.GrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	privileges, err := auth.ParsePrivileges(req.Privileges)
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	if err := change(req.Grantee, req.Table, privileges, req.Columns); err != nil {
+		h.handleGrantError(c, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, message)
+}
+
+func (h *Handler) GrantRole(c *gin.Context) {
+	h.changeRole(c, h.Auth.Grants.GrantRole, "Role granted")
+}
+
+func (h *Handler) RevokeRole(c *gin.Context) {
+	h.changeRole(c, h.Auth.Grants.RevokeRole, "Role revoked")
+}
+
+func (h *Handler) changeRole(c *gin.Context, change func(role, member string) error, message string) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	var req models.RoleGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	if err := change(req.Role, req.Member); err != nil {
+		h.handleGrantError(c, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, message)
+}
+
+func (h *Handler) handleGrantError(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrNotGranted) {
+		h.handleResponse(c, http.NOT_FOUND, err.Error())
+		return
+	}
+	h.handleResponse(c, http.InvalidArgument, err.Error())
+}
+
+func (h *Handler) ListPolicies(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	policies, err := h.Auth.Policies.All()
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.OK, policies)
+}
+
+func (h *Handler) CreatePolicy(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	var req models.CreatePolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	policy, err := auth.ParsePolicy(req.Name, req.Predicate)
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	schema, err := h.Stg.Table().GetTableSchema(req.Table + ".schema")
+	if err != nil {
+		h.handleResponse(c, http.NOT_FOUND, err.Error())
+		return
+	}
+	column, ok := schemaColumn(schema, policy.Column)
+	if !ok {
+		h.handleResponse(c, http.InvalidArgument, "column "+policy.Column+" does not exist")
+		return
+	}
+	if column.Type == storage.TypeJSON {
+		h.handleResponse(c, http.InvalidArgument, "policies can not filter on json columns")
+		return
+	}
+	if !strings.HasPrefix(policy.Value, auth.CallerPrefix) {
+		if _, err := utils.ParseTextValue(column, policy.Value); err != nil {
+			h.handleResponse(c, http.InvalidArgument, err.Error())
+			return
+		}
+	}
+
+	if err := h.Auth.Policies.Create(req.Table, policy); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.Created, policy)
+}
+
+func (h *Handler) DropPolicy(c *gin.Context) {
+	if !h.authorizeSuperuser(c) {
+		return
+	}
+
+	err := h.Auth.Policies.Drop(c.Param("table"), c.Param("policy"))
+	if errors.Is(err, auth.ErrPolicyNotFound) {
+		h.handleResponse(c, http.NOT_FOUND, err.Error())
+		return
+	}
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Policy dropped")
+}
+
+func schemaColumn(schema storage.Schema, name string) (storage.Column, bool) {
+	for _, column := range schema.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return storage.Column{}, false
+}
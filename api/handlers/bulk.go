@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"rdbms/api/http"
+	"rdbms/api/models"
+	"rdbms/src/storage"
+	"rdbms/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bulkInsertBatchSize is the number of rows handed to InsertBatch at once,
+// which bounds the memory a large request holds.
+const bulkInsertBatchSize = 10000
+
+// BulkInsertRecords inserts the rows of the request body into the table
+// given by the `name` query parameter. The body is either a JSON array of
+// row objects or NDJSON with one row object per line. Rows that fail
+// validation or can not be stored are reported and the rest are inserted.
+func (h *Handler) BulkInsertRecords(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		h.handleResponse(c, http.BadRequest, "name query parameter is required")
+		return
+	}
+
+	schema, err := h.table(c).GetTableSchema(name + ".schema")
+	if err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	result := models.BulkInsertResponse{Rejected: []models.RejectedRow{}}
+	records := make([]storage.Record, 0, bulkInsertBatchSize)
+	rows := make([]int, 0, bulkInsertBatchSize)
+
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		rejected, err := h.table(c).InsertBatch(name, records)
+		if err != nil {
+			return err
+		}
+		for i, row := range rows {
+			if err, ok := rejected[i]; ok {
+				result.Rejected = append(result.Rejected, models.RejectedRow{Row: row, Error: err.Error()})
+			}
+		}
+		result.Inserted += len(records) - len(rejected)
+		records, rows = records[:0], rows[:0]
+		return nil
+	}
+
+	err = decodeRows(c.Request.Body, func(row int, values map[string]any) error {
+		if values == nil {
+			result.Rejected = append(result.Rejected, models.RejectedRow{Row: row, Error: "row is not a JSON object"})
+			return nil
+		}
+
+		record, err := utils.ToStorageRecord(schema, values)
+		if err != nil {
+			result.Rejected = append(result.Rejected, models.RejectedRow{Row: row, Error: err.Error()})
+			return nil
+		}
+
+		records = append(records, record)
+		rows = append(rows, row)
+		if len(records) == bulkInsertBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	var malformed *malformedBodyError
+	if errors.As(err, &malformed) {
+		h.handleResponse(c, http.BadRequest, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+	if err != nil {
+		h.handleStorageError(c, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, result)
+}
+
+type malformedBodyError struct {
+	row int
+	err error
+}
+
+func (e *malformedBodyError) Error() string {
+	return fmt.Sprintf("malformed body at row %d: %v", e.row, e.err)
+}
+
+// decodeRows calls fn for every row object of a JSON array or NDJSON body,
+// numbering rows from 1. Rows that are valid JSON but not objects are passed
+// to fn as nil so they can be rejected individually; malformed JSON ends
+// decoding.
+func decodeRows(body io.Reader, fn func(row int, values map[string]any) error) error {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(reader)
+	isArray := first == '['
+	if isArray {
+		if _, err := dec.Token(); err != nil {
+			return &malformedBodyError{row: 1, err: err}
+		}
+	}
+
+	row := 1
+	for ; ; row++ {
+		if isArray && !dec.More() {
+			break
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF && !isArray {
+			break
+		} else if err != nil {
+			return &malformedBodyError{row: row, err: err}
+		}
+
+		var values map[string]any
+		if json.Unmarshal(raw, &values) != nil {
+			values = nil
+		}
+		if err := fn(row, values); err != nil {
+			return err
+		}
+	}
+
+	if isArray {
+		if _, err := dec.Token(); err != nil {
+			return &malformedBodyError{row: row, err: err}
+		}
+	}
+	return nil
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, reader.UnreadByte()
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"rdbms/api/auth"
+	"rdbms/api/http"
+	"rdbms/api/models"
+	"rdbms/src/dataio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportCSV inserts the CSV request body into the table given by the
+// `name` query parameter. The `delimiter` and `quote` query parameters
+// override the CSV dialect.
+func (h *Handler) ImportCSV(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		h.handleResponse(c, http.BadRequest, "name query parameter is required")
+		return
+	}
+
+	opts, err := dataio.ParseCSVOptions(c.Query("delimiter"), c.Query("quote"))
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	if _, err := h.table(c).GetTableSchema(name + ".schema"); err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	var rejects bytes.Buffer
+	result, err := dataio.ImportCSV(h.table(c), name, c.Request.Body, opts, &rejects)
+	response := models.CSVImportResponse{Inserted: result.Inserted, Rejected: result.Rejected}
+	if result.Rejected > 0 {
+		response.Rejects = rejects.String()
+	}
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		h.handleResponse(c, http.Forbidden, gin.H{"error": err.Error(), "result": response})
+		return
+	}
+	if err != nil {
+		h.handleResponse(c, http.BadRequest, gin.H{"error": err.Error(), "result": response})
+		return
+	}
+
+	h.handleResponse(c, http.OK, response)
+}
+
+// ExportCSV runs a query like GetAllRecords and returns the result as a
+// CSV file. Without `select` every column is exported.
+func (h *Handler) ExportCSV(c *gin.Context) {
+	var req models.GetAllRecordsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	opts, err := dataio.ParseCSVOptions(c.Query("delimiter"), c.Query("quote"))
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	columns, data, ok := h.queryForExport(c, req)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := dataio.ExportCSV(&buf, columns, data, opts); err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+req.Name+`.csv"`)
+	c.Data(http.OK.Code, "text/csv; charset=utf-8", buf.Bytes())
+}
@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"errors"
+	"rdbms/api/auth"
+	"rdbms/api/authz"
 	"rdbms/api/http"
 	"rdbms/src"
+	"rdbms/src/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
 	Stg src.StorageI
+	// Auth, when set, requires credentials on every request and enables the
+	// API key endpoints.
+	Auth *auth.Authenticator
 }
 
 func NewHandler(stg src.StorageI) Handler {
@@ -24,3 +31,55 @@ func (h *Handler) handleResponse(c *gin.Context, status http.Status, data interf
 		Data:        data,
 	})
 }
+
+// handleStorageError reports a failed storage call, singling out pages that
+// failed their integrity check and rows hidden by row-level security.
+func (h *Handler) handleStorageError(c *gin.Context, err error) {
+	var corrupt *storage.CorruptPageError
+	if errors.As(err, &corrupt) {
+		h.handleResponse(c, http.DataCorrupted, corrupt)
+		return
+	}
+	if errors.Is(err, auth.ErrPermissionDenied) || errors.Is(err, auth.ErrPolicyViolation) {
+		h.handleResponse(c, http.Forbidden, err.Error())
+		return
+	}
+
+	h.handleResponse(c, http.InternalServerError, err.Error())
+}
+
+// table returns the tables the handlers read and write. With Auth, the
+// grants and row-level security policies of the caller apply to them.
+func (h *Handler) table(c *gin.Context) storage.TableI {
+	principal, _ := auth.FromContext(c.Request.Context())
+	return authz.Table(h.Stg.Table(), h.Auth, principal)
+}
+
+// handleTableError reports a failed table call with status, unless the
+// caller was refused it.
+func (h *Handler) handleTableError(c *gin.Context, status http.Status, err error) {
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		h.handleResponse(c, http.Forbidden, err.Error())
+		return
+	}
+	h.handleResponse(c, status, err.Error())
+}
+
+// authorizeSuperuser checks that the caller is a superuser.
+func (h *Handler) authorizeSuperuser(c *gin.Context) bool {
+	if h.Auth == nil {
+		return true
+	}
+
+	principal, _ := auth.FromContext(c.Request.Context())
+	ok, err := h.Auth.Grants.IsSuperuser(principal)
+	if err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return false
+	}
+	if !ok {
+		h.handleResponse(c, http.Forbidden, "permission denied: superuser required")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"rdbms/api/auth"
+	"rdbms/api/http"
+	"rdbms/api/models"
+	"rdbms/src/dataio"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportParquet runs a query like GetAllRecords and returns the result as
+// a Parquet file. The `row_group_size` query parameter sets the number of
+// rows per row group.
+func (h *Handler) ExportParquet(c *gin.Context) {
+	var req models.GetAllRecordsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	rowGroupSize := dataio.DefaultRowGroupSize
+	if s := c.Query("row_group_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			h.handleResponse(c, http.InvalidArgument, "row_group_size must be a positive integer")
+			return
+		}
+		rowGroupSize = n
+	}
+
+	columns, data, ok := h.queryForExport(c, req)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := dataio.ExportParquet(&buf, columns, data, rowGroupSize); err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+req.Name+`.parquet"`)
+	c.Data(http.OK.Code, "application/vnd.apache.parquet", buf.Bytes())
+}
+
+// ImportParquet inserts the rows of the Parquet request body into the
+// table given by the `name` query parameter. With `create=true` a missing
+// table is created with a schema inferred from the file; that needs the
+// CREATE privilege on it instead of INSERT.
+func (h *Handler) ImportParquet(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		h.handleResponse(c, http.BadRequest, "name query parameter is required")
+		return
+	}
+	create := c.Query("create") == "true"
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	result, err := dataio.ImportParquet(h.table(c), name, bytes.NewReader(body), create)
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		h.handleResponse(c, http.Forbidden, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+	if err != nil {
+		h.handleResponse(c, http.BadRequest, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+
+	h.handleResponse(c, http.OK, result)
+}
@@ -1,12 +1,12 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
 	"rdbms/api/http"
 	"rdbms/api/models"
+	"rdbms/src/dataio"
 	"rdbms/src/storage"
 	"rdbms/utils"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,80 +18,20 @@ func (h *Handler) InsertRecord(c *gin.Context) {
 		return
 	}
 
-	schema, err := h.Stg.Table().GetTableSchema(req.Name + ".schema")
+	schema, err := h.table(c).GetTableSchema(req.Name + ".schema")
 	if err != nil {
-		h.handleResponse(c, http.NOT_FOUND, err.Error())
+		h.handleTableError(c, http.NOT_FOUND, err)
 		return
 	}
 
-	items := make([]storage.Item, 0, len(schema.Columns))
-	for _, col := range schema.Columns {
-		v, ok := req.Values[col.Name]
-		if !ok {
-			h.handleResponse(c, http.InvalidArgument, "missing column: "+col.Name)
-			return
-		}
-
-		switch col.Type {
-		case storage.TypeInt:
-			n, ok := v.(float64)
-			if !ok || n != float64(int(n)) {
-				h.handleResponse(c, http.InvalidArgument, "column "+col.Name+" must be integer")
-				return
-			}
-			items = append(items, storage.Item{Literal: int(n)})
-		case storage.TypeVarchar:
-			s, ok := v.(string)
-			if !ok {
-				h.handleResponse(c, http.InvalidArgument, "column "+col.Name+" must be string")
-				return
-			}
-			if len(s) > col.Length {
-				h.handleResponse(c, http.InvalidArgument, "column "+col.Name+" exceeds length")
-				return
-			}
-			items = append(items, storage.Item{Literal: s})
-		case storage.TypeFloat:
-			f, ok := v.(float64)
-			if !ok {
-				h.handleResponse(c, http.InvalidArgument, "column "+col.Name+" must be number")
-				return
-			}
-			items = append(items, storage.Item{Literal: f})
-		case storage.TypeJSON:
-			b, err := json.Marshal(v)
-			if err != nil {
-				h.handleResponse(c, http.InvalidArgument, "invalid json for "+col.Name)
-				return
-			}
-			items = append(items, storage.Item{Literal: string(b)})
-		case storage.TypeDate:
-			s, ok := v.(string)
-			if !ok {
-				h.handleResponse(c, http.InvalidArgument, "column "+col.Name+" must be date string YYYY-MM-DD")
-				return
-			}
-			if _, err := time.Parse("2006-01-02", s); err != nil {
-				h.handleResponse(c, http.InvalidArgument, "invalid date format for "+col.Name)
-				return
-			}
-			items = append(items, storage.Item{Literal: s})
-		case storage.TypeTimestamp:
-			s, ok := v.(string)
-			if !ok {
-				h.handleResponse(c, http.InvalidArgument, "column "+col.Name+" must be RFC3339 timestamp string")
-				return
-			}
-			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
-				h.handleResponse(c, http.InvalidArgument, "invalid timestamp format for "+col.Name)
-				return
-			}
-			items = append(items, storage.Item{Literal: s})
-		}
+	record, err := utils.ToStorageRecord(schema, req.Values)
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
 	}
 
-	if err := h.Stg.Table().Insert(req.Name, storage.Record{Items: items}); err != nil {
-		h.handleResponse(c, http.InternalServerError, err.Error())
+	if err := h.table(c).Insert(req.Name, record); err != nil {
+		h.handleStorageError(c, err)
 		return
 	}
 
@@ -105,14 +45,43 @@ func (h *Handler) GetAllRecords(c *gin.Context) {
 		return
 	}
 
-	schema, err := h.Stg.Table().GetTableSchema(req.Name + ".schema")
-	if err != nil {
-		h.handleResponse(c, http.NOT_FOUND, err.Error())
+	if c.NegotiateFormat(gin.MIMEJSON, ArrowStreamMIME) == ArrowStreamMIME {
+		h.writeArrowStream(c, req)
+		return
+	}
+
+	_, data, ok := h.queryForExport(c, req)
+	if !ok {
+		return
+	}
+
+	h.handleResponse(c, http.OK, data)
+}
+
+// ArrowStreamMIME is the Accept header value that makes GetAllRecords
+// answer with an Arrow IPC stream instead of JSON.
+const ArrowStreamMIME = "application/vnd.apache.arrow.stream"
+
+// writeArrowStream answers a query with the rows as Arrow record batches.
+// Without `select` every column is returned.
+func (h *Handler) writeArrowStream(c *gin.Context, req models.GetAllRecordsRequest) {
+	columns, data, ok := h.queryForExport(c, req)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := dataio.WriteArrowStream(&buf, columns, data); err != nil {
+		h.handleResponse(c, http.InternalServerError, err.Error())
 		return
 	}
 
-	filters := make([]storage.Filter, 0, len(req.Filter))
-	for _, f := range req.Filter {
+	c.Data(http.OK.Code, ArrowStreamMIME, buf.Bytes())
+}
+
+func toStorageFilters(schema storage.Schema, items []models.FilterRequestItem) ([]storage.Filter, error) {
+	filters := make([]storage.Filter, 0, len(items))
+	for _, f := range items {
 		filters = append(filters, storage.Filter{
 			Column:   f.Column,
 			Operator: f.Operator,
@@ -120,17 +89,40 @@ func (h *Handler) GetAllRecords(c *gin.Context) {
 		})
 	}
 
-	filters, err = utils.SetFilterColumnIndexes(schema, filters)
+	return utils.SetFilterColumnIndexes(schema, filters)
+}
+
+// queryForExport runs the query of a request and returns the selected
+// columns in order with the rows. Without `select` every column is
+// returned. On failure the response is written and ok is false.
+func (h *Handler) queryForExport(c *gin.Context, req models.GetAllRecordsRequest) (columns []storage.Column, data []map[string]any, ok bool) {
+	schema, err := h.table(c).GetTableSchema(req.Name + ".schema")
+	if err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return nil, nil, false
+	}
+
+	columns, err = dataio.ExportColumns(schema, req.Columns)
 	if err != nil {
 		h.handleResponse(c, http.InvalidArgument, err.Error())
-		return
+		return nil, nil, false
 	}
-	selectedColumns := storage.SelectedColumns{Columns: req.Columns}
-	data, err := h.Stg.Table().GetAllData(req.Name, filters, selectedColumns)
+
+	filters, err := toStorageFilters(schema, req.Filter)
 	if err != nil {
-		h.handleResponse(c, http.InternalServerError, err.Error())
-		return
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return nil, nil, false
 	}
 
-	h.handleResponse(c, http.OK, data)
+	selected := storage.SelectedColumns{}
+	for _, column := range columns {
+		selected.Columns = append(selected.Columns, column.Name)
+	}
+	data, err = h.table(c).GetAllData(req.Name, filters, selected)
+	if err != nil {
+		h.handleStorageError(c, err)
+		return nil, nil, false
+	}
+
+	return columns, data, true
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"rdbms/api/http"
 	"rdbms/api/models"
+	"rdbms/src/storage"
 	"rdbms/utils"
 
 	"github.com/gin-gonic/gin"
@@ -21,10 +22,152 @@ func (h *Handler) CreateTable(c *gin.Context) {
 		return
 	}
 
-	if err := h.Stg.Table().CreateTable(req.Name, &schema); err != nil {
-		h.handleResponse(c, http.BadRequest, err.Error())
+	if err := h.table(c).CreateTable(req.Name, &schema); err != nil {
+		h.handleTableError(c, http.BadRequest, err)
 		return
 	}
 
 	h.handleResponse(c, http.Created, "Table created successfully!")
 }
+
+func (h *Handler) ListTables(c *gin.Context) {
+	h.handleResponse(c, http.OK, h.table(c).ListTables())
+}
+
+func (h *Handler) GetTable(c *gin.Context) {
+	info, err := h.table(c).DescribeTable(c.Param("name"))
+	if err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, info)
+}
+
+func (h *Handler) DropTable(c *gin.Context) {
+	if err := h.table(c).DropTable(c.Param("name")); err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Table dropped successfully!")
+}
+
+func (h *Handler) TruncateTable(c *gin.Context) {
+	if err := h.table(c).Truncate(c.Param("name")); err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Table truncated successfully!")
+}
+
+func (h *Handler) AddColumn(c *gin.Context) {
+	var req models.AddColumnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	column, err := utils.ToStorageColumn(models.CreateColumn{Name: req.Name, Type: req.Type, Length: req.Length})
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	if req.Default != nil {
+		if _, err := utils.ToStorageItem(column, req.Default); err != nil {
+			h.handleResponse(c, http.InvalidArgument, "invalid default: "+err.Error())
+			return
+		}
+		column.Default = req.Default
+	}
+
+	if err := h.table(c).AddColumn(c.Param("name"), column); err != nil {
+		h.handleTableError(c, http.BadRequest, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Column added successfully!")
+}
+
+func (h *Handler) DropColumn(c *gin.Context) {
+	var req models.DropColumnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	if err := h.table(c).DropColumn(c.Param("name"), req.Column); err != nil {
+		h.handleTableError(c, http.BadRequest, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Column dropped successfully!")
+}
+
+func (h *Handler) RenameColumn(c *gin.Context) {
+	var req models.RenameColumnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	if err := h.table(c).RenameColumn(c.Param("name"), req.Column, req.NewName); err != nil {
+		h.handleTableError(c, http.BadRequest, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Column renamed successfully!")
+}
+
+func (h *Handler) AlterColumnType(c *gin.Context) {
+	var req models.AlterColumnTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+
+	column, err := utils.ToStorageColumn(models.CreateColumn{Name: req.Column, Type: req.Type, Length: req.Length})
+	if err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	job, err := h.table(c).AlterColumnType(c.Param("name"), req.Column, column.Type, column.Length)
+	if err != nil {
+		h.handleTableError(c, http.BadRequest, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, job)
+}
+
+func (h *Handler) GetAlterColumnTypeProgress(c *gin.Context) {
+	job, err := h.table(c).GetRewriteJob(c.Param("name"))
+	if err != nil {
+		h.handleTableError(c, http.NOT_FOUND, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, job)
+}
+
+func (h *Handler) RenameTable(c *gin.Context) {
+	var req models.RenameTableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.handleResponse(c, http.BadRequest, err.Error())
+		return
+	}
+	if err := storage.ValidateTableName(req.NewName); err != nil {
+		h.handleResponse(c, http.InvalidArgument, err.Error())
+		return
+	}
+
+	if err := h.table(c).RenameTable(c.Param("name"), req.NewName); err != nil {
+		h.handleTableError(c, http.BadRequest, err)
+		return
+	}
+
+	h.handleResponse(c, http.OK, "Table renamed successfully!")
+}
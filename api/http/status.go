@@ -46,12 +46,17 @@ var (
 	Unauthorized = Status{
 		Code:        401,
 		Status:      "UNAUTHORIZED",
-		Description: "...",
+		Description: "The request lacks valid authentication credentials",
 	}
 	Forbidden = Status{
 		Code:        403,
 		Status:      "FORBIDDEN",
-		Description: "...",
+		Description: "The caller does not have the privileges the request needs",
+	}
+	RequestEntityTooLarge = Status{
+		Code:        413,
+		Status:      "REQUEST_ENTITY_TOO_LARGE",
+		Description: "The request body is larger than the server accepts",
 	}
 	TooManyRequests = Status{
 		Code:        429,
@@ -63,6 +68,11 @@ var (
 		Status:      "INTERNAL_SERVER_ERROR",
 		Description: "The server encountered an unexpected condition that prevented it from fulfilling the request",
 	}
+	DataCorrupted = Status{
+		Code:        500,
+		Status:      "DATA_CORRUPTED",
+		Description: "Stored data failed an integrity check",
+	}
 	GRPCError = Status{
 		Code:        500,
 		Status:      "GRPC_ERROR",
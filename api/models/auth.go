@@ -0,0 +1,39 @@
+package models
+
+import "rdbms/api/auth"
+
+type CreateAPIKeyRequest struct {
+	Name         string            `json:"name"`
+	User         string            `json:"user" binding:"required"`
+	Attributes   map[string]string `json:"attributes"`
+	TrustHeaders bool              `json:"trust_headers"`
+}
+
+// CreateAPIKeyResponse carries the secret key, which is shown only once.
+type CreateAPIKeyResponse struct {
+	auth.APIKey
+	Key string `json:"key"`
+}
+
+// GrantRequest grants or revokes privileges on a table, "*" for every
+// table. Columns restrict SELECT to those columns.
+type GrantRequest struct {
+	Grantee    string   `json:"grantee" binding:"required"`
+	Table      string   `json:"table" binding:"required"`
+	Privileges []string `json:"privileges" binding:"required"`
+	Columns    []string `json:"columns"`
+}
+
+type RoleGrantRequest struct {
+	Role   string `json:"role" binding:"required"`
+	Member string `json:"member" binding:"required"`
+}
+
+// CreatePolicyRequest adds a row-level security policy to a table. The
+// predicate compares a column with a literal or an attribute of the
+// caller, e.g. `tenant_id = $caller.tenant`.
+type CreatePolicyRequest struct {
+	Table     string `json:"table" binding:"required"`
+	Name      string `json:"name" binding:"required"`
+	Predicate string `json:"predicate" binding:"required"`
+}
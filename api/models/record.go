@@ -16,3 +16,23 @@ type FilterRequestItem struct {
 	Operator string `json:"operator" binding:"required"`
 	Value    any    `json:"value" binding:"required"`
 }
+
+type BulkInsertResponse struct {
+	Inserted int           `json:"inserted"`
+	Rejected []RejectedRow `json:"rejected"`
+}
+
+// RejectedRow reports a row of a bulk insert that was not stored. Row is
+// the 1-based position of the row in the request body.
+type RejectedRow struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type CSVImportResponse struct {
+	Inserted int `json:"inserted"`
+	Rejected int `json:"rejected"`
+	// Rejects is the reject file: the rejected rows as CSV with the reason
+	// in an extra last column.
+	Rejects string `json:"rejects,omitempty"`
+}
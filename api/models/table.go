@@ -7,6 +7,32 @@ type CreateTableRequest struct {
 
 type CreateColumn struct {
 	Name   string `json:"name" binding:"required"`
-	Type   int    `json:"type" binding:"required"`
+	Type   int    `json:"type"`
 	Length *int   `json:"length,omitempty"`
 }
+
+type AddColumnRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Type    int    `json:"type"`
+	Length  *int   `json:"length,omitempty"`
+	Default any    `json:"default"`
+}
+
+type DropColumnRequest struct {
+	Column string `json:"column" binding:"required"`
+}
+
+type RenameColumnRequest struct {
+	Column  string `json:"column" binding:"required"`
+	NewName string `json:"new_name" binding:"required"`
+}
+
+type AlterColumnTypeRequest struct {
+	Column string `json:"column" binding:"required"`
+	Type   int    `json:"type"`
+	Length *int   `json:"length,omitempty"`
+}
+
+type RenameTableRequest struct {
+	NewName string `json:"new_name" binding:"required"`
+}
@@ -0,0 +1,813 @@
+// Package pgwire serves the storage engine over the PostgreSQL v3 frontend/
+// backend protocol, so psql, JDBC tools and drivers like pgx can connect.
+// Statements are handled by the query package and every statement runs on
+// its own, without transactions.
+//
+// With Auth set, clients log in with an API key or JWT as the password of
+// the user it belongs to, as in `psql "host=localhost user=app password=rk_..."`.
+// The password travels in clear text, since the server does not speak TLS:
+// listen on localhost or behind a TLS-terminating proxy.
+package pgwire
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rdbms/api/auth"
+	"rdbms/api/authz"
+	"rdbms/src"
+	"rdbms/src/query"
+	"rdbms/src/storage"
+)
+
+const (
+	protocolVersion = 196608
+	sslRequestCode  = 80877103
+	gssRequestCode  = 80877104
+	cancelCode      = 80877102
+
+	authCleartextPassword     = 3
+	codeInvalidPassword       = "28P01"
+	codeInsufficientPrivilege = "42501"
+
+	// DefaultMaxMessageSize bounds the length a client may announce for a
+	// message when MaxMessageSize is zero.
+	DefaultMaxMessageSize = 64 << 20
+)
+
+// Server accepts PostgreSQL protocol connections.
+type Server struct {
+	Stg src.StorageI
+	// Auth, when set, requires a password: an API key or JWT of the user.
+	Auth *auth.Authenticator
+	// ReadBufferSize and WriteBufferSize size the buffers of connections;
+	// zero means the bufio default.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// MaxMessageSize is the longest message accepted from clients, zero
+	// meaning DefaultMaxMessageSize.
+	MaxMessageSize int
+
+	mu           sync.Mutex
+	listeners    map[net.Listener]struct{}
+	conns        map[*conn]struct{}
+	shuttingDown atomic.Bool
+	nextPID      atomic.Uint32
+}
+
+func NewServer(stg src.StorageI) *Server {
+	return &Server{Stg: stg, listeners: make(map[net.Listener]struct{}), conns: make(map[*conn]struct{})}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		c := &conn{
+			srv:        s,
+			nc:         nc,
+			r:          bufio.NewReaderSize(nc, bufferSize(s.ReadBufferSize)),
+			w:          bufio.NewWriterSize(nc, bufferSize(s.WriteBufferSize)),
+			pid:        s.nextPID.Add(1),
+			statements: make(map[string]*prepared),
+			portals:    make(map[string]*portal),
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Close stops accepting connections. Connections already open are served
+// until the client leaves; see Shutdown.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and closes those waiting for a
+// message. The others are closed once their current message is handled.
+// When ctx ends first, the remaining connections are closed at once and
+// ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	err := s.Close()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for c := range s.conns {
+				c.nc.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes the connections waiting for a message and reports
+// whether none are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if !c.busy.Load() {
+			c.nc.Close()
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) maxMessageSize() int {
+	if s.MaxMessageSize > 0 {
+		return s.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+func bufferSize(size int) int {
+	if size > 0 {
+		return size
+	}
+	return 4096
+}
+
+type prepared struct {
+	stmt      query.Statement
+	paramOIDs []uint32
+	params    []storage.Column
+	columns   []storage.Column
+}
+
+type portal struct {
+	prepared *prepared
+	params   []*string
+	formats  []int16
+	result   *query.Result
+	sent     int
+}
+
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	r   *bufio.Reader
+	w   *bufio.Writer
+	pid uint32
+
+	user string
+	// principal is the authenticated caller, nil without Auth.
+	principal  *auth.Principal
+	statements map[string]*prepared
+	portals    map[string]*portal
+	// skipToSync is set after an error in the extended query protocol:
+	// messages are discarded until the next Sync.
+	skipToSync bool
+	// busy is set while a message is handled.
+	busy atomic.Bool
+}
+
+func (c *conn) serve() {
+	defer func() {
+		// a bug triggered by one client must not take the server down
+		if r := recover(); r != nil {
+			log.Printf("pgwire: %s: panic: %v\n%s", c.nc.RemoteAddr(), r, debug.Stack())
+		}
+		c.nc.Close()
+		c.srv.mu.Lock()
+		delete(c.srv.conns, c)
+		c.srv.mu.Unlock()
+	}()
+
+	if err := c.startup(); err != nil {
+		if !errors.Is(err, io.EOF) && !c.srv.shuttingDown.Load() {
+			log.Printf("pgwire: %s: startup: %v", c.nc.RemoteAddr(), err)
+		}
+		return
+	}
+
+	for {
+		c.busy.Store(false)
+		if c.srv.shuttingDown.Load() {
+			return
+		}
+		typ, body, err := c.readMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !c.srv.shuttingDown.Load() {
+				log.Printf("pgwire: %s: %v", c.nc.RemoteAddr(), err)
+			}
+			return
+		}
+
+		if typ == 'X' {
+			return
+		}
+		if c.skipToSync && typ != 'S' {
+			continue
+		}
+
+		switch typ {
+		case 'Q':
+			c.simpleQuery(readString(&body))
+		case 'P':
+			c.parse(body)
+		case 'B':
+			c.bind(body)
+		case 'D':
+			c.describe(body)
+		case 'E':
+			c.execute(body)
+		case 'C':
+			c.close(body)
+		case 'S':
+			c.skipToSync = false
+			c.readyForQuery()
+		case 'H':
+		default:
+			c.sendError(&query.Error{Code: query.CodeProtocolViolation, Message: fmt.Sprintf("unsupported message type %q", typ)})
+			c.readyForQuery()
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *conn) startup() error {
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return err
+		}
+		length := int(binary.BigEndian.Uint32(header[:]))
+		if length < 8 || length > c.srv.maxMessageSize() {
+			return fmt.Errorf("invalid startup message length %d", length)
+		}
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return err
+		}
+
+		code := binary.BigEndian.Uint32(body[:4])
+		body = body[4:]
+		switch code {
+		case sslRequestCode, gssRequestCode:
+			if _, err := c.nc.Write([]byte{'N'}); err != nil {
+				return err
+			}
+			continue
+		case cancelCode:
+			return io.EOF
+		case protocolVersion:
+		default:
+			return fmt.Errorf("unsupported protocol version %d", code)
+		}
+
+		for len(body) > 1 {
+			key := readString(&body)
+			value := readString(&body)
+			if key == "user" {
+				c.user = value
+			}
+		}
+		break
+	}
+
+	if c.srv.Auth != nil {
+		if err := c.authenticate(); err != nil {
+			return err
+		}
+	}
+
+	c.send('R', binary.BigEndian.AppendUint32(nil, 0))
+	for _, p := range [][2]string{
+		{"server_version", "14.0"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	} {
+		c.send('S', appendString(appendString(nil, p[0]), p[1]))
+	}
+	c.send('K', binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, c.pid), 0))
+	c.readyForQuery()
+	return c.w.Flush()
+}
+
+// authenticate asks for a password and checks it is an API key or token
+// of the user named in the startup message.
+func (c *conn) authenticate() error {
+	c.send('R', binary.BigEndian.AppendUint32(nil, authCleartextPassword))
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	typ, body, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	if typ != 'p' {
+		c.sendFatal(query.CodeProtocolViolation, fmt.Sprintf("expected password message, got %q", typ))
+		return io.EOF
+	}
+
+	principal, err := c.srv.Auth.Verify(readString(&body))
+	if err == nil && principal.User != c.user {
+		err = auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+			c.sendFatal(codeInvalidPassword, fmt.Sprintf("password authentication failed for user %q", c.user))
+			return io.EOF
+		}
+		c.sendFatal("XX000", err.Error())
+		return err
+	}
+	c.principal = principal
+	return nil
+}
+
+func (c *conn) simpleQuery(sql string) {
+	defer c.readyForQuery()
+
+	statements := query.Split(sql)
+	if len(statements) == 0 {
+		c.send('I', nil)
+		return
+	}
+
+	for _, text := range statements {
+		stmt, err := query.Parse(text)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+		result, err := query.Execute(c.table(), stmt, nil)
+		if err != nil {
+			c.sendError(err)
+			return
+		}
+
+		if _, ok := stmt.(*query.Select); ok {
+			c.rowDescription(result.Columns, nil)
+		}
+		if err := c.dataRows(result, nil, 0, len(result.Rows)); err != nil {
+			c.sendError(err)
+			return
+		}
+		c.send('C', appendString(nil, result.Tag))
+	}
+}
+
+func (c *conn) parse(body []byte) {
+	name := readString(&body)
+	sql := readString(&body)
+	count, err := readCount(&body, 4)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	oids := make([]uint32, count)
+	for i := range oids {
+		oids[i] = uint32(readInt32(&body))
+	}
+
+	p := &prepared{}
+	if statements := query.Split(sql); len(statements) > 1 {
+		c.fail(&query.Error{Code: query.CodeSyntaxError, Message: "cannot insert multiple commands into a prepared statement"})
+		return
+	} else if len(statements) == 1 {
+		stmt, err := query.Parse(statements[0])
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		params, columns, err := query.Describe(c.table(), stmt)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		p.stmt = stmt
+		p.params = params
+		p.paramOIDs = make([]uint32, len(params))
+		for i, column := range params {
+			p.paramOIDs[i] = typeOID(column)
+			if i < len(oids) && oids[i] != 0 {
+				p.paramOIDs[i] = oids[i]
+			}
+		}
+		if _, ok := stmt.(*query.Select); ok {
+			p.columns = columns
+		}
+	}
+
+	c.statements[name] = p
+	c.send('1', nil)
+}
+
+func (c *conn) bind(body []byte) {
+	portalName := readString(&body)
+	statementName := readString(&body)
+	p, ok := c.statements[statementName]
+	if !ok {
+		c.fail(&query.Error{Code: "26000", Message: fmt.Sprintf("prepared statement %q does not exist", statementName)})
+		return
+	}
+
+	paramFormats, err := readFormats(&body)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	count, err := readCount(&body, 4)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if count != len(p.paramOIDs) {
+		c.fail(&query.Error{Code: query.CodeProtocolViolation, Message: fmt.Sprintf("bind message supplies %d parameters, but prepared statement requires %d", count, len(p.paramOIDs))})
+		return
+	}
+
+	params := make([]*string, count)
+	for i := range params {
+		length := readInt32(&body)
+		if length < 0 {
+			continue
+		}
+		if int(length) > len(body) {
+			c.fail(protocolViolation("bind parameter %d is longer than the message", i+1))
+			return
+		}
+		data := readBytes(&body, int(length))
+		text, err := decodeParam(data, p.paramOIDs[i], formatFor(paramFormats, i))
+		if err != nil {
+			c.fail(&query.Error{Code: query.CodeInvalidTextRepr, Message: err.Error()})
+			return
+		}
+		params[i] = &text
+	}
+
+	resultFormats, err := readFormats(&body)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.portals[portalName] = &portal{prepared: p, params: params, formats: resultFormats}
+	c.send('2', nil)
+}
+
+func (c *conn) describe(body []byte) {
+	kind := readByte(&body)
+	name := readString(&body)
+
+	switch kind {
+	case 'S':
+		p, ok := c.statements[name]
+		if !ok {
+			c.fail(&query.Error{Code: "26000", Message: fmt.Sprintf("prepared statement %q does not exist", name)})
+			return
+		}
+		description := binary.BigEndian.AppendUint16(nil, uint16(len(p.paramOIDs)))
+		for _, oid := range p.paramOIDs {
+			description = binary.BigEndian.AppendUint32(description, oid)
+		}
+		c.send('t', description)
+		c.describeColumns(p.columns, nil)
+	case 'P':
+		po, ok := c.portals[name]
+		if !ok {
+			c.fail(&query.Error{Code: "34000", Message: fmt.Sprintf("portal %q does not exist", name)})
+			return
+		}
+		c.describeColumns(po.prepared.columns, po.formats)
+	default:
+		c.fail(&query.Error{Code: query.CodeProtocolViolation, Message: "invalid describe kind"})
+	}
+}
+
+func (c *conn) describeColumns(columns []storage.Column, formats []int16) {
+	if columns == nil {
+		c.send('n', nil)
+		return
+	}
+	c.rowDescription(columns, formats)
+}
+
+func (c *conn) execute(body []byte) {
+	name := readString(&body)
+	maxRows := int(readInt32(&body))
+
+	po, ok := c.portals[name]
+	if !ok {
+		c.fail(&query.Error{Code: "34000", Message: fmt.Sprintf("portal %q does not exist", name)})
+		return
+	}
+	if po.prepared.stmt == nil {
+		c.send('I', nil)
+		return
+	}
+
+	if po.result == nil {
+		result, err := query.Execute(c.table(), po.prepared.stmt, po.params)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		po.result = result
+	}
+
+	end := len(po.result.Rows)
+	if maxRows > 0 && po.sent+maxRows < end {
+		end = po.sent + maxRows
+	}
+	if err := c.dataRows(po.result, po.formats, po.sent, end); err != nil {
+		c.fail(err)
+		return
+	}
+	po.sent = end
+
+	if po.sent < len(po.result.Rows) {
+		c.send('s', nil)
+		return
+	}
+	c.send('C', appendString(nil, po.result.Tag))
+}
+
+func (c *conn) close(body []byte) {
+	kind := readByte(&body)
+	name := readString(&body)
+	if kind == 'S' {
+		delete(c.statements, name)
+	} else {
+		delete(c.portals, name)
+	}
+	c.send('3', nil)
+}
+
+func (c *conn) rowDescription(columns []storage.Column, formats []int16) {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(columns)))
+	for i, column := range columns {
+		oid := typeOID(column)
+		body = appendString(body, column.Name)
+		body = binary.BigEndian.AppendUint32(body, 0)
+		body = binary.BigEndian.AppendUint16(body, 0)
+		body = binary.BigEndian.AppendUint32(body, oid)
+		body = binary.BigEndian.AppendUint16(body, uint16(typeSize(oid)))
+		body = binary.BigEndian.AppendUint32(body, 0xFFFFFFFF)
+		body = binary.BigEndian.AppendUint16(body, uint16(formatFor(formats, i)))
+	}
+	c.send('T', body)
+}
+
+func (c *conn) dataRows(result *query.Result, formats []int16, from, to int) error {
+	for _, row := range result.Rows[from:to] {
+		body := binary.BigEndian.AppendUint16(nil, uint16(len(row)))
+		for i, value := range row {
+			data, err := encodeValue(result.Columns[i], value, formatFor(formats, i))
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				body = binary.BigEndian.AppendUint32(body, 0xFFFFFFFF)
+				continue
+			}
+			body = binary.BigEndian.AppendUint32(body, uint32(len(data)))
+			body = append(body, data...)
+		}
+		c.send('D', body)
+	}
+	return nil
+}
+
+func (c *conn) readyForQuery() {
+	c.send('Z', []byte{'I'})
+}
+
+// fail reports an error in the extended query protocol and discards
+// messages until the next Sync.
+func (c *conn) fail(err error) {
+	c.sendError(err)
+	c.skipToSync = true
+}
+
+// table returns the tables statements run against, checked against the
+// grants and row-level security policies of the logged in user when the
+// server requires credentials.
+func (c *conn) table() storage.TableI {
+	return authz.Table(c.srv.Stg.Table(), c.srv.Auth, c.principal)
+}
+
+func (c *conn) sendError(err error) {
+	code := "XX000"
+	var qerr *query.Error
+	if errors.As(err, &qerr) {
+		code = qerr.Code
+	}
+	var corrupt *storage.CorruptPageError
+	if errors.As(err, &corrupt) {
+		code = "XX001"
+	}
+	if errors.Is(err, auth.ErrPermissionDenied) || errors.Is(err, auth.ErrPolicyViolation) {
+		code = codeInsufficientPrivilege
+	}
+
+	var body []byte
+	body = append(appendString(append(body, 'S'), "ERROR"), 'V')
+	body = appendString(body, "ERROR")
+	body = appendString(append(body, 'C'), code)
+	body = appendString(append(body, 'M'), err.Error())
+	c.send('E', append(body, 0))
+}
+
+// sendFatal reports an error that ends the connection.
+func (c *conn) sendFatal(code, message string) {
+	var body []byte
+	body = append(appendString(append(body, 'S'), "FATAL"), 'V')
+	body = appendString(body, "FATAL")
+	body = appendString(append(body, 'C'), code)
+	body = appendString(append(body, 'M'), message)
+	c.send('E', append(body, 0))
+	c.w.Flush()
+}
+
+func (c *conn) send(typ byte, body []byte) {
+	c.w.WriteByte(typ)
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(body)+4))
+	c.w.Write(length[:])
+	c.w.Write(body)
+}
+
+func (c *conn) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	c.busy.Store(true)
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if length < 4 || length > c.srv.maxMessageSize() {
+		return 0, nil, fmt.Errorf("invalid message length %d", length)
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+func formatFor(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return formatText
+	case 1:
+		return formats[0]
+	}
+	if i < len(formats) {
+		return formats[i]
+	}
+	return formatText
+}
+
+func readFormats(body *[]byte) ([]int16, error) {
+	count, err := readCount(body, 2)
+	if err != nil {
+		return nil, err
+	}
+	formats := make([]int16, count)
+	for i := range formats {
+		formats[i] = readInt16(body)
+		if formats[i] != formatText && formats[i] != formatBinary {
+			return nil, protocolViolation("invalid format code %d", formats[i])
+		}
+	}
+	return formats, nil
+}
+
+// readCount reads the int16 count of a list whose items take at least
+// itemSize bytes each, rejecting counts the rest of the message cannot
+// hold.
+func readCount(body *[]byte, itemSize int) (int, error) {
+	if len(*body) < 2 {
+		return 0, protocolViolation("message is truncated")
+	}
+	count := int(readInt16(body))
+	if count < 0 || count*itemSize > len(*body) {
+		return 0, protocolViolation("invalid count %d", count)
+	}
+	return count, nil
+}
+
+func protocolViolation(format string, args ...any) error {
+	return &query.Error{Code: query.CodeProtocolViolation, Message: fmt.Sprintf(format, args...)}
+}
+
+// The read helpers consume from the front of a message body. Truncated
+// messages read as zero values rather than panicking.
+
+func readByte(body *[]byte) byte {
+	if len(*body) < 1 {
+		return 0
+	}
+	b := (*body)[0]
+	*body = (*body)[1:]
+	return b
+}
+
+func readInt16(body *[]byte) int16 {
+	if len(*body) < 2 {
+		*body = nil
+		return 0
+	}
+	n := int16(binary.BigEndian.Uint16(*body))
+	*body = (*body)[2:]
+	return n
+}
+
+func readInt32(body *[]byte) int32 {
+	if len(*body) < 4 {
+		*body = nil
+		return 0
+	}
+	n := int32(binary.BigEndian.Uint32(*body))
+	*body = (*body)[4:]
+	return n
+}
+
+func readBytes(body *[]byte, n int) []byte {
+	if n > len(*body) {
+		n = len(*body)
+	}
+	b := (*body)[:n]
+	*body = (*body)[n:]
+	return b
+}
+
+func readString(body *[]byte) string {
+	for i, b := range *body {
+		if b == 0 {
+			s := string((*body)[:i])
+			*body = (*body)[i+1:]
+			return s
+		}
+	}
+	s := string(*body)
+	*body = nil
+	return s
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
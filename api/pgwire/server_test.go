@@ -0,0 +1,338 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"rdbms/api/auth"
+	"rdbms/src"
+)
+
+// testConn is a raw protocol connection to a test server.
+type testConn struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+type message struct {
+	typ  byte
+	body []byte
+}
+
+// newServer serves a fresh data directory on a local port, requiring
+// credentials when a is set.
+func newServer(t *testing.T, a *auth.Authenticator) (*Server, string) {
+	t.Helper()
+
+	stg, err := src.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stg.Close() })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(stg)
+	s.Auth = a
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func newAuthenticator(t *testing.T) *auth.Authenticator {
+	t.Helper()
+
+	dir := t.TempDir()
+	keys, err := auth.OpenKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := auth.OpenGrantStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := auth.OpenPolicyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.NewAuthenticator(keys, grants, policies, nil)
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	t.Helper()
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { nc.Close() })
+	return &testConn{t: t, nc: nc, r: bufio.NewReader(nc)}
+}
+
+// startup sends a startup message with params and returns the messages up
+// to the first ReadyForQuery or ErrorResponse.
+func (c *testConn) startup(params ...string) []message {
+	c.t.Helper()
+
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	for _, p := range params {
+		body = appendString(body, p)
+	}
+	body = append(body, 0)
+	c.write(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body)
+	return c.readUntil('Z', 'E')
+}
+
+// login runs the startup of user, answering the password request with
+// password, and returns the messages up to the first ReadyForQuery or
+// ErrorResponse.
+func (c *testConn) login(user, password string) []message {
+	c.t.Helper()
+
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	body = append(appendString(appendString(body, "user"), user), 0)
+	c.write(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body)
+	m := c.readUntil('R', 'E')
+	if last := m[len(m)-1]; last.typ != 'R' || binary.BigEndian.Uint32(last.body) != authCleartextPassword {
+		c.t.Fatalf("got %q %q, want a password request", last.typ, last.body)
+	}
+	c.send('p', appendString(nil, password))
+	return c.readUntil('Z', 'E')
+}
+
+func (c *testConn) send(typ byte, body []byte) {
+	c.t.Helper()
+	c.write([]byte{typ}, binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body)
+}
+
+func (c *testConn) write(parts ...[]byte) {
+	c.t.Helper()
+	for _, p := range parts {
+		if _, err := c.nc.Write(p); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// readUntil reads messages up to and including one of the types in stop.
+func (c *testConn) readUntil(stop ...byte) []message {
+	c.t.Helper()
+
+	var messages []message
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			c.t.Fatalf("reading message: %v", err)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			c.t.Fatalf("reading message: %v", err)
+		}
+		messages = append(messages, message{typ: header[0], body: body})
+		if strings.IndexByte(string(stop), header[0]) >= 0 {
+			return messages
+		}
+	}
+}
+
+// errorCode returns the SQLSTATE of an ErrorResponse.
+func errorCode(m message) string {
+	for body := m.body; len(body) > 1; {
+		field := readByte(&body)
+		value := readString(&body)
+		if field == 'C' {
+			return value
+		}
+	}
+	return ""
+}
+
+func (c *testConn) query(sql string) []message {
+	c.t.Helper()
+	c.send('Q', appendString(nil, sql))
+	return c.readUntil('Z')
+}
+
+func TestMalformedMessages(t *testing.T) {
+	_, addr := newServer(t, nil)
+	c := dial(t, addr)
+	if m := c.startup("user", "test"); m[len(m)-1].typ != 'Z' {
+		t.Fatalf("startup failed: %q", m[len(m)-1].body)
+	}
+	c.query("CREATE TABLE t (id INT)")
+
+	tests := []struct {
+		name string
+		typ  byte
+		body []byte
+	}{
+		{"parse with negative parameter count", 'P', binary.BigEndian.AppendUint16(appendString(appendString(nil, ""), "SELECT * FROM t"), 0xFFFF)},
+		{"parse with parameter count past the end", 'P', binary.BigEndian.AppendUint16(appendString(appendString(nil, ""), "SELECT * FROM t"), 100)},
+		{"bind with negative format count", 'B', binary.BigEndian.AppendUint16(appendString(appendString(nil, ""), ""), 0xFFFF)},
+		{"bind with negative parameter count", 'B', binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(appendString(appendString(nil, ""), ""), 0), 0x8000)},
+		{"bind with parameter longer than the message", 'B', binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(appendString(appendString(nil, ""), "one"), 0), 1), 1000)},
+	}
+
+	// a statement for the bind tests to refer to
+	c.send('P', binary.BigEndian.AppendUint16(appendString(appendString(nil, ""), "SELECT * FROM t"), 0))
+	c.send('P', binary.BigEndian.AppendUint16(appendString(appendString(nil, "one"), "SELECT * FROM t WHERE id = $1"), 0))
+	c.send('S', nil)
+	if m := c.readUntil('Z'); m[0].typ != '1' || m[1].typ != '1' {
+		t.Fatalf("parse failed: %q", m[0].body)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.send(tt.typ, tt.body)
+			c.send('S', nil)
+			m := c.readUntil('Z')
+			if m[0].typ != 'E' || errorCode(m[0]) != "08P01" {
+				t.Fatalf("got %q %q, want a protocol violation", m[0].typ, m[0].body)
+			}
+		})
+	}
+
+	// the connection, and the server, still work
+	if m := c.query("SELECT * FROM t"); m[len(m)-2].typ != 'C' {
+		t.Fatalf("query after malformed messages failed: %q", m[0].body)
+	}
+	other := dial(t, addr)
+	if m := other.startup("user", "test"); m[len(m)-1].typ != 'Z' {
+		t.Fatal("server stopped accepting connections")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	a := newAuthenticator(t)
+	_, key, err := a.Keys.Create(auth.APIKey{User: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grants.Grant("app", auth.AllTables, []auth.Privilege{auth.Create}, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := newServer(t, a)
+
+	for name, login := range map[string][2]string{
+		"wrong password":      {"app", "rk_nope_nope"},
+		"empty password":      {"app", ""},
+		"key of another user": {"root", key},
+	} {
+		m := dial(t, addr).login(login[0], login[1])
+		if last := m[len(m)-1]; last.typ != 'E' || errorCode(last) != codeInvalidPassword {
+			t.Errorf("%s: got %q %q, want %s", name, last.typ, last.body, codeInvalidPassword)
+		}
+	}
+
+	c := dial(t, addr)
+	if m := c.login("app", key); m[len(m)-1].typ != 'Z' {
+		t.Fatalf("login failed: %q", m[len(m)-1].body)
+	}
+	if m := c.query("CREATE TABLE t (id INT)"); m[0].typ != 'C' {
+		t.Fatalf("statement after login failed: %q", m[0].body)
+	}
+
+	// app owns t, but not the tables of others
+	_, other, err := a.Keys.Create(auth.APIKey{User: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = dial(t, addr)
+	if m := c.login("other", other); m[len(m)-1].typ != 'Z' {
+		t.Fatalf("login failed: %q", m[len(m)-1].body)
+	}
+	for _, sql := range []string{"SELECT * FROM t", "INSERT INTO t VALUES (1)", "CREATE TABLE u (id INT)"} {
+		if m := c.query(sql); m[0].typ != 'E' || errorCode(m[0]) != codeInsufficientPrivilege {
+			t.Errorf("%s: got %q %q, want %s", sql, m[0].typ, m[0].body, codeInsufficientPrivilege)
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	a := newAuthenticator(t)
+	if err := a.Grants.Grant("app", auth.AllTables, auth.AllPrivileges, nil); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := auth.ParsePolicy("tenant_isolation", "tenant = $caller.tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Policies.Create("t", policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Grants.GrantRole(auth.SuperuserRole, "root"); err != nil {
+		t.Fatal(err)
+	}
+	_, rootKey, err := a.Keys.Create(auth.APIKey{User: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := a.Keys.Create(auth.APIKey{User: "app", Attributes: map[string]string{"tenant": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := newServer(t, a)
+
+	root := dial(t, addr)
+	if m := root.login("root", rootKey); m[len(m)-1].typ != 'Z' {
+		t.Fatalf("login failed: %q", m[len(m)-1].body)
+	}
+	root.query("CREATE TABLE t (tenant VARCHAR(8))")
+	if m := root.query("INSERT INTO t VALUES ('b'), ('b')"); m[0].typ != 'C' {
+		t.Fatalf("insert as superuser failed: %q", m[0].body)
+	}
+
+	c := dial(t, addr)
+	if m := c.login("app", key); m[len(m)-1].typ != 'Z' {
+		t.Fatalf("login failed: %q", m[len(m)-1].body)
+	}
+	if m := c.query("INSERT INTO t VALUES ('b')"); m[0].typ != 'E' || errorCode(m[0]) != codeInsufficientPrivilege {
+		t.Errorf("insert of another tenant: got %q %q, want %s", m[0].typ, m[0].body, codeInsufficientPrivilege)
+	}
+	if m := c.query("INSERT INTO t VALUES ('a')"); m[0].typ != 'C' {
+		t.Fatalf("insert of own tenant failed: %q", m[0].body)
+	}
+	if m := c.query("SELECT * FROM t WHERE tenant = 'b'"); string(m[len(m)-2].body) != "SELECT 0\x00" {
+		t.Errorf("select of another tenant: got %q", m[len(m)-2].body)
+	}
+	if m := c.query("SELECT * FROM t"); string(m[len(m)-2].body) != "SELECT 1\x00" {
+		t.Errorf("select: got %q", m[len(m)-2].body)
+	}
+	if m := root.query("SELECT * FROM t"); string(m[len(m)-2].body) != "SELECT 3\x00" {
+		t.Errorf("select as superuser: got %q", m[len(m)-2].body)
+	}
+}
+
+// TestLargeIntegers checks integers beyond 2^53, which a float64 can not
+// tell apart, keep their exact value from the SQL text to the heap.
+func TestLargeIntegers(t *testing.T) {
+	_, addr := newServer(t, nil)
+	c := dial(t, addr)
+	if m := c.startup("user", "test"); m[len(m)-1].typ != 'Z' {
+		t.Fatalf("startup failed: %q", m[len(m)-1].body)
+	}
+	c.query("CREATE TABLE t (id INT)")
+	if m := c.query("INSERT INTO t VALUES (9007199254740993)"); m[0].typ != 'C' {
+		t.Fatalf("insert failed: %q", m[0].body)
+	}
+
+	m := c.query("SELECT * FROM t WHERE id = 9007199254740993")
+	if string(m[len(m)-2].body) != "SELECT 1\x00" {
+		t.Fatalf("select: got %q", m[len(m)-2].body)
+	}
+	if m[1].typ != 'D' || !strings.Contains(string(m[1].body), "9007199254740993") {
+		t.Errorf("row: got %q %q, want 9007199254740993", m[1].typ, m[1].body)
+	}
+	if m := c.query("SELECT * FROM t WHERE id = 9007199254740992"); string(m[len(m)-2].body) != "SELECT 0\x00" {
+		t.Errorf("select of the neighbouring integer: got %q", m[len(m)-2].body)
+	}
+}
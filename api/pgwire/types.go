@@ -0,0 +1,156 @@
+package pgwire
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"rdbms/src/storage"
+)
+
+// Type OIDs from the pg_type catalog of PostgreSQL.
+const (
+	oidInt8      = 20
+	oidText      = 25
+	oidJSON      = 114
+	oidFloat8    = 701
+	oidVarchar   = 1043
+	oidDate      = 1082
+	oidTimestamp = 1114
+)
+
+const (
+	formatText   = 0
+	formatBinary = 1
+)
+
+// postgresEpoch is the zero point of the binary date and timestamp formats.
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func typeOID(column storage.Column) uint32 {
+	switch column.Type {
+	case storage.TypeInt:
+		return oidInt8
+	case storage.TypeFloat:
+		return oidFloat8
+	case storage.TypeDate:
+		return oidDate
+	case storage.TypeTimestamp:
+		return oidTimestamp
+	case storage.TypeJSON:
+		return oidJSON
+	}
+	return oidVarchar
+}
+
+// typeSize is the typlen of the type, -1 for variable length types.
+func typeSize(oid uint32) int16 {
+	switch oid {
+	case oidInt8, oidFloat8, oidTimestamp:
+		return 8
+	case oidDate:
+		return 4
+	}
+	return -1
+}
+
+// encodeValue encodes a value as returned by TableI.GetAllData in the
+// text or binary format of the column type. A nil result is a NULL.
+func encodeValue(column storage.Column, value any, format int16) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch column.Type {
+	case storage.TypeInt:
+		n, ok := value.(int64)
+		if !ok {
+			break
+		}
+		if format == formatBinary {
+			return binary.BigEndian.AppendUint64(nil, uint64(n)), nil
+		}
+		return strconv.AppendInt(nil, n, 10), nil
+	case storage.TypeFloat:
+		f, ok := value.(float64)
+		if !ok {
+			break
+		}
+		if format == formatBinary {
+			return binary.BigEndian.AppendUint64(nil, math.Float64bits(f)), nil
+		}
+		return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+	case storage.TypeDate:
+		s, _ := value.(string)
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, err
+		}
+		if format == formatBinary {
+			days := int32((t.Unix() - postgresEpoch.Unix()) / 86400)
+			return binary.BigEndian.AppendUint32(nil, uint32(days)), nil
+		}
+		return []byte(s), nil
+	case storage.TypeTimestamp:
+		s, _ := value.(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		if format == formatBinary {
+			micros := t.UnixMicro() - postgresEpoch.UnixMicro()
+			return binary.BigEndian.AppendUint64(nil, uint64(micros)), nil
+		}
+		return []byte(t.UTC().Format("2006-01-02 15:04:05.999999")), nil
+	case storage.TypeJSON:
+		return json.Marshal(value)
+	default:
+		if s, ok := value.(string); ok {
+			return []byte(s), nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected value %v for column %s", value, column.Name)
+}
+
+// decodeParam turns a bound parameter into the text form query.Execute
+// takes. Binary parameters are decoded according to the type OID the
+// parameter was described with.
+func decodeParam(data []byte, oid uint32, format int16) (string, error) {
+	if format == formatText {
+		return string(data), nil
+	}
+
+	switch oid {
+	case oidInt8:
+		if len(data) != 8 {
+			return "", errors.New("invalid binary int8")
+		}
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(data)), 10), nil
+	case oidFloat8:
+		if len(data) != 8 {
+			return "", errors.New("invalid binary float8")
+		}
+		return strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(data)), 'g', -1, 64), nil
+	case oidDate:
+		if len(data) != 4 {
+			return "", errors.New("invalid binary date")
+		}
+		days := int32(binary.BigEndian.Uint32(data))
+		return postgresEpoch.AddDate(0, 0, int(days)).Format("2006-01-02"), nil
+	case oidTimestamp:
+		if len(data) != 8 {
+			return "", errors.New("invalid binary timestamp")
+		}
+		micros := int64(binary.BigEndian.Uint64(data))
+		return time.UnixMicro(postgresEpoch.UnixMicro() + micros).UTC().Format(time.RFC3339Nano), nil
+	case oidText, oidVarchar, oidJSON:
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("binary format is not supported for type %d", oid)
+}
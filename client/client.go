@@ -0,0 +1,471 @@
+// Package client is a Go client for the HTTP API of the server.
+//
+// Tables can be created from Go structs, rows inserted from structs and
+// query results decoded back into structs. Struct fields map to columns
+// through the `rdbms` struct tag, see CreateTableFrom for the format.
+//
+//	c := client.New("http://localhost:8000")
+//	err := c.CreateTableFrom(ctx, "users", User{})
+//	err = c.Insert(ctx, "users", User{ID: 1, Name: "ann"})
+//	var users []User
+//	err = c.From("users").Where("name", client.Eq, "ann").Scan(ctx, &users)
+//
+// Requests that fail because the server could not be reached or was
+// temporarily unavailable are retried with exponential backoff.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rdbms/api/models"
+	"rdbms/src/storage"
+)
+
+// RetryPolicy controls how failed requests are retried. A request is
+// retried when it could not be sent or the server answered 429, 502, 503
+// or 504. The delay doubles after each attempt, starting at MinBackoff and
+// capped at MaxBackoff, with random jitter of up to half the delay.
+type RetryPolicy struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of a client created without
+// WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+// NoRetry disables retries.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+	header     http.Header
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to send requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetryPolicy sets the retry policy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// WithHeader adds a header sent with every request.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+// WithToken authenticates requests with an API key or a JWT.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// New returns a client for the server at baseURL, for example
+// "http://localhost:8000".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/") + "/api/v1",
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+		header:     http.Header{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned when the server rejects a request.
+type Error struct {
+	StatusCode int
+	// Status is the status name of the response, like "NOT_FOUND".
+	Status  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rdbms: %s (%d): %s", e.Status, e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is an Error for a missing table or page.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// envelope is the JSON body every API response is wrapped in.
+type envelope struct {
+	Status      string          `json:"status"`
+	Description string          `json:"description"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// request describes one API call. body is sent as is; it is kept in
+// memory so that the request can be replayed on retry.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	accept      string
+}
+
+func jsonRequest(method, path string, query url.Values, v any) (request, error) {
+	req := request{method: method, path: path, query: query}
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return request{}, err
+		}
+		req.body = b
+		req.contentType = "application/json"
+	}
+	return req, nil
+}
+
+// call sends a JSON request and decodes the data of the response into out,
+// which may be nil.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, in any, out any) error {
+	req, err := jsonRequest(method, path, query, in)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return fmt.Errorf("rdbms: decode response: %w", err)
+	}
+	if out == nil || len(env.Data) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(env.Data))
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+// do sends the request, retrying according to the retry policy, and
+// returns a response with a 2xx status. Any other status is turned into an
+// Error.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.retry.backoff(attempt)); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		lastErr = responseError(resp)
+		resp.Body.Close()
+		if !retryable(resp.StatusCode) {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	u := c.baseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range c.header {
+		httpReq.Header[key] = values
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if req.accept != "" {
+		httpReq.Header.Set("Accept", req.accept)
+	}
+
+	return c.httpClient.Do(httpReq)
+}
+
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode, Status: http.StatusText(resp.StatusCode)}
+
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return e
+	}
+	if env.Status != "" {
+		e.Status = env.Status
+	}
+
+	// The data is the error message, or an object with the message in
+	// "error" for requests that were partly processed.
+	var message string
+	var partial struct {
+		Error string `json:"error"`
+	}
+	switch {
+	case json.Unmarshal(env.Data, &message) == nil:
+		e.Message = message
+	case json.Unmarshal(env.Data, &partial) == nil && partial.Error != "":
+		e.Message = partial.Error
+	default:
+		e.Message = string(env.Data)
+	}
+	return e
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// CreateTable creates a table from an explicit column list. Use
+// CreateTableFrom to derive the columns from a struct.
+func (c *Client) CreateTable(ctx context.Context, req models.CreateTableRequest) error {
+	return c.call(ctx, http.MethodPost, "/tables/create-table", nil, req, nil)
+}
+
+func (c *Client) ListTables(ctx context.Context) ([]storage.TableInfo, error) {
+	var tables []storage.TableInfo
+	err := c.call(ctx, http.MethodGet, "/tables", nil, nil, &tables)
+	return tables, err
+}
+
+func (c *Client) DescribeTable(ctx context.Context, name string) (storage.TableInfo, error) {
+	var info storage.TableInfo
+	err := c.call(ctx, http.MethodGet, "/tables/"+url.PathEscape(name), nil, nil, &info)
+	return info, err
+}
+
+func (c *Client) DropTable(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodDelete, "/tables/"+url.PathEscape(name), nil, nil, nil)
+}
+
+func (c *Client) TruncateTable(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodPost, "/tables/"+url.PathEscape(name)+"/truncate", nil, nil, nil)
+}
+
+func (c *Client) RenameTable(ctx context.Context, name, newName string) error {
+	req := models.RenameTableRequest{NewName: newName}
+	return c.call(ctx, http.MethodPost, "/tables/"+url.PathEscape(name)+"/rename", nil, req, nil)
+}
+
+func (c *Client) AddColumn(ctx context.Context, table string, req models.AddColumnRequest) error {
+	return c.call(ctx, http.MethodPost, "/tables/"+url.PathEscape(table)+"/add-column", nil, req, nil)
+}
+
+func (c *Client) DropColumn(ctx context.Context, table, column string) error {
+	req := models.DropColumnRequest{Column: column}
+	return c.call(ctx, http.MethodPost, "/tables/"+url.PathEscape(table)+"/drop-column", nil, req, nil)
+}
+
+func (c *Client) RenameColumn(ctx context.Context, table, column, newName string) error {
+	req := models.RenameColumnRequest{Column: column, NewName: newName}
+	return c.call(ctx, http.MethodPost, "/tables/"+url.PathEscape(table)+"/rename-column", nil, req, nil)
+}
+
+// AlterColumnType starts a background rewrite of the table. Poll its
+// progress with AlterColumnTypeProgress.
+func (c *Client) AlterColumnType(ctx context.Context, table string, req models.AlterColumnTypeRequest) (storage.RewriteJob, error) {
+	var job storage.RewriteJob
+	err := c.call(ctx, http.MethodPost, "/tables/"+url.PathEscape(table)+"/alter-column-type", nil, req, &job)
+	return job, err
+}
+
+func (c *Client) AlterColumnTypeProgress(ctx context.Context, table string) (storage.RewriteJob, error) {
+	var job storage.RewriteJob
+	err := c.call(ctx, http.MethodGet, "/tables/"+url.PathEscape(table)+"/alter-column-type", nil, nil, &job)
+	return job, err
+}
+
+// InspectPage returns the decoded contents of a heap page, with a hex dump
+// of the page if withHex is set.
+func (c *Client) InspectPage(ctx context.Context, table string, page int, withHex bool) (storage.PageInspection, error) {
+	var query url.Values
+	if withHex {
+		query = url.Values{"hex": {"true"}}
+	}
+	var inspection storage.PageInspection
+	err := c.call(ctx, http.MethodGet, fmt.Sprintf("/admin/tables/%s/pages/%d", url.PathEscape(table), page), query, nil, &inspection)
+	return inspection, err
+}
+
+// InsertValues inserts a row given as column values: numbers, strings for
+// varchar, date and timestamp columns, and any JSON value for json columns.
+func (c *Client) InsertValues(ctx context.Context, table string, values map[string]any) error {
+	req := models.InsertRecordRequest{Name: table, Values: values}
+	return c.call(ctx, http.MethodPost, "/records/insert", nil, req, nil)
+}
+
+// BulkInsertValues inserts many rows in one request. Rows the server
+// rejects are reported in the result; err is only set when the request as
+// a whole failed.
+func (c *Client) BulkInsertValues(ctx context.Context, table string, rows []map[string]any) (models.BulkInsertResponse, error) {
+	var result models.BulkInsertResponse
+	err := c.call(ctx, http.MethodPost, "/records/bulk-insert", url.Values{"name": {table}}, rows, &result)
+	return result, err
+}
+
+// CSVOptions selects the CSV dialect of an import or export. Zero fields
+// use the server defaults, a comma and a double quote.
+type CSVOptions struct {
+	Delimiter rune
+	Quote     rune
+}
+
+func (o CSVOptions) query() url.Values {
+	query := url.Values{}
+	if o.Delimiter != 0 {
+		query.Set("delimiter", string(o.Delimiter))
+	}
+	if o.Quote != 0 {
+		query.Set("quote", string(o.Quote))
+	}
+	return query
+}
+
+// ImportCSV inserts the rows of a CSV file with a header line into the
+// table. The file is read into memory so that the request can be retried.
+func (c *Client) ImportCSV(ctx context.Context, table string, r io.Reader, opts CSVOptions) (models.CSVImportResponse, error) {
+	query := opts.query()
+	query.Set("name", table)
+	result, err := c.importFile(ctx, "/records/import-csv", query, r, "text/csv")
+	return result.CSVImportResponse, err
+}
+
+// ImportParquet inserts the rows of a Parquet file into the table. With
+// create set a missing table is created from the file schema.
+func (c *Client) ImportParquet(ctx context.Context, table string, r io.Reader, create bool) (ImportResult, error) {
+	query := url.Values{"name": {table}}
+	if create {
+		query.Set("create", "true")
+	}
+
+	result, err := c.importFile(ctx, "/records/import-parquet", query, r, "application/vnd.apache.parquet")
+	return ImportResult{Inserted: result.Inserted, Rejected: result.Rejected, Errors: result.Errors}, err
+}
+
+// ImportResult is the outcome of a Parquet import. Errors holds the reasons
+// of the first rejected rows.
+type ImportResult struct {
+	Inserted int
+	Rejected int
+	Errors   []string
+}
+
+type importResponse struct {
+	models.CSVImportResponse
+	Errors []string `json:"errors"`
+}
+
+func (c *Client) importFile(ctx context.Context, path string, query url.Values, r io.Reader, contentType string) (importResponse, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return importResponse{}, err
+	}
+
+	var result importResponse
+	req := request{method: http.MethodPost, path: path, query: query, body: body, contentType: contentType}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return result, fmt.Errorf("rdbms: decode response: %w", err)
+	}
+	err = json.Unmarshal(env.Data, &result)
+	return result, err
+}
@@ -0,0 +1,370 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"rdbms/api"
+	"rdbms/api/handlers"
+	"rdbms/api/models"
+	"rdbms/src"
+)
+
+type profile struct {
+	Langs []string `json:"langs"`
+}
+
+type user struct {
+	ID      int64     `rdbms:"id"`
+	Name    string    `rdbms:"name,length=32"`
+	Score   float64   `rdbms:"score"`
+	Born    time.Time `rdbms:"born,date"`
+	SeenAt  time.Time
+	Profile profile `rdbms:"profile"`
+	Ignored string  `rdbms:"-"`
+}
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// newServer starts a test server running the real handlers on a fresh
+// data directory. wrap, if set, wraps the router.
+func newServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	stg, err := src.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h http.Handler = api.SetUpRouter(handlers.NewHandler(stg), api.Options{})
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newUsers(t *testing.T, c *Client) []user {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := c.CreateTableFrom(ctx, "users", user{}); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC)
+	users := []user{
+		{ID: 1, Name: "ann", Score: 1.5, Born: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC), SeenAt: seen, Profile: profile{Langs: []string{"go"}}},
+		{ID: 2, Name: "bob", Score: 2, Born: time.Date(1991, 3, 4, 0, 0, 0, 0, time.UTC), SeenAt: seen.Add(time.Hour), Profile: profile{Langs: []string{}}},
+		{ID: 3, Name: "cid", Score: -3.25, Born: time.Date(1992, 5, 6, 0, 0, 0, 0, time.UTC), SeenAt: seen.Add(2 * time.Hour), Profile: profile{Langs: []string{"c", "sql"}}},
+	}
+	if err := c.Insert(ctx, "users", users[0]); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.BulkInsert(ctx, "users", users[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 2 || len(result.Rejected) != 0 {
+		t.Fatalf("bulk insert: got %+v", result)
+	}
+	return users
+}
+
+func TestTableRequest(t *testing.T) {
+	req, err := TableRequest("users", user{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name   string
+		typ    int
+		length int
+	}{
+		{"id", 0, 0},
+		{"name", 1, 32},
+		{"score", 4, 0},
+		{"born", 2, 0},
+		{"seen_at", 3, 0},
+		{"profile", 5, 0},
+	}
+	if len(req.Columns) != len(want) {
+		t.Fatalf("got %d columns, want %d", len(req.Columns), len(want))
+	}
+	for i, w := range want {
+		got := req.Columns[i]
+		length := 0
+		if got.Length != nil {
+			length = *got.Length
+		}
+		if got.Name != w.name || got.Type != w.typ || length != w.length {
+			t.Errorf("column %d: got %s/%d/%d, want %s/%d/%d", i, got.Name, got.Type, length, w.name, w.typ, w.length)
+		}
+	}
+
+	if _, err := TableRequest("bad", struct {
+		X int `rdbms:"x,size=3"`
+	}{}); err == nil {
+		t.Error("expected an error for an unknown tag option")
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"ID":        "id",
+		"UserID":    "user_id",
+		"CreatedAt": "created_at",
+		"HTTPCode":  "http_code",
+		"name":      "name",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestInsertAndScan(t *testing.T) {
+	srv := newServer(t, nil)
+	c := New(srv.URL)
+	ctx := context.Background()
+	users := newUsers(t, c)
+
+	var got []user
+	if err := c.From("users").Scan(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(users) {
+		t.Fatalf("got %d rows, want %d", len(got), len(users))
+	}
+	for i := range users {
+		want := users[i]
+		g := got[i]
+		if g.ID != want.ID || g.Name != want.Name || g.Score != want.Score ||
+			!g.Born.Equal(want.Born) || !g.SeenAt.Equal(want.SeenAt) ||
+			strings.Join(g.Profile.Langs, ",") != strings.Join(want.Profile.Langs, ",") {
+			t.Errorf("row %d: got %+v, want %+v", i, g, want)
+		}
+	}
+
+	var ptrs []*user
+	if err := c.From("users").Where("name", Ne, "bob").Where("id", Ne, 1).Scan(ctx, &ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 1 || ptrs[0].Name != "cid" {
+		t.Fatalf("got %+v", ptrs)
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	srv := newServer(t, nil)
+	c := New(srv.URL)
+	ctx := context.Background()
+	users := newUsers(t, c)
+
+	rows, err := c.From("users").Select("id", "name").Where("seen_at", Eq, users[1].SeenAt).Rows(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "bob" || len(rows[0]) != 2 {
+		t.Fatalf("got %v", rows)
+	}
+
+	var u user
+	if err := c.From("users").Where("born", Eq, users[2].Born.Format(DateLayout)).First(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 3 {
+		t.Fatalf("got %+v", u)
+	}
+
+	err = c.From("users").Where("id", Eq, 42).First(ctx, &u)
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	_, err = c.From("users").Where("id", Eq, []int{1}).Rows(ctx)
+	if err == nil {
+		t.Fatal("expected an error for an unsupported filter value")
+	}
+
+	_, err = c.From("users").Where("nope", Eq, 1).Rows(ctx)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Message, "nope") {
+		t.Fatalf("expected an unknown column error, got %v", err)
+	}
+}
+
+func TestTables(t *testing.T) {
+	srv := newServer(t, nil)
+	c := New(srv.URL)
+	ctx := context.Background()
+	newUsers(t, c)
+
+	if _, err := c.DescribeTable(ctx, "missing"); !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	if err := c.AddColumn(ctx, "users", models.AddColumnRequest{Name: "level", Type: 0, Default: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RenameTable(ctx, "users", "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := c.ListTables(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Name != "people" || tables[0].RowCount != 3 {
+		t.Fatalf("got %+v", tables)
+	}
+
+	info, err := c.DescribeTable(ctx, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := info.Columns[len(info.Columns)-1]; last.Name != "level" {
+		t.Fatalf("got columns %+v", info.Columns)
+	}
+
+	page, err := c.InspectPage(ctx, "people", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.RecordCount != 3 {
+		t.Fatalf("got %d records on page 1", page.RecordCount)
+	}
+
+	if err := c.TruncateTable(ctx, "people"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := c.From("people").Rows(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("got %d rows after truncate", len(rows))
+	}
+
+	if err := c.DropTable(ctx, "people"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DropTable(ctx, "people"); !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	srv := newServer(t, nil)
+	c := New(srv.URL)
+	ctx := context.Background()
+	newUsers(t, c)
+
+	var buf bytes.Buffer
+	if err := c.From("users").Select("id", "name").ExportCSV(ctx, &buf, CSVOptions{Delimiter: ';'}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "id;name\n1;ann\n2;bob\n3;cid\n"; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	type pair struct {
+		ID   int64  `rdbms:"id"`
+		Name string `rdbms:"name,length=8"`
+	}
+	if err := c.CreateTableFrom(ctx, "pairs", pair{}); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("x;too long for the column\n")
+	result, err := c.ImportCSV(ctx, "pairs", &buf, CSVOptions{Delimiter: ';'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 3 || result.Rejected != 1 || result.Rejects == "" {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var failures atomic.Int32
+	failures.Store(2)
+	var attempts atomic.Int32
+
+	srv := newServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			if failures.Add(-1) >= 0 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	ctx := context.Background()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetry))
+	if err := c.CreateTableFrom(ctx, "users", user{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+
+	// Client errors are not retried.
+	attempts.Store(0)
+	if err := c.CreateTableFrom(ctx, "users", user{}); err == nil {
+		t.Fatal("expected an error for a duplicate table")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("got %d attempts, want 1", n)
+	}
+
+	failures.Store(5)
+	attempts.Store(0)
+	var apiErr *Error
+	_, err := c.ListTables(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last 503, got %v", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+}
+
+func TestContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := newServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+	})
+	defer close(release)
+
+	c := New(srv.URL, WithRetryPolicy(fastRetry))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.ListTables(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("request was not cancelled")
+	}
+}
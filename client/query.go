@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+
+	"rdbms/api/models"
+)
+
+// Operator is a filter comparison.
+type Operator string
+
+const (
+	Eq Operator = "="
+	Ne Operator = "!="
+)
+
+// Row is a query result row keyed by column name. Numbers are json.Number
+// so that int columns keep their full precision, dates are "2006-01-02"
+// strings, timestamps RFC 3339 strings and json columns decoded JSON.
+type Row map[string]any
+
+// ArrowStreamMIME is the media type of an Arrow IPC stream.
+const ArrowStreamMIME = "application/vnd.apache.arrow.stream"
+
+// Query builds a query on one table. Build it with Client.From; the
+// methods return the query so that calls can be chained. A Query must not
+// be changed while it is running.
+type Query struct {
+	c   *Client
+	req models.GetAllRecordsRequest
+	err error
+}
+
+// From starts a query on a table. Without Select every column is
+// returned.
+func (c *Client) From(table string) *Query {
+	return &Query{c: c, req: models.GetAllRecordsRequest{Name: table}}
+}
+
+// Select sets the columns to return.
+func (q *Query) Select(columns ...string) *Query {
+	q.req.Columns = append(q.req.Columns, columns...)
+	return q
+}
+
+// Where adds a filter. Filters are combined with AND. A time.Time value is
+// compared as a timestamp; compare date columns with a DateLayout string.
+func (q *Query) Where(column string, op Operator, value any) *Query {
+	v, err := filterValue(value)
+	if err != nil && q.err == nil {
+		q.err = fmt.Errorf("rdbms: filter on %s: %w", column, err)
+	}
+	q.req.Filter = append(q.req.Filter, models.FilterRequestItem{
+		Column:   column,
+		Operator: string(op),
+		Value:    v,
+	})
+	return q
+}
+
+// filterValue converts a Go value to the JSON value the API compares
+// column values with.
+func filterValue(value any) (any, error) {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano), nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	return nil, fmt.Errorf("unsupported value %T", value)
+}
+
+// Rows runs the query and returns the matching rows.
+func (q *Query) Rows(ctx context.Context) ([]Row, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	var rows []Row
+	err := q.c.call(ctx, http.MethodPost, "/records/query", nil, q.req, &rows)
+	return rows, err
+}
+
+// Scan runs the query and stores the rows in dst, which must be a pointer
+// to a slice of structs or struct pointers. Fields map to columns as in
+// CreateTableFrom; columns without a field are ignored.
+func (q *Query) Scan(ctx context.Context, dst any) error {
+	slice := reflect.ValueOf(dst)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("rdbms: Scan needs a pointer to a slice, got %T", dst)
+	}
+	slice = slice.Elem()
+
+	elem := slice.Type().Elem()
+	isPtr := elem.Kind() == reflect.Pointer
+	if isPtr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return fmt.Errorf("rdbms: Scan needs a slice of structs, got %T", dst)
+	}
+	fields, err := structFields(elem)
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := reflect.MakeSlice(slice.Type(), 0, len(rows))
+	for _, row := range rows {
+		v := reflect.New(elem)
+		if err := decodeRow(row, v.Elem(), fields); err != nil {
+			return err
+		}
+		if !isPtr {
+			v = v.Elem()
+		}
+		out = reflect.Append(out, v)
+	}
+	slice.Set(out)
+	return nil
+}
+
+// First runs the query and stores the first row in the struct dst points
+// to. It returns an Error with status 404 when no row matches.
+func (q *Query) First(ctx context.Context, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("rdbms: First needs a pointer to a struct, got %T", dst)
+	}
+	fields, err := structFields(v.Elem().Type())
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return &Error{StatusCode: http.StatusNotFound, Status: "NOT_FOUND", Message: "no rows in " + q.req.Name}
+	}
+	return decodeRow(rows[0], v.Elem(), fields)
+}
+
+// ExportCSV writes the result of the query to w as CSV with a header line.
+func (q *Query) ExportCSV(ctx context.Context, w io.Writer, opts CSVOptions) error {
+	return q.export(ctx, "/records/export-csv", opts.query(), "", w)
+}
+
+// ExportParquet writes the result of the query to w as a Parquet file with
+// rowGroupSize rows per row group, or the server default if it is 0.
+func (q *Query) ExportParquet(ctx context.Context, w io.Writer, rowGroupSize int) error {
+	var query url.Values
+	if rowGroupSize > 0 {
+		query = url.Values{"row_group_size": {strconv.Itoa(rowGroupSize)}}
+	}
+	return q.export(ctx, "/records/export-parquet", query, "", w)
+}
+
+// ExportArrow writes the result of the query to w as an Arrow IPC stream.
+func (q *Query) ExportArrow(ctx context.Context, w io.Writer) error {
+	return q.export(ctx, "/records/query", nil, ArrowStreamMIME, w)
+}
+
+func (q *Query) export(ctx context.Context, path string, query url.Values, accept string, w io.Writer) error {
+	if q.err != nil {
+		return q.err
+	}
+
+	req, err := jsonRequest(http.MethodPost, path, query, q.req)
+	if err != nil {
+		return err
+	}
+	req.accept = accept
+
+	resp, err := q.c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
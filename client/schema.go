@@ -0,0 +1,417 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"rdbms/api/models"
+	"rdbms/src/storage"
+)
+
+// DateLayout is the layout of date column values.
+const DateLayout = "2006-01-02"
+
+// field is a struct field mapped to a column.
+type field struct {
+	index  []int
+	column string
+	typ    storage.ColumnType
+	length int
+}
+
+var (
+	fieldCacheMu sync.Mutex
+	fieldCache   = map[reflect.Type][]field{}
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structFields returns the column mapping of a struct type. Exported
+// fields are mapped, including the fields of embedded structs, unless
+// their tag is "-".
+func structFields(t reflect.Type) ([]field, error) {
+	fieldCacheMu.Lock()
+	defer fieldCacheMu.Unlock()
+
+	if fields, ok := fieldCache[t]; ok {
+		return fields, nil
+	}
+
+	fields, err := collectFields(t, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("rdbms: %s has no exported fields", t)
+	}
+	fieldCache[t] = fields
+	return fields, nil
+}
+
+func collectFields(t reflect.Type, index []int) ([]field, error) {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("rdbms")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			embedded, err := collectFields(sf.Type, fieldIndex)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+
+		f, err := parseField(sf, tag)
+		if err != nil {
+			return nil, err
+		}
+		f.index = fieldIndex
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// parseField reads the column of a struct field from its tag. The tag is
+// the column name followed by options:
+//
+//	ID      int64     `rdbms:"id"`
+//	Name    string    `rdbms:"name,length=64"`
+//	Born    time.Time `rdbms:"born,date"`
+//	Profile Profile   `rdbms:"profile,json"`
+//
+// Without a name the column is the field name in snake case. The column
+// type follows from the field type: integers map to int, floats to float,
+// strings to varchar, time.Time to timestamp and everything else to json.
+// The options int, float, varchar, date, timestamp and json override the
+// type and length=N sets the length of a varchar column.
+func parseField(sf reflect.StructField, tag string) (field, error) {
+	parts := strings.Split(tag, ",")
+	f := field{column: parts[0]}
+	if f.column == "" {
+		f.column = snakeCase(sf.Name)
+	}
+
+	t := sf.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	f.typ = columnType(t)
+
+	for _, opt := range parts[1:] {
+		switch opt = strings.TrimSpace(opt); {
+		case opt == "int":
+			f.typ = storage.TypeInt
+		case opt == "float":
+			f.typ = storage.TypeFloat
+		case opt == "varchar":
+			f.typ = storage.TypeVarchar
+		case opt == "date":
+			f.typ = storage.TypeDate
+		case opt == "timestamp":
+			f.typ = storage.TypeTimestamp
+		case opt == "json":
+			f.typ = storage.TypeJSON
+		case strings.HasPrefix(opt, "length="):
+			n, err := strconv.Atoi(strings.TrimPrefix(opt, "length="))
+			if err != nil || n < 0 {
+				return field{}, fmt.Errorf("rdbms: field %s: invalid length %q", sf.Name, opt)
+			}
+			f.length = n
+		case opt == "":
+		default:
+			return field{}, fmt.Errorf("rdbms: field %s: unknown tag option %q", sf.Name, opt)
+		}
+	}
+
+	if (f.typ == storage.TypeDate || f.typ == storage.TypeTimestamp) && t != timeType && t.Kind() != reflect.String {
+		return field{}, fmt.Errorf("rdbms: field %s: %s column needs a time.Time or string field", sf.Name, f.typ)
+	}
+	return f, nil
+}
+
+func columnType(t reflect.Type) storage.ColumnType {
+	if t == timeType {
+		return storage.TypeTimestamp
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return storage.TypeInt
+	case reflect.Float32, reflect.Float64:
+		return storage.TypeFloat
+	case reflect.String:
+		return storage.TypeVarchar
+	}
+	return storage.TypeJSON
+}
+
+// snakeCase turns a Go field name like UserID into user_id.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// structType returns the struct type of v, which is a struct or a pointer
+// to one.
+func structType(v any) (reflect.Type, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("rdbms: expected a struct, got %T", v)
+	}
+	return t, nil
+}
+
+// TableRequest returns the create table request for the columns of a
+// struct, see parseField for how fields map to columns.
+func TableRequest(name string, v any) (models.CreateTableRequest, error) {
+	t, err := structType(v)
+	if err != nil {
+		return models.CreateTableRequest{}, err
+	}
+	fields, err := structFields(t)
+	if err != nil {
+		return models.CreateTableRequest{}, err
+	}
+
+	req := models.CreateTableRequest{Name: name}
+	for _, f := range fields {
+		column := models.CreateColumn{Name: f.column, Type: int(f.typ)}
+		if f.typ == storage.TypeVarchar && f.length > 0 {
+			length := f.length
+			column.Length = &length
+		}
+		req.Columns = append(req.Columns, column)
+	}
+	return req, nil
+}
+
+// CreateTableFrom creates a table with the columns of the struct v.
+func (c *Client) CreateTableFrom(ctx context.Context, name string, v any) error {
+	req, err := TableRequest(name, v)
+	if err != nil {
+		return err
+	}
+	return c.CreateTable(ctx, req)
+}
+
+// Insert inserts the struct v as a row. Nil pointer fields are left out,
+// so the column default is stored.
+func (c *Client) Insert(ctx context.Context, table string, v any) error {
+	values, err := structValues(v)
+	if err != nil {
+		return err
+	}
+	return c.InsertValues(ctx, table, values)
+}
+
+// BulkInsert inserts a slice of structs in one request.
+func (c *Client) BulkInsert(ctx context.Context, table string, rows any) (models.BulkInsertResponse, error) {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		return models.BulkInsertResponse{}, fmt.Errorf("rdbms: expected a slice of structs, got %T", rows)
+	}
+
+	values := make([]map[string]any, rv.Len())
+	for i := range values {
+		row, err := structValues(rv.Index(i).Interface())
+		if err != nil {
+			return models.BulkInsertResponse{}, err
+		}
+		values[i] = row
+	}
+	return c.BulkInsertValues(ctx, table, values)
+}
+
+// structValues converts a struct to the column values of an insert.
+func structValues(v any) (map[string]any, error) {
+	t, err := structType(v)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := structFields(t)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	values := make(map[string]any, len(fields))
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+
+		value, err := encodeValue(f, fv)
+		if err != nil {
+			return nil, err
+		}
+		values[f.column] = value
+	}
+	return values, nil
+}
+
+// encodeValue converts a field value to the JSON value the API accepts for
+// the column type.
+func encodeValue(f field, v reflect.Value) (any, error) {
+	if t, ok := v.Interface().(time.Time); ok {
+		if f.typ == storage.TypeDate {
+			return t.Format(DateLayout), nil
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	}
+
+	if f.typ == storage.TypeJSON {
+		b, err := json.Marshal(v.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("rdbms: column %s: %w", f.column, err)
+		}
+		return json.RawMessage(b), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	return nil, fmt.Errorf("rdbms: column %s: can not store %s in a %s column", f.column, v.Type(), f.typ)
+}
+
+// decodeRow stores the values of a row in the fields of the struct dst
+// points to. Columns without a field are ignored.
+func decodeRow(row Row, dst reflect.Value, fields []field) error {
+	for _, f := range fields {
+		value, ok := row[f.column]
+		if !ok {
+			continue
+		}
+
+		fv := dst.FieldByIndex(f.index)
+		if value == nil {
+			fv.Set(reflect.Zero(fv.Type()))
+			continue
+		}
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+
+		if err := decodeValue(f, value, fv); err != nil {
+			return fmt.Errorf("rdbms: column %s: %w", f.column, err)
+		}
+	}
+	return nil
+}
+
+func decodeValue(f field, value any, fv reflect.Value) error {
+	if fv.Type() == timeType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a time string, got %T", value)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			if t, err = time.Parse(DateLayout, s); err != nil {
+				return err
+			}
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if f.typ == storage.TypeJSON {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, fv.Addr().Interface())
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toNumber(value).Int64()
+		if err != nil {
+			return err
+		}
+		if fv.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, fv.Type())
+		}
+		fv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(toNumber(value).String(), 10, 64)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %s", n, fv.Type())
+		}
+		fv.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := toNumber(value).Float64()
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+		return nil
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %T", value)
+		}
+		fv.SetString(s)
+		return nil
+	}
+
+	return errors.New("unsupported field type " + fv.Type().String())
+}
+
+func toNumber(value any) json.Number {
+	switch v := value.(type) {
+	case json.Number:
+		return v
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return json.Number(fmt.Sprint(value))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"rdbms/api/models"
+	"rdbms/client"
+	"rdbms/db"
+	"rdbms/src/query"
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+// backend runs the shell commands, either on a data directory opened in
+// process or through the HTTP API of a running server.
+type backend interface {
+	Tables() ([]storage.TableInfo, error)
+	Describe(table string) (storage.TableInfo, error)
+	Columns(table string) ([]storage.Column, error)
+	Execute(stmt query.Statement) (*query.Result, error)
+	Close() error
+}
+
+type localBackend struct {
+	db *db.DB
+}
+
+func (b *localBackend) Tables() ([]storage.TableInfo, error) {
+	return b.db.Tables()
+}
+
+func (b *localBackend) Describe(table string) (storage.TableInfo, error) {
+	return b.db.Table().DescribeTable(table)
+}
+
+func (b *localBackend) Columns(table string) ([]storage.Column, error) {
+	schema, err := b.db.Table().GetTableSchema(table + ".schema")
+	return schema.Columns, err
+}
+
+func (b *localBackend) Execute(stmt query.Statement) (*query.Result, error) {
+	return query.Execute(b.db.Table(), stmt, nil)
+}
+
+func (b *localBackend) Close() error {
+	return b.db.Close()
+}
+
+type remoteBackend struct {
+	c *client.Client
+}
+
+func (b *remoteBackend) Tables() ([]storage.TableInfo, error) {
+	return b.c.ListTables(context.Background())
+}
+
+func (b *remoteBackend) Describe(table string) (storage.TableInfo, error) {
+	return b.c.DescribeTable(context.Background(), table)
+}
+
+func (b *remoteBackend) Columns(table string) ([]storage.Column, error) {
+	info, err := b.Describe(table)
+	return info.Columns, err
+}
+
+func (b *remoteBackend) Close() error {
+	return nil
+}
+
+// Execute runs a statement through the record endpoints. Literals are
+// converted with the column types the server reports, like query.Execute
+// does in process.
+func (b *remoteBackend) Execute(stmt query.Statement) (*query.Result, error) {
+	ctx := context.Background()
+
+	switch s := stmt.(type) {
+	case *query.Select:
+		schema, err := b.Columns(s.Table)
+		if err != nil {
+			return nil, err
+		}
+		columns, err := selectColumns(schema, s.Columns)
+		if err != nil {
+			return nil, err
+		}
+
+		q := b.c.From(s.Table)
+		for _, column := range columns {
+			q.Select(column.Name)
+		}
+		for _, cond := range s.Where {
+			column, err := findColumn(schema, cond.Column)
+			if err != nil {
+				return nil, err
+			}
+			value, err := literal(column, cond.Value)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return nil, errors.New("comparison with NULL is not supported")
+			}
+			q.Where(column.Name, client.Operator(cond.Operator), value)
+		}
+
+		rows, err := q.Rows(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if s.Limit >= 0 && len(rows) > s.Limit {
+			rows = rows[:s.Limit]
+		}
+
+		result := &query.Result{Columns: columns, Rows: make([][]any, len(rows))}
+		for i, row := range rows {
+			values := make([]any, len(columns))
+			for j, column := range columns {
+				if values[j], err = fromJSON(column, row[column.Name]); err != nil {
+					return nil, err
+				}
+			}
+			result.Rows[i] = values
+		}
+		result.Tag = fmt.Sprintf("SELECT %d", len(rows))
+		return result, nil
+
+	case *query.Insert:
+		schema, err := b.Columns(s.Table)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := selectColumns(schema, s.Columns)
+		if err != nil {
+			return nil, err
+		}
+
+		rows := make([]map[string]any, len(s.Rows))
+		for r, row := range s.Rows {
+			if len(row) != len(targets) {
+				return nil, fmt.Errorf("row %d has %d values for %d columns", r+1, len(row), len(targets))
+			}
+			values := make(map[string]any, len(row))
+			for i, v := range row {
+				value, err := literal(targets[i], v)
+				if err != nil {
+					return nil, err
+				}
+				if value != nil {
+					values[targets[i].Name] = value
+				}
+			}
+			rows[r] = values
+		}
+
+		if len(rows) == 1 {
+			if err := b.c.InsertValues(ctx, s.Table, rows[0]); err != nil {
+				return nil, err
+			}
+			return &query.Result{Tag: "INSERT 0 1"}, nil
+		}
+		result, err := b.c.BulkInsertValues(ctx, s.Table, rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Rejected) > 0 {
+			first := result.Rejected[0]
+			return nil, fmt.Errorf("row %d: %s (%d of %d rows inserted)", first.Row, first.Error, result.Inserted, len(rows))
+		}
+		return &query.Result{Tag: fmt.Sprintf("INSERT 0 %d", result.Inserted)}, nil
+
+	case *query.CreateTable:
+		req := models.CreateTableRequest{Name: s.Table}
+		for _, c := range s.Columns {
+			column := models.CreateColumn{Name: c.Name, Type: int(c.Type)}
+			if c.Length > 0 {
+				length := c.Length
+				column.Length = &length
+			}
+			req.Columns = append(req.Columns, column)
+		}
+		if err := b.c.CreateTable(ctx, req); err != nil {
+			return nil, err
+		}
+		return &query.Result{Tag: "CREATE TABLE"}, nil
+
+	case *query.Set:
+		return &query.Result{Tag: "SET"}, nil
+	}
+
+	return nil, errors.New("unsupported statement")
+}
+
+// selectColumns returns the named columns of a schema, or all of them when
+// names is nil.
+func selectColumns(schema []storage.Column, names []string) ([]storage.Column, error) {
+	if names == nil {
+		return schema, nil
+	}
+
+	var err error
+	columns := make([]storage.Column, len(names))
+	for i, name := range names {
+		if columns[i], err = findColumn(schema, name); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+func findColumn(columns []storage.Column, name string) (storage.Column, error) {
+	for _, column := range columns {
+		if column.Name == name {
+			return column, nil
+		}
+	}
+	return storage.Column{}, fmt.Errorf("column %q does not exist", name)
+}
+
+// literal converts a statement value to the JSON value of the column, nil
+// for NULL. The shell has no parameters to bind.
+func literal(column storage.Column, v query.Value) (any, error) {
+	switch v.Kind {
+	case query.ValueNull:
+		return nil, nil
+	case query.ValueParam:
+		return nil, fmt.Errorf("parameter $%d is not bound", v.Param)
+	}
+	return utils.ParseTextValue(column, v.Text)
+}
+
+// fromJSON converts a value of a query response to the type GetAllData
+// returns for the column, which the output formats expect.
+func fromJSON(column storage.Column, v any) (any, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, nil
+	}
+	switch column.Type {
+	case storage.TypeInt:
+		return n.Int64()
+	case storage.TypeFloat:
+		return n.Float64()
+	}
+	return v, nil
+}
@@ -0,0 +1,353 @@
+// Command cli is an interactive shell for the database. It connects to a
+// running server, or opens a data directory directly when -data is given,
+// in which case the server must not be running on it.
+//
+// SQL statements in the dialect of package query end with a semicolon and
+// may span lines. Commands start with a backslash:
+//
+//	\dt             list tables
+//	\d TABLE        describe a table
+//	\format FORMAT  switch the output to table, csv or json
+//	\q              quit
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/peterh/liner"
+
+	"rdbms/client"
+	"rdbms/db"
+	"rdbms/src/query"
+)
+
+func main() {
+	server := flag.String("server", "http://localhost:8000", "URL of the server to connect to")
+	token := flag.String("token", os.Getenv("RDBMS_TOKEN"), "API key or JWT for the server, default $RDBMS_TOKEN")
+	dataDir := flag.String("data", "", "open this data directory instead of connecting to a server")
+	format := flag.String("format", formatTable, "output format: table, csv or json")
+	command := flag.String("c", "", "run the given commands and exit")
+	historyFile := flag.String("history", defaultHistoryFile(), "history file, empty to disable")
+	flag.Parse()
+
+	if !validFormat(*format) {
+		fmt.Fprintln(os.Stderr, "cli: unknown format", *format)
+		os.Exit(2)
+	}
+
+	var b backend
+	if *dataDir != "" {
+		d, err := db.Open(*dataDir, db.Options{})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cli:", err)
+			os.Exit(1)
+		}
+		b = &localBackend{db: d}
+	} else {
+		var opts []client.Option
+		if *token != "" {
+			opts = append(opts, client.WithToken(*token))
+		}
+		b = &remoteBackend{c: client.New(*server, opts...)}
+	}
+
+	sh := &shell{backend: b, out: os.Stdout, format: *format, columns: map[string][]string{}}
+
+	var err error
+	if *command != "" {
+		err = sh.runScript(*command)
+	} else {
+		err = sh.interactive(*historyFile)
+	}
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cli:", err)
+		os.Exit(1)
+	}
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".rdbms_history")
+}
+
+var errQuit = errors.New("quit")
+
+type shell struct {
+	backend backend
+	out     io.Writer
+	format  string
+
+	// tables and columns cache the names offered by tab completion.
+	tables  []string
+	columns map[string][]string
+}
+
+func (sh *shell) interactive(historyFile string) error {
+	line := liner.NewLiner()
+	defer line.Close()
+
+	line.SetCtrlCAborts(true)
+	line.SetWordCompleter(sh.complete)
+
+	if historyFile != "" {
+		if f, err := os.Open(historyFile); err == nil {
+			line.ReadHistory(f)
+			f.Close()
+		}
+		defer func() {
+			if f, err := os.Create(historyFile); err == nil {
+				line.WriteHistory(f)
+				f.Close()
+			}
+		}()
+	}
+
+	var pending strings.Builder
+	for {
+		prompt := "rdbms=> "
+		if pending.Len() > 0 {
+			prompt = "rdbms-> "
+		}
+
+		input, err := line.Prompt(prompt)
+		if errors.Is(err, liner.ErrPromptAborted) {
+			pending.Reset()
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(sh.out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		trimmed := strings.TrimSpace(input)
+		if trimmed == "" {
+			continue
+		}
+
+		if pending.Len() == 0 && strings.HasPrefix(trimmed, `\`) {
+			line.AppendHistory(trimmed)
+			if err := sh.meta(trimmed); err != nil {
+				if errors.Is(err, errQuit) {
+					return nil
+				}
+				fmt.Fprintln(sh.out, "ERROR:", err)
+			}
+			continue
+		}
+
+		pending.WriteString(input)
+		pending.WriteString("\n")
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+
+		text := pending.String()
+		pending.Reset()
+		line.AppendHistory(strings.Join(strings.Fields(text), " "))
+		for _, statement := range query.Split(text) {
+			if err := sh.execute(statement); err != nil {
+				fmt.Fprintln(sh.out, "ERROR:", err)
+				break
+			}
+		}
+	}
+}
+
+// runScript runs the commands of -c: statements separated by semicolons,
+// and backslash commands on lines of their own. It stops at the first
+// error.
+func (sh *shell) runScript(script string) error {
+	var sql strings.Builder
+	flush := func() error {
+		statements := query.Split(sql.String())
+		sql.Reset()
+		for _, statement := range statements {
+			if err := sh.execute(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, `\`) {
+			sql.WriteString(line)
+			sql.WriteString("\n")
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return err
+		}
+		if err := sh.meta(trimmed); err != nil {
+			if errors.Is(err, errQuit) {
+				return nil
+			}
+			return err
+		}
+	}
+	return flush()
+}
+
+func (sh *shell) meta(command string) error {
+	fields := strings.Fields(command)
+	switch fields[0] {
+	case `\q`:
+		return errQuit
+	case `\dt`:
+		tables, err := sh.backend.Tables()
+		if err != nil {
+			return err
+		}
+		sh.tables = nil
+		return printTables(sh.out, sh.format, tables)
+	case `\d`:
+		if len(fields) != 2 {
+			return errors.New(`usage: \d TABLE`)
+		}
+		info, err := sh.backend.Describe(fields[1])
+		if err != nil {
+			return err
+		}
+		return printColumns(sh.out, sh.format, info)
+	case `\format`:
+		if len(fields) != 2 || !validFormat(fields[1]) {
+			return errors.New(`usage: \format table|csv|json`)
+		}
+		sh.format = fields[1]
+		return nil
+	case `\?`, `\help`:
+		fmt.Fprint(sh.out, helpText)
+		return nil
+	}
+	return fmt.Errorf(`unknown command %s, try \?`, fields[0])
+}
+
+const helpText = `\dt             list tables
+\d TABLE        describe a table
+\format FORMAT  switch the output to table, csv or json
+\q              quit
+
+SELECT * | col, ... FROM table [WHERE col = value [AND ...]] [LIMIT n];
+INSERT INTO table [(col, ...)] VALUES (value, ...)[, (...)];
+CREATE TABLE table (col type, ...);
+`
+
+func (sh *shell) execute(statement string) error {
+	stmt, err := query.Parse(statement)
+	if err != nil {
+		return err
+	}
+
+	result, err := sh.backend.Execute(stmt)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := stmt.(*query.Select); ok {
+		return printRows(sh.out, sh.format, result.Columns, result.Rows)
+	}
+	if _, ok := stmt.(*query.CreateTable); ok {
+		sh.tables = nil
+	}
+	if sh.format == formatTable {
+		fmt.Fprintln(sh.out, result.Tag)
+	}
+	return nil
+}
+
+var keywords = []string{
+	"SELECT", "FROM", "WHERE", "AND", "LIMIT", "INSERT", "INTO", "VALUES",
+	"CREATE", "TABLE", "NULL",
+	"int", "float", "varchar", "date", "timestamp", "json",
+}
+
+var commands = []string{`\dt`, `\d`, `\format`, `\q`, `\?`}
+
+// complete offers the commands, keywords, table names and column names
+// starting with the word under the cursor. Column names come from the
+// tables named earlier on the line.
+func (sh *shell) complete(line string, pos int) (head string, completions []string, tail string) {
+	start := strings.LastIndexAny(line[:pos], " \t(,=") + 1
+	head, word, tail := line[:start], line[start:pos], line[pos:]
+
+	var candidates []string
+	if start == 0 && strings.HasPrefix(word, `\`) {
+		candidates = commands
+	} else {
+		candidates = append(candidates, keywords...)
+		tables := sh.tableNames()
+		candidates = append(candidates, tables...)
+		for _, token := range strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(" \t(),=;", r) }) {
+			if contains(tables, token) {
+				candidates = append(candidates, sh.columnNames(token)...)
+			}
+		}
+	}
+
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) {
+			completions = append(completions, c)
+		}
+	}
+	sort.Strings(completions)
+	return head, completions, tail
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (sh *shell) tableNames() []string {
+	if sh.tables == nil {
+		tables, err := sh.backend.Tables()
+		if err != nil {
+			return nil
+		}
+		sh.tables = []string{}
+		for _, t := range tables {
+			sh.tables = append(sh.tables, t.Name)
+		}
+		sort.Strings(sh.tables)
+		sh.columns = map[string][]string{}
+	}
+	return sh.tables
+}
+
+func (sh *shell) columnNames(table string) []string {
+	if names, ok := sh.columns[table]; ok {
+		return names
+	}
+	columns, err := sh.backend.Columns(table)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	sh.columns[table] = names
+	return names
+}
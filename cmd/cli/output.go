@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"rdbms/src/dataio"
+	"rdbms/src/storage"
+)
+
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+)
+
+func validFormat(format string) bool {
+	return format == formatTable || format == formatCSV || format == formatJSON
+}
+
+// printRows writes the columns and rows of a result in the given output
+// format. Values are as returned by TableI.GetAllData.
+func printRows(w io.Writer, format string, columns []storage.Column, rows [][]any) error {
+	switch format {
+	case formatCSV:
+		return printCSV(w, columns, rows)
+	case formatJSON:
+		return printJSON(w, columns, rows)
+	}
+	return printTable(w, columns, rows)
+}
+
+func formatValue(column storage.Column, value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if column.Type != storage.TypeJSON {
+			return v
+		}
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+// printTable writes an aligned table with a header, numbers aligned to the
+// right, followed by the row count.
+func printTable(w io.Writer, columns []storage.Column, rows [][]any) error {
+	cells := make([][]string, len(rows))
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column.Name)
+	}
+	for r, row := range rows {
+		cells[r] = make([]string, len(columns))
+		for i, column := range columns {
+			cell := strings.ReplaceAll(formatValue(column, row[i]), "\n", `\n`)
+			cells[r][i] = cell
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var b strings.Builder
+	for i, column := range columns {
+		if i > 0 {
+			b.WriteString(" | ")
+		}
+		b.WriteString(pad(column.Name, widths[i], false))
+	}
+	b.WriteString("\n")
+	for i := range columns {
+		if i > 0 {
+			b.WriteString("-+-")
+		}
+		b.WriteString(strings.Repeat("-", widths[i]))
+	}
+	b.WriteString("\n")
+
+	for _, row := range cells {
+		for i, column := range columns {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			numeric := column.Type == storage.TypeInt || column.Type == storage.TypeFloat
+			b.WriteString(pad(row[i], widths[i], numeric))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(rows) == 1 {
+		b.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n", len(rows))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func pad(s string, width int, right bool) string {
+	fill := strings.Repeat(" ", width-utf8.RuneCountInString(s))
+	if right {
+		return fill + s
+	}
+	return s + fill
+}
+
+func printCSV(w io.Writer, columns []storage.Column, rows [][]any) error {
+	maps := make([]map[string]any, len(rows))
+	for r, row := range rows {
+		maps[r] = make(map[string]any, len(columns))
+		for i, column := range columns {
+			maps[r][column.Name] = row[i]
+		}
+	}
+	return dataio.ExportCSV(w, columns, maps, dataio.DefaultCSVOptions())
+}
+
+// printJSON writes the rows as a JSON array of objects with the keys in
+// column order.
+func printJSON(w io.Writer, columns []storage.Column, rows [][]any) error {
+	var b strings.Builder
+	b.WriteString("[")
+	for r, row := range rows {
+		if r > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for i, column := range columns {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			key, _ := json.Marshal(column.Name)
+			value, err := json.Marshal(row[i])
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteString(": ")
+			b.Write(value)
+		}
+		b.WriteString("}")
+	}
+	if len(rows) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func printTables(w io.Writer, format string, tables []storage.TableInfo) error {
+	columns := []storage.Column{
+		{Name: "name", Type: storage.TypeVarchar},
+		{Name: "columns", Type: storage.TypeInt},
+		{Name: "rows", Type: storage.TypeInt},
+		{Name: "pages", Type: storage.TypeInt},
+	}
+	rows := make([][]any, len(tables))
+	for i, t := range tables {
+		rows[i] = []any{t.Name, int64(len(t.Columns)), t.RowCount, t.PageCount}
+	}
+	return printRows(w, format, columns, rows)
+}
+
+func printColumns(w io.Writer, format string, info storage.TableInfo) error {
+	columns := []storage.Column{
+		{Name: "column", Type: storage.TypeVarchar},
+		{Name: "type", Type: storage.TypeVarchar},
+		{Name: "default", Type: storage.TypeJSON},
+	}
+	rows := make([][]any, len(info.Columns))
+	for i, c := range info.Columns {
+		typ := c.Type.String()
+		if c.Type == storage.TypeVarchar {
+			typ = fmt.Sprintf("varchar(%d)", c.Length)
+		}
+		rows[i] = []any{c.Name, typ, c.Default}
+	}
+	return printRows(w, format, columns, rows)
+}
@@ -0,0 +1,153 @@
+// Command csv imports CSV files into a table and exports tables as CSV,
+// working directly on a data directory. The server must not be running on
+// the same directory at the same time.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"rdbms/src/dataio"
+	"rdbms/src/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "csv:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: csv import|export -data DIR -table NAME [flags]")
+	os.Exit(2)
+}
+
+func runImport(args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dataDir := flags.String("data", "data", "data directory")
+	table := flags.String("table", "", "table to import into")
+	file := flags.String("file", "-", "CSV file to import, - for stdin")
+	rejectsFile := flags.String("rejects", "", "write rejected rows and the reason to this file")
+	delimiter := flags.String("delimiter", ",", "field delimiter, \\t for tab")
+	quote := flags.String("quote", `"`, "quote character")
+	flags.Parse(args)
+
+	if *table == "" {
+		return fmt.Errorf("-table is required")
+	}
+	opts, err := dataio.ParseCSVOptions(*delimiter, *quote)
+	if err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var rejects io.Writer
+	if *rejectsFile != "" {
+		f, err := os.Create(*rejectsFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rejects = f
+	}
+
+	tm, err := storage.NewTableManager(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := tm.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	result, err := dataio.ImportCSV(tm, *table, in, opts, rejects)
+	fmt.Printf("inserted %d rows, rejected %d\n", result.Inserted, result.Rejected)
+	return err
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dataDir := flags.String("data", "data", "data directory")
+	table := flags.String("table", "", "table to export")
+	file := flags.String("file", "-", "CSV file to write, - for stdout")
+	columns := flags.String("columns", "", "comma separated columns to export, all by default")
+	delimiter := flags.String("delimiter", ",", "field delimiter, \\t for tab")
+	quote := flags.String("quote", `"`, "quote character")
+	flags.Parse(args)
+
+	if *table == "" {
+		return fmt.Errorf("-table is required")
+	}
+	opts, err := dataio.ParseCSVOptions(*delimiter, *quote)
+	if err != nil {
+		return err
+	}
+
+	tm, err := storage.NewTableManager(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer tm.Close()
+
+	schema, err := tm.GetTableSchema(*table + ".schema")
+	if err != nil {
+		return err
+	}
+
+	var selected []string
+	if *columns != "" {
+		selected = strings.Split(*columns, ",")
+	}
+	exportColumns, err := dataio.ExportColumns(schema, selected)
+	if err != nil {
+		return err
+	}
+
+	names := storage.SelectedColumns{}
+	for _, column := range exportColumns {
+		names.Columns = append(names.Columns, column.Name)
+	}
+	rows, err := tm.GetAllData(*table, nil, names)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return dataio.ExportCSV(out, exportColumns, rows, opts)
+}
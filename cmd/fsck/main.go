@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"rdbms/src/storage"
+)
+
+func main() {
+	dataDir := flag.String("data", "data", "data directory to check")
+	table := flag.String("table", "", "only check this table")
+	repair := flag.Bool("repair-fsm", false, "rewrite the .fsm files from the pages")
+	flag.Parse()
+
+	problems, err := run(*dataDir, *table, *repair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck:", err)
+		os.Exit(2)
+	}
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
+
+func run(dataDir string, only string, repair bool) (int, error) {
+	// checking reads the files as they are, repairing must keep the server
+	// and other tools out
+	if repair {
+		lock, err := storage.LockDataDir(dataDir)
+		if err != nil {
+			return 0, err
+		}
+		defer lock.Close()
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	problems := 0
+	tables := make(map[string]bool)
+	clean := false
+	for _, e := range entries {
+		name := e.Name()
+		if name == storage.CleanShutdownFileName {
+			clean = true
+		}
+		if strings.HasSuffix(name, storage.JournalSuffix) {
+			fmt.Printf("pending rename journal %s: start the server once to replay it\n", name)
+			problems++
+		}
+		if strings.HasSuffix(name, ".schema") {
+			tables[strings.TrimSuffix(name, ".schema")] = true
+		}
+	}
+
+	if !clean && len(tables) > 0 {
+		fmt.Println("no clean shutdown marker: the server was killed or is still running; free space maps are checked on its next start")
+	}
+
+	catalog, err := readCatalog(dataDir)
+	if err != nil {
+		fmt.Printf("catalog: %v\n", err)
+		problems++
+	} else {
+		for name := range catalog.Tables {
+			if !tables[name] {
+				fmt.Printf("catalog: table %s has no .schema file\n", name)
+				problems++
+			}
+		}
+		for name := range tables {
+			if _, ok := catalog.Tables[name]; !ok {
+				fmt.Printf("catalog: files of table %s have no catalog entry and will be removed on start\n", name)
+				problems++
+			}
+		}
+	}
+
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		if only == "" || name == only {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		files, err := readTableFiles(dataDir, name)
+		if err != nil {
+			return problems, err
+		}
+
+		check := storage.CheckTable(files)
+		fmt.Printf("table %s: %d pages, %d records, %d problems\n", check.Table, check.Pages, check.Records, len(check.Problems))
+		for _, p := range check.Problems {
+			fmt.Printf("  %s\n", p)
+		}
+		problems += len(check.Problems)
+
+		if repair && check.FSMMismatch {
+			if err := os.WriteFile(filepath.Join(dataDir, name+".fsm"), check.FSM, 0666); err != nil {
+				return problems, err
+			}
+			fmt.Printf("  repaired %s.fsm from %d pages\n", name, check.Pages)
+		}
+	}
+
+	if problems == 0 {
+		fmt.Println("no problems found")
+	}
+	return problems, nil
+}
+
+func readCatalog(dataDir string) (*storage.Catalog, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, storage.CatalogFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &storage.Catalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+func readTableFiles(dataDir string, name string) (storage.TableFiles, error) {
+	files := storage.TableFiles{Name: name}
+	targets := map[string]*[]byte{
+		".schema": &files.Schema,
+		".table":  &files.Table,
+		".fsm":    &files.FSM,
+		".toast":  &files.Toast,
+	}
+
+	for ext, target := range targets {
+		data, err := os.ReadFile(filepath.Join(dataDir, name+ext))
+		if err != nil && !os.IsNotExist(err) {
+			return files, err
+		}
+		*target = data
+	}
+
+	return files, nil
+}
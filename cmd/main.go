@@ -1,27 +1,238 @@
+// Command main runs the HTTP server, and the PostgreSQL protocol and gRPC
+// servers when they are given an address, on a data directory, configured
+// as described in package config:
+//
+//	go run ./cmd -config rdbms.yaml -log-level debug
+//
+// On SIGINT or SIGTERM it stops accepting connections, lets in-flight
+// requests finish and closes the data directory, leaving the clean shutdown
+// marker the next start checks for.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"rdbms/api"
+	"rdbms/api/auth"
+	"rdbms/api/certs"
+	"rdbms/api/grpcserver"
 	"rdbms/api/handlers"
+	"rdbms/api/pgwire"
+	"rdbms/config"
 	"rdbms/src"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(2)
+	}
+	level, _ := cfg.Level()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	if level > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
 	var stg src.StorageI
-	stg, err := src.NewStorage("data")
+	stg, err = src.NewStorage(cfg.DataDir)
 
 	if err != nil {
 		panic(err)
 	}
 
+	a, err := newAuthenticator(cfg.DataDir, cfg.JWT)
+	if err != nil {
+		panic(err)
+	}
+	if cfg.HTTP.TLS.ClientCertsEnabled() {
+		a.ClientCertUser = cfg.HTTP.TLS.ClientUserField
+	}
+
+	// listeners report here when they stop on their own
+	serveErr := make(chan error, 3)
+
+	var pg *pgwire.Server
+	if cfg.PGWire.Addr != "" {
+		pg = pgwire.NewServer(stg)
+		pg.Auth = a
+		pg.ReadBufferSize = int(cfg.PGWire.ReadBufferSize)
+		pg.WriteBufferSize = int(cfg.PGWire.WriteBufferSize)
+		pg.MaxMessageSize = int(cfg.PGWire.MaxMessageBytes)
+		go func() {
+			if err := pg.ListenAndServe(cfg.PGWire.Addr); err != nil {
+				serveErr <- fmt.Errorf("pgwire: %w", err)
+			}
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPC.Addr != "" {
+		grpcServer = grpcserver.NewGRPCServer(stg, a,
+			grpc.ReadBufferSize(int(cfg.GRPC.ReadBufferSize)),
+			grpc.WriteBufferSize(int(cfg.GRPC.WriteBufferSize)),
+			grpc.MaxRecvMsgSize(int(cfg.GRPC.MaxMessageBytes)),
+		)
+		go func() {
+			l, err := net.Listen("tcp", cfg.GRPC.Addr)
+			if err == nil {
+				err = grpcServer.Serve(l)
+			}
+			if err != nil {
+				serveErr <- fmt.Errorf("grpc: %w", err)
+			}
+		}()
+	}
+
 	h := handlers.NewHandler(stg)
+	h.Auth = a
 
-	r := api.SetUpRouter(h)
+	r := api.SetUpRouter(h, api.Options{
+		AllowedOrigins: cfg.HTTP.AllowedOrigins,
+		MaxBodyBytes:   int64(cfg.HTTP.MaxBodyBytes),
+		Logging:        level <= slog.LevelInfo,
+	})
 	server := &http.Server{
-		Addr:    ":8000",
-		Handler: r,
+		Addr:           cfg.HTTP.Addr,
+		Handler:        r,
+		MaxHeaderBytes: int(cfg.HTTP.MaxHeaderBytes),
+	}
+	if cfg.HTTP.TLS.Enabled() {
+		reloader, err := certs.NewReloader(cfg.HTTP.TLS.CertOptions())
+		if err != nil {
+			panic(err)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- fmt.Errorf("http: %w", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Printf("shutting down, interrupt again to exit at once")
+	case err := <-serveErr:
+		log.Printf("%v; shutting down", err)
+		exitCode = 1
+	}
+	stop()
+
+	if err := shutdown(server, pg, grpcServer, stg); err != nil {
+		log.Printf("shutdown: %v", err)
+		exitCode = 1
 	}
-	server.ListenAndServe()
+	os.Exit(exitCode)
+}
+
+// shutdownTimeout bounds how long in-flight requests may take to finish.
+const shutdownTimeout = 30 * time.Second
+
+// shutdown drains the listeners, then syncs and closes the data files. The
+// storage is closed even when requests outlive shutdownTimeout, as their
+// connections are closed by then.
+func shutdown(server *http.Server, pg *pgwire.Server, grpcServer *grpc.Server, stg src.StorageI) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if errs[0] = server.Shutdown(ctx); errs[0] != nil {
+			server.Close()
+		}
+	}()
+	if pg != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[1] = pg.Shutdown(ctx)
+		}()
+	}
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				grpcServer.Stop()
+				errs[2] = fmt.Errorf("grpc: %w", ctx.Err())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := stg.Close(); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	return errors.Join(errs...)
+}
+
+// newAuthenticator reads the API keys, grants and row-level security
+// policies of the data directory. Bearer tokens are accepted when the JWT
+// settings have a secret or public key.
+func newAuthenticator(dataDir string, cfg config.JWTConfig) (*auth.Authenticator, error) {
+	keys, err := auth.OpenKeyStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := auth.OpenGrantStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := auth.OpenPolicyStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwt *auth.JWTConfig
+	if cfg.Enabled() {
+		jwt = &auth.JWTConfig{
+			Secret:   []byte(cfg.Secret),
+			Issuer:   cfg.Issuer,
+			Audience: cfg.Audience,
+			Leeway:   time.Minute,
+		}
+		if cfg.PublicKeyFile != "" {
+			if jwt.PublicKey, err = auth.LoadRSAPublicKey(cfg.PublicKeyFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return auth.NewAuthenticator(keys, grants, policies, jwt), nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"rdbms/src/storage"
+)
+
+func main() {
+	dataDir := flag.String("data", "data", "data directory")
+	table := flag.String("table", "", "table to inspect")
+	pageNo := flag.Int("page", 1, "page number, starting at 1")
+	format := flag.String("format", "text", "output format: text or json")
+	withHex := flag.Bool("hex", false, "include a hex dump of the page")
+	flag.Parse()
+
+	if *table == "" {
+		fmt.Fprintln(os.Stderr, "pageinspect: -table is required")
+		os.Exit(2)
+	}
+
+	inspection, err := inspect(*dataDir, *table, *pageNo, *withHex)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pageinspect:", err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(inspection)
+	case "text":
+		printText(inspection)
+	default:
+		fmt.Fprintln(os.Stderr, "pageinspect: unknown format", *format)
+		os.Exit(2)
+	}
+}
+
+func inspect(dataDir string, table string, pageNo int, withHex bool) (storage.PageInspection, error) {
+	if pageNo < 1 {
+		return storage.PageInspection{}, fmt.Errorf("page numbers start at 1")
+	}
+
+	schema, err := os.ReadFile(filepath.Join(dataDir, table+".schema"))
+	if err != nil {
+		return storage.PageInspection{}, err
+	}
+	history, err := storage.DeserializeSchemaHistory(schema)
+	if err != nil {
+		return storage.PageInspection{}, fmt.Errorf("%s.schema: %w", table, err)
+	}
+
+	heap, err := os.Open(filepath.Join(dataDir, table+".table"))
+	if err != nil {
+		return storage.PageInspection{}, err
+	}
+	defer heap.Close()
+
+	page := make([]byte, storage.PageSize)
+	if _, err := heap.ReadAt(page, int64(pageNo-1)*storage.PageSize); err != nil {
+		if err == io.EOF {
+			return storage.PageInspection{}, fmt.Errorf("table %s has no page %d", table, pageNo)
+		}
+		return storage.PageInspection{}, err
+	}
+
+	var fsm *int
+	if data, err := os.ReadFile(filepath.Join(dataDir, table+".fsm")); err == nil && len(data) >= pageNo*2 {
+		free := int(binary.LittleEndian.Uint16(data[(pageNo-1)*2:]))
+		fsm = &free
+	}
+
+	toast, err := os.ReadFile(filepath.Join(dataDir, table+".toast"))
+	if err != nil && !os.IsNotExist(err) {
+		return storage.PageInspection{}, err
+	}
+
+	return storage.InspectPage(table, pageNo, page, fsm, history, storage.ToastReaderFromBytes(toast), withHex), nil
+}
+
+func printText(p storage.PageInspection) {
+	fmt.Printf("table %s page %d\n", p.Table, p.Page)
+	fmt.Printf("  record count:       %d\n", p.RecordCount)
+	fmt.Printf("  free space pointer: %d\n", p.FreeSpacePointer)
+	if p.FSMFreeSpace != nil {
+		fmt.Printf("  free space:         %d (fsm: %d)\n", p.FreeSpace, *p.FSMFreeSpace)
+	} else {
+		fmt.Printf("  free space:         %d (fsm: missing)\n", p.FreeSpace)
+	}
+	fmt.Printf("  checksum:           %08x (computed %08x)\n", p.Checksum, p.ComputedChecksum)
+	fmt.Printf("  lsn:                %d\n", p.LSN)
+	if p.VerifyError != "" {
+		fmt.Printf("  verify error:       %s\n", p.VerifyError)
+	}
+
+	fmt.Println("  slots:")
+	for _, s := range p.Slots {
+		fmt.Printf("    [%d] offset %d length %d version %d", s.Slot, s.Offset, s.Length, s.SchemaVersion)
+		if s.Error != "" {
+			fmt.Printf("  error: %s", s.Error)
+		}
+		fmt.Println()
+
+		names := make([]string, 0, len(s.Values))
+		for name := range s.Values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, _ := json.Marshal(s.Values[name])
+			fmt.Printf("        %s = %s\n", name, value)
+		}
+	}
+
+	if p.Hex != "" {
+		fmt.Println()
+		fmt.Print(p.Hex)
+	}
+}
@@ -0,0 +1,128 @@
+// Command parquet imports Parquet files into a table and exports tables as
+// Parquet, working directly on a data directory. The server must not be
+// running on the same directory at the same time.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"rdbms/src/dataio"
+	"rdbms/src/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "parquet:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: parquet import|export -data DIR -table NAME -file FILE [flags]")
+	os.Exit(2)
+}
+
+func runImport(args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dataDir := flags.String("data", "data", "data directory")
+	table := flags.String("table", "", "table to import into")
+	file := flags.String("file", "", "Parquet file to import")
+	create := flags.Bool("create", false, "create the table from the file schema if it does not exist")
+	flags.Parse(args)
+
+	if *table == "" || *file == "" {
+		return fmt.Errorf("-table and -file are required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tm, err := storage.NewTableManager(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := tm.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	result, err := dataio.ImportParquet(tm, *table, f, *create)
+	fmt.Printf("inserted %d rows, rejected %d\n", result.Inserted, result.Rejected)
+	for _, e := range result.Errors {
+		fmt.Println(e)
+	}
+	return err
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dataDir := flags.String("data", "data", "data directory")
+	table := flags.String("table", "", "table to export")
+	file := flags.String("file", "", "Parquet file to write")
+	columns := flags.String("columns", "", "comma separated columns to export, all by default")
+	rowGroupSize := flags.Int("row-group-size", dataio.DefaultRowGroupSize, "rows per row group")
+	flags.Parse(args)
+
+	if *table == "" || *file == "" {
+		return fmt.Errorf("-table and -file are required")
+	}
+
+	tm, err := storage.NewTableManager(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer tm.Close()
+
+	schema, err := tm.GetTableSchema(*table + ".schema")
+	if err != nil {
+		return err
+	}
+
+	var selected []string
+	if *columns != "" {
+		selected = strings.Split(*columns, ",")
+	}
+	exportColumns, err := dataio.ExportColumns(schema, selected)
+	if err != nil {
+		return err
+	}
+
+	names := storage.SelectedColumns{}
+	for _, column := range exportColumns {
+		names.Columns = append(names.Columns, column.Name)
+	}
+	rows, err := tm.GetAllData(*table, nil, names)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	if err := dataio.ExportParquet(f, exportColumns, rows, *rowGroupSize); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,442 @@
+// Package config holds the settings of the server in cmd/main.go. They are
+// read, from lowest to highest precedence, from the defaults, a YAML or TOML
+// file, RDBMS_* environment variables and command-line flags:
+//
+//	server -config rdbms.yaml -http-addr :8443 -tls-cert cert.pem -tls-key key.pem
+//	RDBMS_DATA_DIR=/var/lib/rdbms RDBMS_ALLOWED_ORIGINS=https://app.example.com server
+//
+// A YAML file looks like:
+//
+//	data_dir: /var/lib/rdbms
+//	log_level: info
+//	http:
+//	  addr: :8000
+//	  allowed_origins: [https://app.example.com]
+//	  max_body_bytes: 64MiB
+//	  tls:
+//	    cert_file: cert.pem
+//	    key_file: key.pem
+//	    client_ca_file: clients-ca.pem
+//	    client_auth: optional
+//	    client_user_field: cn
+//	pgwire:
+//	  addr: 127.0.0.1:5432
+//	grpc:
+//	  addr: 127.0.0.1:9090
+//	jwt:
+//	  issuer: https://issuer.example.com
+//
+// The TOML file has the same keys. Every setting has a flag and an
+// environment variable, listed by -help.
+//
+// Only the HTTP API listens by default. The PostgreSQL protocol and gRPC
+// listeners start once given an address, as above or with -pgwire-addr
+// 127.0.0.1:5432 and RDBMS_GRPC_ADDR=127.0.0.1:9090. They take the same
+// credentials as the HTTP API but have no TLS, so API keys cross the
+// network in the clear: keep them on localhost or a private network.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rdbms/api/auth"
+	"rdbms/api/certs"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// EnvPrefix starts the environment variables of the settings.
+const EnvPrefix = "RDBMS_"
+
+type Config struct {
+	DataDir string `yaml:"data_dir" toml:"data_dir"`
+	// LogLevel is debug, info, warn or error. Requests are logged at info.
+	LogLevel string       `yaml:"log_level" toml:"log_level"`
+	HTTP     HTTPConfig   `yaml:"http" toml:"http"`
+	PGWire   PGWireConfig `yaml:"pgwire" toml:"pgwire"`
+	GRPC     GRPCConfig   `yaml:"grpc" toml:"grpc"`
+	JWT      JWTConfig    `yaml:"jwt" toml:"jwt"`
+}
+
+type HTTPConfig struct {
+	Addr string    `yaml:"addr" toml:"addr"`
+	TLS  TLSConfig `yaml:"tls" toml:"tls"`
+	// AllowedOrigins are the origins browsers may call the API from, with
+	// credentials. "*" allows every origin, without credentials. Empty
+	// allows none.
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	MaxBodyBytes   ByteSize `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	MaxHeaderBytes ByteSize `yaml:"max_header_bytes" toml:"max_header_bytes"`
+}
+
+// TLSConfig serves HTTPS when both files are set. The files are reloaded
+// when they change.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	// ClientCAFile is the PEM file of the CAs client certificates are
+	// verified with.
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+	// ClientAuth is none, optional (verify certificates clients send) or
+	// require. Requests with a verified certificate and no API key or
+	// token run as the user ClientUserField maps it to.
+	ClientAuth string `yaml:"client_auth" toml:"client_auth"`
+	// ClientUserField is the field of the certificate the user is taken
+	// from: cn, email or dn.
+	ClientUserField string `yaml:"client_user_field" toml:"client_user_field"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// CertOptions returns the options of the certificate reloader.
+func (t TLSConfig) CertOptions() certs.Options {
+	return certs.Options{
+		CertFile:     t.CertFile,
+		KeyFile:      t.KeyFile,
+		ClientCAFile: t.ClientCAFile,
+		ClientAuth:   clientAuthTypes[t.ClientAuth],
+	}
+}
+
+// ClientCertsEnabled reports whether client certificates authenticate.
+func (t TLSConfig) ClientCertsEnabled() bool {
+	return t.Enabled() && t.ClientAuth != "none"
+}
+
+// PGWireConfig configures the PostgreSQL protocol listener. An empty Addr
+// disables it.
+type PGWireConfig struct {
+	Addr            string   `yaml:"addr" toml:"addr"`
+	ReadBufferSize  ByteSize `yaml:"read_buffer_size" toml:"read_buffer_size"`
+	WriteBufferSize ByteSize `yaml:"write_buffer_size" toml:"write_buffer_size"`
+	MaxMessageBytes ByteSize `yaml:"max_message_bytes" toml:"max_message_bytes"`
+}
+
+// GRPCConfig configures the gRPC listener. An empty Addr disables it.
+type GRPCConfig struct {
+	Addr            string   `yaml:"addr" toml:"addr"`
+	ReadBufferSize  ByteSize `yaml:"read_buffer_size" toml:"read_buffer_size"`
+	WriteBufferSize ByteSize `yaml:"write_buffer_size" toml:"write_buffer_size"`
+	MaxMessageBytes ByteSize `yaml:"max_message_bytes" toml:"max_message_bytes"`
+}
+
+// JWTConfig accepts bearer tokens signed with Secret (HS256) or with the
+// key in the PEM file PublicKeyFile (RS256). Issuer and Audience, when set,
+// must match the iss and aud claims.
+type JWTConfig struct {
+	Secret        string `yaml:"secret" toml:"secret"`
+	PublicKeyFile string `yaml:"public_key_file" toml:"public_key_file"`
+	Issuer        string `yaml:"issuer" toml:"issuer"`
+	Audience      string `yaml:"audience" toml:"audience"`
+}
+
+func (j JWTConfig) Enabled() bool {
+	return j.Secret != "" || j.PublicKeyFile != ""
+}
+
+// Default returns the settings used when nothing overrides them.
+func Default() Config {
+	return Config{
+		DataDir:  "data",
+		LogLevel: "info",
+		HTTP: HTTPConfig{
+			Addr:           ":8000",
+			MaxBodyBytes:   64 << 20,
+			MaxHeaderBytes: 1 << 20,
+			TLS:            TLSConfig{ClientAuth: "none", ClientUserField: auth.CertUserCN},
+		},
+		PGWire: PGWireConfig{
+			ReadBufferSize:  4 << 10,
+			WriteBufferSize: 4 << 10,
+			MaxMessageBytes: 64 << 20,
+		},
+		GRPC: GRPCConfig{
+			ReadBufferSize:  32 << 10,
+			WriteBufferSize: 32 << 10,
+			MaxMessageBytes: 4 << 20,
+		},
+	}
+}
+
+// setting is a value settable by a flag and an environment variable.
+type setting struct {
+	flag  string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+// env returns the environment variable of the setting: -http-addr is
+// RDBMS_HTTP_ADDR.
+func (s setting) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.flag, "-", "_"))
+}
+
+var settings = []setting{
+	{"data-dir", "data directory", func(c *Config) flag.Value { return (*stringValue)(&c.DataDir) }},
+	{"log-level", "log level: debug, info, warn or error", func(c *Config) flag.Value { return (*stringValue)(&c.LogLevel) }},
+	{"http-addr", "HTTP listen address", func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.Addr) }},
+	{"tls-cert", "TLS certificate file of the HTTP listener", func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.TLS.CertFile) }},
+	{"tls-key", "TLS key file of the HTTP listener", func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.TLS.KeyFile) }},
+	{"tls-client-ca", "CA file client certificates are verified with", func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.TLS.ClientCAFile) }},
+	{"tls-client-auth", "client certificates: none, optional or require", func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.TLS.ClientAuth) }},
+	{"tls-client-user-field", "field of client certificates naming the user: cn, email or dn", func(c *Config) flag.Value { return (*stringValue)(&c.HTTP.TLS.ClientUserField) }},
+	{"allowed-origins", "comma-separated origins allowed by CORS, or *", func(c *Config) flag.Value { return (*listValue)(&c.HTTP.AllowedOrigins) }},
+	{"max-body-bytes", "largest HTTP request body", func(c *Config) flag.Value { return &c.HTTP.MaxBodyBytes }},
+	{"max-header-bytes", "largest HTTP request header", func(c *Config) flag.Value { return &c.HTTP.MaxHeaderBytes }},
+	{"pgwire-addr", "PostgreSQL protocol listen address, e.g. 127.0.0.1:5432; disabled when empty", func(c *Config) flag.Value { return (*stringValue)(&c.PGWire.Addr) }},
+	{"pgwire-read-buffer-size", "read buffer size of PostgreSQL protocol connections", func(c *Config) flag.Value { return &c.PGWire.ReadBufferSize }},
+	{"pgwire-write-buffer-size", "write buffer size of PostgreSQL protocol connections", func(c *Config) flag.Value { return &c.PGWire.WriteBufferSize }},
+	{"pgwire-max-message-bytes", "largest PostgreSQL protocol message", func(c *Config) flag.Value { return &c.PGWire.MaxMessageBytes }},
+	{"grpc-addr", "gRPC listen address, e.g. 127.0.0.1:9090; disabled when empty", func(c *Config) flag.Value { return (*stringValue)(&c.GRPC.Addr) }},
+	{"grpc-read-buffer-size", "read buffer size of gRPC connections", func(c *Config) flag.Value { return &c.GRPC.ReadBufferSize }},
+	{"grpc-write-buffer-size", "write buffer size of gRPC connections", func(c *Config) flag.Value { return &c.GRPC.WriteBufferSize }},
+	{"grpc-max-message-bytes", "largest gRPC request message", func(c *Config) flag.Value { return &c.GRPC.MaxMessageBytes }},
+	{"jwt-secret", "HS256 secret of bearer tokens; prefer the environment variable", func(c *Config) flag.Value { return (*stringValue)(&c.JWT.Secret) }},
+	{"jwt-public-key", "PEM file of the RS256 public key of bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.JWT.PublicKeyFile) }},
+	{"jwt-issuer", "required iss claim of bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.JWT.Issuer) }},
+	{"jwt-audience", "required aud claim of bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.JWT.Audience) }},
+}
+
+// Load returns the validated settings for the command-line arguments args,
+// reading environment variables with lookupEnv, normally os.LookupEnv. A
+// variable set to an empty string overrides the file, so RDBMS_GRPC_ADDR=
+// disables a gRPC listener the file enables. The file is named by the -config flag or RDBMS_CONFIG; its
+// format follows its extension.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	defaults := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	defaultFile, _ := lookupEnv(EnvPrefix + "CONFIG")
+	configFile := fs.String("config", defaultFile, "YAML or TOML config file (env "+EnvPrefix+"CONFIG)")
+	flags := make(map[string]*flagValue, len(settings))
+	for _, s := range settings {
+		flags[s.flag] = &flagValue{def: s.value(&defaults).String()}
+		fs.Var(flags[s.flag], s.flag, s.usage+" (env "+s.env()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	c := defaults
+	if *configFile != "" {
+		if err := c.readFile(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env()); ok {
+			if err := s.value(&c).Set(v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.env(), err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if f := flags[s.flag]; f.set {
+			if err := s.value(&c).Set(f.raw); err != nil {
+				return Config{}, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// readFile overrides c with the settings of a .yaml, .yml or .toml file.
+// Unknown keys are errors.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalWithOptions(data, c, yaml.Strict())
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		d := toml.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(c)
+	default:
+		return fmt.Errorf("config file %s: unknown format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports the first invalid setting.
+func (c Config) Validate() error {
+	if c.DataDir == "" {
+		return errors.New("data directory is required")
+	}
+	if _, err := c.Level(); err != nil {
+		return err
+	}
+
+	if c.HTTP.Addr == "" {
+		return errors.New("http address is required")
+	}
+	addrs := map[string]string{"http": c.HTTP.Addr, "pgwire": c.PGWire.Addr, "grpc": c.GRPC.Addr}
+	for name, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("%s address: %w", name, err)
+		}
+	}
+	if c.PGWire.Addr != "" && (c.PGWire.Addr == c.HTTP.Addr || c.PGWire.Addr == c.GRPC.Addr) ||
+		c.GRPC.Addr != "" && c.GRPC.Addr == c.HTTP.Addr {
+		return errors.New("listeners must use different addresses")
+	}
+
+	if _, ok := clientAuthTypes[c.HTTP.TLS.ClientAuth]; !ok {
+		return fmt.Errorf("tls client auth %q: expected none, optional or require", c.HTTP.TLS.ClientAuth)
+	}
+	if !auth.ValidCertUserField(c.HTTP.TLS.ClientUserField) {
+		return fmt.Errorf("tls client user field %q: expected one of %v", c.HTTP.TLS.ClientUserField, auth.CertUserFields)
+	}
+	if c.HTTP.TLS.Enabled() {
+		if c.HTTP.TLS.CertFile == "" || c.HTTP.TLS.KeyFile == "" {
+			return errors.New("tls needs both a certificate and a key file")
+		}
+		if c.HTTP.TLS.ClientCertsEnabled() && c.HTTP.TLS.ClientCAFile == "" {
+			return errors.New("tls client auth needs a client CA file")
+		}
+		if _, err := certs.Load(c.HTTP.TLS.CertOptions()); err != nil {
+			return err
+		}
+	} else if c.HTTP.TLS.ClientAuth != "none" || c.HTTP.TLS.ClientCAFile != "" {
+		return errors.New("client certificates need a tls certificate and key")
+	}
+
+	for _, origin := range c.HTTP.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			return err
+		}
+	}
+
+	sizes := map[string]ByteSize{
+		"http max body bytes":      c.HTTP.MaxBodyBytes,
+		"http max header bytes":    c.HTTP.MaxHeaderBytes,
+		"pgwire read buffer size":  c.PGWire.ReadBufferSize,
+		"pgwire write buffer size": c.PGWire.WriteBufferSize,
+		"pgwire max message bytes": c.PGWire.MaxMessageBytes,
+		"grpc read buffer size":    c.GRPC.ReadBufferSize,
+		"grpc write buffer size":   c.GRPC.WriteBufferSize,
+		"grpc max message bytes":   c.GRPC.MaxMessageBytes,
+	}
+	for name, size := range sizes {
+		if size <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	if c.PGWire.MaxMessageBytes > 1<<30 {
+		return errors.New("pgwire max message bytes must be at most 1GiB")
+	}
+
+	if !c.JWT.Enabled() && (c.JWT.Issuer != "" || c.JWT.Audience != "") {
+		return errors.New("jwt issuer and audience need a jwt secret or public key")
+	}
+	if c.JWT.PublicKeyFile != "" {
+		if _, err := os.Stat(c.JWT.PublicKeyFile); err != nil {
+			return fmt.Errorf("jwt public key: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateOrigin accepts "*" and origins like https://app.example.com:8080.
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("allowed origin %q is not * or scheme://host[:port]", origin)
+	}
+	return nil
+}
+
+// Level returns the parsed LogLevel.
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("log level %q: expected debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
+
+// flagValue records a flag, to apply it after the file and the environment.
+type flagValue struct {
+	def string
+	raw string
+	set bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	f.raw, f.set = s, true
+	return nil
+}
+
+type stringValue string
+
+func (s *stringValue) String() string { return string(*s) }
+
+func (s *stringValue) Set(v string) error {
+	*s = stringValue(v)
+	return nil
+}
+
+// listValue is a comma-separated list.
+type listValue []string
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+func (l *listValue) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+// Package db embeds the storage engine in a Go program, without the HTTP
+// server. Values are validated like the HTTP handlers validate them, but
+// are passed as Go values instead of JSON:
+//
+//	d, err := db.Open("data", db.Options{})
+//	defer d.Close()
+//	err = d.CreateTable("users", db.Column{Name: "id", Type: db.Int}, db.Column{Name: "name", Type: db.Varchar, Length: 64})
+//	err = d.Insert("users", map[string]any{"id": 1, "name": "ann"})
+//	rows, err := d.Query("users", db.Query{Where: []db.Filter{{Column: "id", Operator: db.Eq, Value: 1}}})
+//
+// The package also registers a database/sql driver named "rdbms", see
+// driver.go.
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"rdbms/api/models"
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+type ColumnType = storage.ColumnType
+
+const (
+	Int       = storage.TypeInt
+	Varchar   = storage.TypeVarchar
+	Date      = storage.TypeDate
+	Timestamp = storage.TypeTimestamp
+	Float     = storage.TypeFloat
+	JSON      = storage.TypeJSON
+)
+
+type Operator = storage.FilterOperator
+
+const (
+	Eq = storage.OpEq
+	Ne = storage.OpNe
+)
+
+// DateLayout is the layout of date values in text form.
+const DateLayout = "2006-01-02"
+
+// ErrClosed is returned by the methods of a closed DB.
+var ErrClosed = storage.ErrClosed
+
+type Options struct {
+	// DisableToastCompression stores large varchar and json values
+	// uncompressed.
+	DisableToastCompression bool
+}
+
+// DB is an open data directory. It is safe for concurrent use.
+type DB struct {
+	dir string
+	tm  *storage.TableManager
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+var (
+	openMu sync.Mutex
+	// openDirs holds the data directories open in this process. Two
+	// managers on the same files would overwrite each other's pages.
+	openDirs = map[string]bool{}
+)
+
+// Open opens the data directory dir, creating it if it does not exist. A
+// directory can only be open once; Open fails with storage.ErrLocked while
+// another process, like the server, has it open.
+func Open(dir string, opts Options) (*DB, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	if openDirs[abs] {
+		return nil, fmt.Errorf("data directory %s is already open", dir)
+	}
+
+	tm, err := storage.NewTableManager(abs)
+	if err != nil {
+		return nil, err
+	}
+	tm.CompressToast = !opts.DisableToastCompression
+
+	openDirs[abs] = true
+	return &DB{dir: abs, tm: tm}, nil
+}
+
+// Close waits for running calls, then flushes and closes the data files.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	err := d.tm.Close()
+
+	openMu.Lock()
+	delete(openDirs, d.dir)
+	openMu.Unlock()
+
+	return err
+}
+
+// Table returns the storage engine for the operations DB does not wrap,
+// like schema changes. It must not be used after Close.
+func (d *DB) Table() storage.TableI {
+	return d.tm
+}
+
+// use runs fn unless the DB is closed, keeping Close from closing the files
+// while fn runs.
+func (d *DB) use(fn func() error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	return fn()
+}
+
+type Column struct {
+	Name string
+	Type ColumnType
+	// Length is the maximum length of a varchar column, 255 if it is 0.
+	Length int
+}
+
+func (d *DB) CreateTable(name string, columns ...Column) error {
+	req := models.CreateTableRequest{Name: name}
+	for _, c := range columns {
+		column := models.CreateColumn{Name: c.Name, Type: int(c.Type)}
+		if c.Type == Varchar && c.Length > 0 {
+			length := c.Length
+			column.Length = &length
+		}
+		req.Columns = append(req.Columns, column)
+	}
+
+	schema, err := utils.ToStorageSchema(req)
+	if err != nil {
+		return err
+	}
+	return d.use(func() error {
+		return d.tm.CreateTable(name, &schema)
+	})
+}
+
+func (d *DB) DropTable(name string) error {
+	return d.use(func() error {
+		return d.tm.DropTable(name)
+	})
+}
+
+func (d *DB) Tables() ([]storage.TableInfo, error) {
+	var tables []storage.TableInfo
+	err := d.use(func() error {
+		tables = d.tm.ListTables()
+		return nil
+	})
+	return tables, err
+}
+
+// Insert inserts a row given by column name. Values are Go integers,
+// floats, strings, time.Time for date and timestamp columns, or anything
+// encoding/json can marshal for json columns. Missing and nil values take
+// the column default.
+func (d *DB) Insert(table string, values map[string]any) error {
+	return d.use(func() error {
+		record, err := d.record(table, values)
+		if err != nil {
+			return err
+		}
+		return d.tm.Insert(table, record)
+	})
+}
+
+// InsertBatch inserts many rows at once. Rows that fail validation or can
+// not be stored are reported by index in rejected and the rest are
+// inserted; err is set when the batch as a whole failed.
+func (d *DB) InsertBatch(table string, rows []map[string]any) (rejected map[int]error, err error) {
+	err = d.use(func() error {
+		schema, err := d.schema(table)
+		if err != nil {
+			return err
+		}
+
+		rejected = make(map[int]error)
+		records := make([]storage.Record, 0, len(rows))
+		index := make([]int, 0, len(rows))
+		for i, values := range rows {
+			record, err := toRecord(schema, values)
+			if err != nil {
+				rejected[i] = err
+				continue
+			}
+			records = append(records, record)
+			index = append(index, i)
+		}
+
+		failed, err := d.tm.InsertBatch(table, records)
+		if err != nil {
+			return err
+		}
+		for i, err := range failed {
+			rejected[index[i]] = err
+		}
+		return nil
+	})
+	return rejected, err
+}
+
+func (d *DB) schema(table string) (storage.Schema, error) {
+	schema, err := d.tm.GetTableSchema(table + ".schema")
+	if err != nil {
+		return storage.Schema{}, fmt.Errorf("table %s does not exist", table)
+	}
+	return schema, nil
+}
+
+func (d *DB) record(table string, values map[string]any) (storage.Record, error) {
+	schema, err := d.schema(table)
+	if err != nil {
+		return storage.Record{}, err
+	}
+	return toRecord(schema, values)
+}
+
+func toRecord(schema storage.Schema, values map[string]any) (storage.Record, error) {
+	converted := make(map[string]any, len(values))
+	for name, v := range values {
+		column, ok := schemaColumn(schema, name)
+		if !ok {
+			return storage.Record{}, errors.New("unknown column: " + name)
+		}
+		if v == nil {
+			continue
+		}
+		value, err := toValue(column, v)
+		if err != nil {
+			return storage.Record{}, err
+		}
+		converted[name] = value
+	}
+	return utils.ToStorageRecord(schema, converted)
+}
+
+func schemaColumn(schema storage.Schema, name string) (storage.Column, bool) {
+	for _, column := range schema.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return storage.Column{}, false
+}
+
+// toValue converts a Go value to the representation the validation in
+// utils takes for the column: integers as int64, other numbers as float64,
+// dates and timestamps as strings. Values it does not know are passed on
+// for utils to reject.
+func toValue(column storage.Column, v any) (any, error) {
+	if t, ok := v.(time.Time); ok {
+		switch column.Type {
+		case Date:
+			return t.Format(DateLayout), nil
+		case Timestamp:
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+	}
+	if column.Type == JSON {
+		// go through encoding/json so that structs are stored by their
+		// JSON field names
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid json for %s: %w", column.Name, err)
+		}
+		var decoded any
+		err = json.Unmarshal(b, &decoded)
+		return decoded, err
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if column.Type == Float {
+			return float64(rv.Int()), nil
+		}
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if column.Type == Float {
+			return float64(rv.Uint()), nil
+		}
+		if rv.Uint() > 1<<63-1 {
+			return nil, errors.New("column " + column.Name + " out of range")
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+	return v, nil
+}
+
+// Row is a query result row keyed by column name. Values are int64,
+// float64, string, time.Time for date and timestamp columns and the
+// decoded JSON for json columns.
+type Row map[string]any
+
+type Filter struct {
+	Column   string
+	Operator Operator
+	Value    any
+}
+
+type Query struct {
+	// Columns lists the columns to return, all of them if it is empty.
+	Columns []string
+	// Where filters are combined with AND.
+	Where []Filter
+	// Limit caps the number of rows returned unless it is 0.
+	Limit int
+}
+
+func (d *DB) Query(table string, q Query) ([]Row, error) {
+	var rows []Row
+	err := d.use(func() error {
+		schema, err := d.schema(table)
+		if err != nil {
+			return err
+		}
+
+		columns := schema.Columns
+		if len(q.Columns) > 0 {
+			columns = make([]storage.Column, len(q.Columns))
+			for i, name := range q.Columns {
+				column, ok := schemaColumn(schema, name)
+				if !ok {
+					return errors.New("unknown column: " + name)
+				}
+				columns[i] = column
+			}
+		}
+
+		filters := make([]storage.Filter, 0, len(q.Where))
+		for _, f := range q.Where {
+			value := f.Value
+			if column, ok := schemaColumn(schema, f.Column); ok && value != nil {
+				if value, err = toValue(column, value); err != nil {
+					return err
+				}
+			}
+			filters = append(filters, storage.Filter{Column: f.Column, Operator: string(f.Operator), Value: value})
+		}
+		filters, err = utils.SetFilterColumnIndexes(schema, filters)
+		if err != nil {
+			return err
+		}
+
+		selected := storage.SelectedColumns{}
+		for _, column := range columns {
+			selected.Columns = append(selected.Columns, column.Name)
+		}
+		data, err := d.tm.GetAllData(table, filters, selected)
+		if err != nil {
+			return err
+		}
+		if q.Limit > 0 && len(data) > q.Limit {
+			data = data[:q.Limit]
+		}
+
+		rows = make([]Row, len(data))
+		for i, values := range data {
+			row := make(Row, len(columns))
+			for _, column := range columns {
+				if row[column.Name], err = fromStorage(column, values[column.Name]); err != nil {
+					return err
+				}
+			}
+			rows[i] = row
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// fromStorage converts a value as returned by TableI.GetAllData to the Go
+// type Row documents.
+func fromStorage(column storage.Column, v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	switch column.Type {
+	case Date:
+		return time.Parse(DateLayout, s)
+	case Timestamp:
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return v, nil
+}
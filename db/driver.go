@@ -0,0 +1,348 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rdbms/src/query"
+	"rdbms/src/storage"
+)
+
+// DriverName is the name the database/sql driver is registered under. The
+// data source name is the data directory:
+//
+//	sqlDB, err := sql.Open("rdbms", "data")
+//	rows, err := sqlDB.Query("SELECT id, name FROM users WHERE id = ?", 1)
+//
+// Statements use the dialect of package query, with $n or ? parameters.
+// Transactions are not supported. A DB already opened with Open is used
+// through its SQL method instead.
+const DriverName = "rdbms"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+type Driver struct{}
+
+var (
+	connectorsMu sync.Mutex
+	// connectors shares one DB between the sql.DBs opened on a directory.
+	connectors = map[string]*connector{}
+)
+
+func (drv *Driver) Open(name string) (driver.Conn, error) {
+	c, err := drv.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector opens the data directory name, or shares it with the other
+// sql.DBs open on it. The directory is closed with the last of them.
+func (drv *Driver) OpenConnector(name string) (driver.Connector, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	connectorsMu.Lock()
+	defer connectorsMu.Unlock()
+
+	if c, ok := connectors[abs]; ok {
+		c.refs++
+		return c, nil
+	}
+
+	d, err := Open(abs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	c := &connector{db: d, dir: abs, refs: 1, shared: true}
+	connectors[abs] = c
+	return c, nil
+}
+
+// SQL returns a database/sql handle on the DB. Closing it leaves the DB
+// open.
+func (d *DB) SQL() *sql.DB {
+	return sql.OpenDB(&connector{db: d})
+}
+
+type connector struct {
+	db *DB
+
+	// shared connectors are counted in connectors and close the DB when
+	// the last reference goes away.
+	dir    string
+	refs   int
+	shared bool
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &Driver{}
+}
+
+// Close is called by sql.DB.Close.
+func (c *connector) Close() error {
+	if !c.shared {
+		return nil
+	}
+
+	connectorsMu.Lock()
+	defer connectorsMu.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	delete(connectors, c.dir)
+	return c.db.Close()
+}
+
+var (
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+)
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(sqlText string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), sqlText)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, sqlText string) (driver.Stmt, error) {
+	parsed, err := query.Parse(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, parsed: parsed}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *conn) ExecContext(ctx context.Context, sqlText string, args []driver.NamedValue) (driver.Result, error) {
+	s, err := c.PrepareContext(ctx, sqlText)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stmt).ExecContext(ctx, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, sqlText string, args []driver.NamedValue) (driver.Rows, error) {
+	s, err := c.PrepareContext(ctx, sqlText)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stmt).QueryContext(ctx, args)
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+type stmt struct {
+	conn   *conn
+	parsed query.Statement
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return query.Params(s.parsed)
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	result, err := s.execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(result.Tag), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := s.execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+func (s *stmt) execute(ctx context.Context, args []driver.NamedValue) (*query.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var result *query.Result
+	err := s.conn.db.use(func() error {
+		params, _, err := query.Describe(s.conn.db.tm, s.parsed)
+		if err != nil {
+			return err
+		}
+
+		text := make([]*string, len(params))
+		for _, arg := range args {
+			if arg.Ordinal < 1 || arg.Ordinal > len(params) {
+				return fmt.Errorf("statement has %d parameters, got argument %d", len(params), arg.Ordinal)
+			}
+			text[arg.Ordinal-1], err = paramText(params[arg.Ordinal-1], arg.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err = query.Execute(s.conn.db.tm, s.parsed, text)
+		return err
+	})
+	return result, err
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// paramText formats an argument in the text form query.Execute parses for
+// the column the parameter is compared with or inserted into.
+func paramText(column storage.Column, v driver.Value) (*string, error) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case time.Time:
+		if column.Type == Date {
+			s = v.Format(DateLayout)
+		} else {
+			s = v.UTC().Format(time.RFC3339Nano)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported argument type %T", v)
+	}
+	return &s, nil
+}
+
+// execResult reports the affected rows from a command tag like
+// "INSERT 0 3".
+type execResult string
+
+func (r execResult) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported")
+}
+
+func (r execResult) RowsAffected() (int64, error) {
+	fields := strings.Fields(string(r))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+	return n, nil
+}
+
+var _ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+
+type rows struct {
+	result *query.Result
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	names := make([]string, len(r.result.Columns))
+	for i, column := range r.result.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	row := r.result.Rows[r.next]
+	r.next++
+
+	for i, column := range r.result.Columns {
+		v, err := fromStorage(column, row[i])
+		if err != nil {
+			return err
+		}
+		if column.Type == JSON && v != nil {
+			if v, err = json.Marshal(v); err != nil {
+				return err
+			}
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	switch r.result.Columns[index].Type {
+	case Int:
+		return "INT8"
+	case Float:
+		return "FLOAT8"
+	case Date:
+		return "DATE"
+	case Timestamp:
+		return "TIMESTAMP"
+	case JSON:
+		return "JSON"
+	}
+	return "VARCHAR"
+}
@@ -0,0 +1,203 @@
+package dataio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/extensions"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+
+	"rdbms/src/storage"
+)
+
+// ArrowType is the Arrow type a column is exported as. Dates are days and
+// timestamps microseconds since the epoch, like their storage encoding, and
+// json columns use the canonical arrow.json extension type so Parquet files
+// get the JSON logical type.
+func ArrowType(column storage.Column) arrow.DataType {
+	switch column.Type {
+	case storage.TypeInt:
+		return arrow.PrimitiveTypes.Int64
+	case storage.TypeFloat:
+		return arrow.PrimitiveTypes.Float64
+	case storage.TypeDate:
+		return arrow.FixedWidthTypes.Date32
+	case storage.TypeTimestamp:
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	case storage.TypeJSON:
+		return jsonType
+	}
+	return arrow.BinaryTypes.String
+}
+
+var jsonType, _ = extensions.NewJSONType(arrow.BinaryTypes.String)
+
+func ArrowSchema(columns []storage.Column) *arrow.Schema {
+	fields := make([]arrow.Field, len(columns))
+	for i, column := range columns {
+		fields[i] = arrow.Field{Name: column.Name, Type: ArrowType(column), Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// ArrowRecord builds a record batch of schema, as made by ArrowSchema for
+// columns, from rows as returned by TableI.GetAllData.
+func ArrowRecord(mem memory.Allocator, schema *arrow.Schema, columns []storage.Column, rows []map[string]any) (arrow.Record, error) {
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+
+	for i, column := range columns {
+		field := builder.Field(i)
+		field.Reserve(len(rows))
+		for _, row := range rows {
+			if err := appendArrowValue(field, row[column.Name]); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+		}
+	}
+
+	return builder.NewRecord(), nil
+}
+
+func appendArrowValue(field array.Builder, value any) error {
+	if value == nil {
+		field.AppendNull()
+		return nil
+	}
+
+	switch b := field.(type) {
+	case *array.Int64Builder:
+		n, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("unexpected value %v", value)
+		}
+		b.Append(n)
+	case *array.Float64Builder:
+		f, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("unexpected value %v", value)
+		}
+		b.Append(f)
+	case *array.StringBuilder:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("unexpected value %v", value)
+		}
+		b.Append(s)
+	case *array.Date32Builder:
+		s, _ := value.(string)
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.TimestampBuilder:
+		s, _ := value.(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.ExtensionBuilder:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		b.StorageBuilder().(*array.StringBuilder).Append(string(raw))
+	default:
+		return fmt.Errorf("unsupported arrow builder %T", field)
+	}
+	return nil
+}
+
+// arrowValue converts value i of an Arrow array to the JSON decoded value
+// utils.ToStorageItem accepts, or nil for a null. Integers are int64, so
+// values beyond 2^53 keep their exact value.
+func arrowValue(arr arrow.Array, i int) (any, error) {
+	if arr.IsNull(i) {
+		return nil, nil
+	}
+
+	switch a := arr.(type) {
+	case *array.Int8:
+		return int64(a.Value(i)), nil
+	case *array.Int16:
+		return int64(a.Value(i)), nil
+	case *array.Int32:
+		return int64(a.Value(i)), nil
+	case *array.Int64:
+		return a.Value(i), nil
+	case *array.Uint8:
+		return int64(a.Value(i)), nil
+	case *array.Uint16:
+		return int64(a.Value(i)), nil
+	case *array.Uint32:
+		return int64(a.Value(i)), nil
+	case *array.Uint64:
+		n := a.Value(i)
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("%d does not fit a 64-bit signed integer", n)
+		}
+		return int64(n), nil
+	case *array.Float32:
+		return float64(a.Value(i)), nil
+	case *array.Float64:
+		return a.Value(i), nil
+	case *array.Boolean:
+		return a.Value(i), nil
+	case *array.String:
+		return a.Value(i), nil
+	case *array.LargeString:
+		return a.Value(i), nil
+	case *array.Binary:
+		return string(a.Value(i)), nil
+	case *extensions.JSONArray:
+		return a.Value(i), nil
+	case *array.Date32:
+		return a.Value(i).ToTime().Format("2006-01-02"), nil
+	case *array.Date64:
+		return a.Value(i).ToTime().Format("2006-01-02"), nil
+	case *array.Timestamp:
+		toTime, err := a.DataType().(*arrow.TimestampType).GetToTimeFunc()
+		if err != nil {
+			return nil, err
+		}
+		return toTime(a.Value(i)).UTC().Format(time.RFC3339Nano), nil
+	}
+
+	return nil, fmt.Errorf("unsupported arrow type %s", arr.DataType())
+}
+
+// ArrowBatchSize is the number of rows per record batch of an Arrow IPC
+// stream.
+const ArrowBatchSize = 64 * 1024
+
+// WriteArrowStream writes rows as returned by TableI.GetAllData to w as an
+// Arrow IPC stream of record batches with the schema of columns.
+func WriteArrowStream(w io.Writer, columns []storage.Column, rows []map[string]any) error {
+	mem := memory.DefaultAllocator
+	schema := ArrowSchema(columns)
+	writer := ipc.NewWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+
+	for start := 0; start < len(rows); start += ArrowBatchSize {
+		record, err := ArrowRecord(mem, schema, columns, rows[start:min(start+ArrowBatchSize, len(rows))])
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		err = writer.Write(record)
+		record.Release()
+		if err != nil {
+			writer.Close()
+			return err
+		}
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,234 @@
+// Package dataio moves table data in and out of the database in file
+// formats other tools understand. It is shared by the HTTP handlers and the
+// command line tools that work directly on a data directory.
+package dataio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVOptions configures the dialect of a CSV file.
+type CSVOptions struct {
+	Delimiter rune
+	Quote     rune
+}
+
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{Delimiter: ',', Quote: '"'}
+}
+
+// ParseCSVOptions builds options from the single character strings a user
+// passes; empty strings keep the defaults.
+func ParseCSVOptions(delimiter string, quote string) (CSVOptions, error) {
+	opts := DefaultCSVOptions()
+	if delimiter != "" {
+		r, err := singleRune(delimiter)
+		if err != nil {
+			return opts, fmt.Errorf("delimiter: %w", err)
+		}
+		opts.Delimiter = r
+	}
+	if quote != "" {
+		r, err := singleRune(quote)
+		if err != nil {
+			return opts, fmt.Errorf("quote: %w", err)
+		}
+		opts.Quote = r
+	}
+	if opts.Delimiter == opts.Quote {
+		return opts, errors.New("delimiter and quote must differ")
+	}
+	if opts.Delimiter == '\n' || opts.Delimiter == '\r' || opts.Quote == '\n' || opts.Quote == '\r' {
+		return opts, errors.New("delimiter and quote can not be line breaks")
+	}
+	return opts, nil
+}
+
+func singleRune(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError || size != len(s) {
+		return 0, errors.New("must be a single character")
+	}
+	return r, nil
+}
+
+// CSVReader reads records from a CSV file. Fields may be quoted with the
+// configured quote character, which is escaped inside a quoted field by
+// doubling it; quoted fields may span lines.
+type CSVReader struct {
+	r    *bufio.Reader
+	opts CSVOptions
+	line int
+}
+
+func NewCSVReader(r io.Reader, opts CSVOptions) *CSVReader {
+	return &CSVReader{r: bufio.NewReader(r), opts: opts, line: 1}
+}
+
+// CSVParseError reports a record that is not valid CSV. The reader skips
+// it, so the next Read returns the record after it.
+type CSVParseError struct {
+	Line int
+	// Raw is the text of the record without the line break ending it.
+	Raw string
+	Err error
+}
+
+func (e *CSVParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *CSVParseError) Unwrap() error {
+	return e.Err
+}
+
+// Line returns the line the next record starts on.
+func (cr *CSVReader) Line() int {
+	return cr.line
+}
+
+// Read returns the fields of the next record, or io.EOF after the last one.
+// A record that is not valid CSV is reported with a *CSVParseError; any
+// other error comes from the underlying reader.
+func (cr *CSVReader) Read() ([]string, error) {
+	var fields []string
+	var field, raw strings.Builder
+	quoted, inQuotes, started := false, false, false
+	start := cr.line
+
+	for {
+		r, _, err := cr.r.ReadRune()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, cr.unterminated(start, raw.String())
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			return append(fields, field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		started = true
+		raw.WriteRune(r)
+
+		if inQuotes {
+			if r == '\n' {
+				cr.line++
+			}
+			if r != cr.opts.Quote {
+				field.WriteRune(r)
+				continue
+			}
+			next, _, err := cr.r.ReadRune()
+			if err == nil && next == cr.opts.Quote {
+				field.WriteRune(r)
+				raw.WriteRune(next)
+				continue
+			}
+			if err == nil {
+				cr.r.UnreadRune()
+			}
+			inQuotes = false
+			continue
+		}
+
+		switch {
+		case r == cr.opts.Quote && field.Len() == 0 && !quoted:
+			quoted, inQuotes = true, true
+		case r == cr.opts.Delimiter:
+			fields = append(fields, field.String())
+			field.Reset()
+			quoted = false
+		case r == '\r':
+			// dropped; a following \n ends the record
+		case r == '\n':
+			cr.line++
+			return append(fields, field.String()), nil
+		case quoted:
+			return nil, cr.skipLine(start, &raw, fmt.Errorf("unexpected %q after quoted field", r))
+		default:
+			field.WriteRune(r)
+		}
+	}
+}
+
+// skipLine discards the rest of the current line, the end of the record
+// that failed with err.
+func (cr *CSVReader) skipLine(start int, raw *strings.Builder, err error) error {
+	for {
+		r, _, readErr := cr.r.ReadRune()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+		if r == '\n' {
+			cr.line++
+			break
+		}
+		raw.WriteRune(r)
+	}
+	return &CSVParseError{Line: start, Raw: strings.TrimSuffix(raw.String(), "\r"), Err: err}
+}
+
+// unterminated reports a quoted field left open at the end of the file.
+// Only the first line of the record is taken as broken: the lines after it
+// are read again as records of their own.
+func (cr *CSVReader) unterminated(start int, raw string) error {
+	first, rest, found := strings.Cut(raw, "\n")
+	if found {
+		cr.r = bufio.NewReader(io.MultiReader(strings.NewReader(rest), cr.r))
+		cr.line = start + 1
+	}
+	return &CSVParseError{Line: start, Raw: strings.TrimSuffix(first, "\r"), Err: errors.New("unterminated quoted field")}
+}
+
+// CSVWriter writes records to a CSV file, quoting fields only when needed.
+type CSVWriter struct {
+	w    *bufio.Writer
+	opts CSVOptions
+}
+
+func NewCSVWriter(w io.Writer, opts CSVOptions) *CSVWriter {
+	return &CSVWriter{w: bufio.NewWriter(w), opts: opts}
+}
+
+func (cw *CSVWriter) Write(fields []string) error {
+	for i, field := range fields {
+		if i > 0 {
+			cw.w.WriteRune(cw.opts.Delimiter)
+		}
+		if !cw.needsQuotes(field) {
+			cw.w.WriteString(field)
+			continue
+		}
+
+		quote := string(cw.opts.Quote)
+		cw.w.WriteString(quote)
+		cw.w.WriteString(strings.ReplaceAll(field, quote, quote+quote))
+		cw.w.WriteString(quote)
+	}
+	_, err := cw.w.WriteString("\n")
+	return err
+}
+
+func (cw *CSVWriter) Flush() error {
+	return cw.w.Flush()
+}
+
+func (cw *CSVWriter) needsQuotes(field string) bool {
+	return strings.ContainsRune(field, cw.opts.Delimiter) ||
+		strings.ContainsRune(field, cw.opts.Quote) ||
+		strings.ContainsAny(field, "\r\n")
+}
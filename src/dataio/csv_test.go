@@ -0,0 +1,83 @@
+package dataio
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"rdbms/src/storage"
+)
+
+// TestImportCSVMalformed checks records that are not valid CSV are rejected
+// with their raw text and the import goes on with the next record.
+func TestImportCSVMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		ids     []any
+		rejects []string
+	}{
+		{
+			name:    "text after closing quote",
+			input:   "id,name\n1,\"a\"x,y\n2,b\n",
+			ids:     []any{int64(2)},
+			rejects: []string{"1,\"a\"x,y", "line 2: unexpected 'x' after quoted field"},
+		},
+		{
+			name:    "text after closing quote on last line",
+			input:   "id,name\n1,a\r\n2,\"b\" \r\n",
+			ids:     []any{int64(1)},
+			rejects: []string{"2,\"b\" ", "line 3: unexpected ' ' after quoted field"},
+		},
+		{
+			name:    "unterminated quote",
+			input:   "id,name\n1,\"a\n2,b\n3,c\n",
+			ids:     []any{int64(2), int64(3)},
+			rejects: []string{"1,\"a", "line 2: unterminated quoted field"},
+		},
+		{
+			name:    "unterminated quote on last line",
+			input:   "id,name\n1,a\n2,\"b",
+			ids:     []any{int64(1)},
+			rejects: []string{"2,\"b", "line 3: unterminated quoted field"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTableManager(t)
+			schema := storage.Schema{Columns: []storage.Column{
+				{Name: "id", Type: storage.TypeInt},
+				{Name: "name", Type: storage.TypeVarchar, Length: 8},
+			}}
+			if err := tm.CreateTable("t", &schema); err != nil {
+				t.Fatal(err)
+			}
+
+			var rejects bytes.Buffer
+			result, err := ImportCSV(tm, "t", strings.NewReader(tt.input), DefaultCSVOptions(), &rejects)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Inserted != len(tt.ids) || result.Rejected != 1 {
+				t.Errorf("import: got %+v, want %d inserted and 1 rejected", result, len(tt.ids))
+			}
+			if got := readInts(t, tm, "t"); !reflect.DeepEqual(got, tt.ids) {
+				t.Errorf("got ids %v, want %v", got, tt.ids)
+			}
+
+			reader := NewCSVReader(&rejects, DefaultCSVOptions())
+			if _, err := reader.Read(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.rejects) {
+				t.Errorf("got reject %q, want %q", got, tt.rejects)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package dataio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"rdbms/src/storage"
+)
+
+// ExportCSV writes rows as returned by TableI.GetAllData to w, with a
+// header row and the fields in the order of columns. NULLs and missing
+// values become empty fields and json values are written as JSON text, so
+// the file can be imported again with ImportCSV.
+func ExportCSV(w io.Writer, columns []storage.Column, rows []map[string]any, opts CSVOptions) error {
+	writer := NewCSVWriter(w, opts)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	fields := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			field, err := csvField(column, row[column.Name])
+			if err != nil {
+				return fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			fields[i] = field
+		}
+		if err := writer.Write(fields); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+func csvField(column storage.Column, value any) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		if column.Type == storage.TypeJSON {
+			break
+		}
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ExportColumns returns the columns of schema named in selected, in that
+// order, or every column when selected is empty.
+func ExportColumns(schema storage.Schema, selected []string) ([]storage.Column, error) {
+	if len(selected) == 0 {
+		return schema.Columns, nil
+	}
+
+	columns := make([]storage.Column, 0, len(selected))
+	for _, name := range selected {
+		found := false
+		for _, column := range schema.Columns {
+			if column.Name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("column %s does not exist", name)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,159 @@
+package dataio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+// ImportBatchSize is the number of rows handed to InsertBatch at once.
+const ImportBatchSize = 10000
+
+// ImportResult summarizes a CSV import.
+type ImportResult struct {
+	Inserted int `json:"inserted"`
+	Rejected int `json:"rejected"`
+	// Errors describes the first rejected rows of imports that have no
+	// reject file.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportCSV inserts the rows of a CSV file into a table. The header row
+// names the columns of the file and is matched to the table columns by
+// name; columns of the file the table does not have are an error, and
+// table columns missing from the file, or empty in a row, take their
+// default. Rows that can not be coerced or stored are written to rejects,
+// when it is not nil, as the original fields followed by the reason; rows
+// that are not valid CSV are written as their raw text followed by the
+// reason. Only errors reading r or writing rejects abort the import.
+func ImportCSV(table storage.TableI, tableName string, r io.Reader, opts CSVOptions, rejects io.Writer) (ImportResult, error) {
+	var result ImportResult
+
+	schema, err := table.GetTableSchema(tableName + ".schema")
+	if err != nil {
+		return result, err
+	}
+
+	reader := NewCSVReader(r, opts)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return result, errors.New("csv file is empty")
+	}
+	if err != nil {
+		return result, err
+	}
+
+	columns := make([]*storage.Column, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		for j := range schema.Columns {
+			if schema.Columns[j].Name == name {
+				columns[i] = &schema.Columns[j]
+			}
+		}
+		if columns[i] == nil {
+			return result, fmt.Errorf("csv column %q does not exist in table %s", name, tableName)
+		}
+	}
+
+	var rejectWriter *CSVWriter
+	if rejects != nil {
+		rejectWriter = NewCSVWriter(rejects, opts)
+		if err := rejectWriter.Write(append(append([]string(nil), header...), "error")); err != nil {
+			return result, err
+		}
+		defer rejectWriter.Flush()
+	}
+	reject := func(fields []string, reason string) error {
+		result.Rejected++
+		if rejectWriter == nil {
+			return nil
+		}
+		return rejectWriter.Write(append(append([]string(nil), fields...), reason))
+	}
+
+	records := make([]storage.Record, 0, ImportBatchSize)
+	batchFields := make([][]string, 0, ImportBatchSize)
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		rejected, err := table.InsertBatch(tableName, records)
+		if err != nil {
+			return err
+		}
+		for i, fields := range batchFields {
+			if err, ok := rejected[i]; ok {
+				if err := reject(fields, err.Error()); err != nil {
+					return err
+				}
+			}
+		}
+		result.Inserted += len(records) - len(rejected)
+		records, batchFields = records[:0], batchFields[:0]
+		return nil
+	}
+
+	for {
+		line := reader.Line()
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *CSVParseError
+		if errors.As(err, &parseErr) {
+			if err := reject([]string{parseErr.Raw}, parseErr.Error()); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if len(fields) == 1 && fields[0] == "" {
+			continue
+		}
+
+		record, err := csvRecord(schema, columns, fields)
+		if err != nil {
+			if err := reject(fields, fmt.Sprintf("line %d: %v", line, err)); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		records = append(records, record)
+		batchFields = append(batchFields, fields)
+		if len(records) == ImportBatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, flush()
+}
+
+func csvRecord(schema storage.Schema, columns []*storage.Column, fields []string) (storage.Record, error) {
+	if len(fields) != len(columns) {
+		return storage.Record{}, fmt.Errorf("row has %d fields, header has %d", len(fields), len(columns))
+	}
+
+	values := make(map[string]any, len(fields))
+	for i, field := range fields {
+		if field == "" {
+			continue
+		}
+		value, err := utils.ParseTextValue(*columns[i], field)
+		if err != nil {
+			return storage.Record{}, err
+		}
+		values[columns[i].Name] = value
+	}
+
+	return utils.ToStorageRecord(schema, values)
+}
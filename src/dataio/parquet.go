@@ -0,0 +1,271 @@
+package dataio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/extensions"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/apache/arrow-go/v18/parquet/schema"
+
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+// DefaultRowGroupSize is the number of rows per Parquet row group when the
+// caller does not choose one.
+const DefaultRowGroupSize = 64 * 1024
+
+// maxReportedErrors caps ImportResult.Errors so a file full of bad rows
+// does not produce a huge report.
+const maxReportedErrors = 100
+
+// ExportParquet writes rows as returned by TableI.GetAllData to w as a
+// Parquet file with one row group per rowGroupSize rows.
+func ExportParquet(w io.Writer, columns []storage.Column, rows []map[string]any, rowGroupSize int) error {
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+
+	mem := memory.DefaultAllocator
+	arrowSchema := ArrowSchema(columns)
+	props := parquet.NewWriterProperties(
+		parquet.WithMaxRowGroupLength(int64(rowGroupSize)),
+		parquet.WithCompression(compress.Codecs.Snappy),
+		parquet.WithAllocator(mem),
+	)
+	// the Parquet writer closes sinks that are io.Closers; w stays owned by
+	// the caller
+	sink := struct{ io.Writer }{w}
+	writer, err := pqarrow.NewFileWriter(arrowSchema, sink, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(rows); start += rowGroupSize {
+		record, err := ArrowRecord(mem, arrowSchema, columns, rows[start:min(start+rowGroupSize, len(rows))])
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		err = writer.Write(record)
+		record.Release()
+		if err != nil {
+			writer.Close()
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// ImportParquet inserts the rows of a Parquet file into a table. File
+// columns are matched to table columns by name. When the table does not
+// exist and create is set, it is created with a schema inferred from the
+// file; varchar lengths are the longest value of the column.
+func ImportParquet(table storage.TableI, tableName string, r parquet.ReaderAtSeeker, create bool) (ImportResult, error) {
+	var result ImportResult
+
+	pf, err := file.NewParquetReader(r)
+	if err != nil {
+		return result, err
+	}
+	defer pf.Close()
+
+	mem := memory.DefaultAllocator
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: ImportBatchSize}, mem)
+	if err != nil {
+		return result, err
+	}
+	fileSchema, err := fr.Schema()
+	if err != nil {
+		return result, err
+	}
+
+	if _, err := table.DescribeTable(tableName); err != nil {
+		if !create {
+			return result, err
+		}
+		inferred, err := inferSchema(fr, pf.MetaData().Schema, fileSchema)
+		if err != nil {
+			return result, err
+		}
+		if err := table.CreateTable(tableName, &inferred); err != nil {
+			return result, err
+		}
+	}
+
+	tableSchema, err := table.GetTableSchema(tableName + ".schema")
+	if err != nil {
+		return result, err
+	}
+	columns := make([]storage.Column, fileSchema.NumFields())
+	for i, field := range fileSchema.Fields() {
+		index := -1
+		for j, column := range tableSchema.Columns {
+			if column.Name == field.Name {
+				index = j
+			}
+		}
+		if index < 0 {
+			return result, fmt.Errorf("parquet column %q does not exist in table %s", field.Name, tableName)
+		}
+		columns[i] = tableSchema.Columns[index]
+	}
+
+	reader, err := fr.GetRecordReader(context.Background(), nil, nil)
+	if err != nil {
+		return result, err
+	}
+	defer reader.Release()
+
+	reject := func(row int, err error) {
+		result.Rejected++
+		if len(result.Errors) < maxReportedErrors {
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", row, err))
+		}
+	}
+
+	row := 0
+	for reader.Next() {
+		batch := reader.Record()
+		records := make([]storage.Record, 0, batch.NumRows())
+		rows := make([]int, 0, batch.NumRows())
+
+		for i := 0; i < int(batch.NumRows()); i++ {
+			row++
+			record, err := parquetRecord(tableSchema, columns, batch, i)
+			if err != nil {
+				reject(row, err)
+				continue
+			}
+			records = append(records, record)
+			rows = append(rows, row)
+		}
+
+		rejected, err := table.InsertBatch(tableName, records)
+		if err != nil {
+			return result, err
+		}
+		for i, row := range rows {
+			if err, ok := rejected[i]; ok {
+				reject(row, err)
+			}
+		}
+		result.Inserted += len(records) - len(rejected)
+	}
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func parquetRecord(schema storage.Schema, columns []storage.Column, batch arrow.Record, i int) (storage.Record, error) {
+	values := make(map[string]any, len(columns))
+	for c, column := range columns {
+		value, err := arrowValue(batch.Column(c), i)
+		if err != nil {
+			return storage.Record{}, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		if value == nil {
+			continue
+		}
+
+		// integer columns of the file may fill float columns of the table
+		if n, ok := value.(int64); ok && column.Type == storage.TypeFloat {
+			value = float64(n)
+		}
+
+		// JSON text read without the arrow.json type, e.g. from a file
+		// written by another tool, is parsed so it is not stored as a
+		// JSON string
+		if s, ok := value.(string); ok && column.Type == storage.TypeJSON {
+			if _, isJSON := batch.Column(c).(*extensions.JSONArray); !isJSON {
+				if err := json.Unmarshal([]byte(s), &value); err != nil {
+					return storage.Record{}, fmt.Errorf("column %s: invalid json: %v", column.Name, err)
+				}
+			}
+		}
+		values[column.Name] = value
+	}
+
+	return utils.ToStorageRecord(schema, values)
+}
+
+// inferSchema derives a table schema from the columns of a Parquet file.
+func inferSchema(fr *pqarrow.FileReader, parquetSchema *schema.Schema, fileSchema *arrow.Schema) (storage.Schema, error) {
+	if parquetSchema.NumColumns() != fileSchema.NumFields() {
+		return storage.Schema{}, errors.New("nested parquet columns are not supported")
+	}
+
+	var inferred storage.Schema
+	var varchars []int
+	for i, field := range fileSchema.Fields() {
+		column := storage.Column{Name: field.Name}
+		switch field.Type.ID() {
+		case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64:
+			column.Type = storage.TypeInt
+		case arrow.FLOAT32, arrow.FLOAT64:
+			column.Type = storage.TypeFloat
+		case arrow.DATE32, arrow.DATE64:
+			column.Type = storage.TypeDate
+		case arrow.TIMESTAMP:
+			column.Type = storage.TypeTimestamp
+		case arrow.EXTENSION:
+			if _, ok := field.Type.(*extensions.JSONType); !ok {
+				return storage.Schema{}, fmt.Errorf("column %s has unsupported type %s", field.Name, field.Type)
+			}
+			column.Type = storage.TypeJSON
+		case arrow.STRING, arrow.LARGE_STRING, arrow.BINARY:
+			if _, ok := parquetSchema.Column(i).LogicalType().(schema.JSONLogicalType); ok {
+				column.Type = storage.TypeJSON
+				break
+			}
+			column.Type = storage.TypeVarchar
+			varchars = append(varchars, i)
+		default:
+			return storage.Schema{}, fmt.Errorf("column %s has unsupported type %s", field.Name, field.Type)
+		}
+		inferred.Columns = append(inferred.Columns, column)
+	}
+
+	if len(varchars) == 0 {
+		return inferred, nil
+	}
+
+	reader, err := fr.GetRecordReader(context.Background(), varchars, nil)
+	if err != nil {
+		return storage.Schema{}, err
+	}
+	defer reader.Release()
+
+	for reader.Next() {
+		batch := reader.Record()
+		for c, i := range varchars {
+			arr := batch.Column(c)
+			for row := 0; row < arr.Len(); row++ {
+				value, err := arrowValue(arr, row)
+				if err != nil {
+					return storage.Schema{}, err
+				}
+				if s, ok := value.(string); ok {
+					inferred.Columns[i].Length = max(inferred.Columns[i].Length, len(s))
+				}
+			}
+		}
+	}
+	for _, i := range varchars {
+		inferred.Columns[i].Length = max(inferred.Columns[i].Length, 1)
+	}
+
+	return inferred, nil
+}
@@ -0,0 +1,123 @@
+package dataio
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+
+	"rdbms/src/storage"
+)
+
+func newTableManager(t *testing.T) *storage.TableManager {
+	t.Helper()
+
+	tm, err := storage.NewTableManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tm.Close() })
+	return tm
+}
+
+// readInts returns the id column of a table in insertion order.
+func readInts(t *testing.T, tm *storage.TableManager, table string) []any {
+	t.Helper()
+
+	rows, err := tm.GetAllData(table, nil, storage.SelectedColumns{Columns: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]any, len(rows))
+	for i, row := range rows {
+		ids[i] = row["id"]
+	}
+	return ids
+}
+
+// TestParquetIntegers checks integers beyond 2^53, which a float64 can not
+// tell apart, survive an export and import exactly.
+func TestParquetIntegers(t *testing.T) {
+	tests := []struct {
+		name  string
+		value int64
+	}{
+		{"2^53+1", 1<<53 + 1},
+		{"-(2^53+1)", -(1<<53 + 1)},
+		{"max int64", math.MaxInt64},
+		{"min int64", math.MinInt64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTableManager(t)
+			schema := storage.Schema{Columns: []storage.Column{{Name: "id", Type: storage.TypeInt}}}
+			if err := tm.CreateTable("src", &schema); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.Insert("src", storage.Record{Items: []storage.Item{{Literal: int(tt.value)}}}); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := tm.GetAllData("src", nil, storage.SelectedColumns{Columns: []string{"id"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := ExportParquet(&buf, schema.Columns, rows, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := ImportParquet(tm, "dst", bytes.NewReader(buf.Bytes()), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Inserted != 1 || result.Rejected != 0 {
+				t.Fatalf("import: got %+v", result)
+			}
+			if got := readInts(t, tm, "dst"); len(got) != 1 || got[0] != tt.value {
+				t.Errorf("got %v, want [%d]", got, tt.value)
+			}
+		})
+	}
+}
+
+// TestParquetUint64 checks unsigned values that fit an int64 are imported
+// exactly and the ones that do not are rejected.
+func TestParquetUint64(t *testing.T) {
+	mem := memory.DefaultAllocator
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Uint64}}, nil)
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.Uint64Builder).AppendValues([]uint64{1<<53 + 1, math.MaxInt64 + 1, math.MaxUint64}, nil)
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var buf bytes.Buffer
+	writer, err := pqarrow.NewFileWriter(schema, &buf, nil, pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := newTableManager(t)
+	result, err := ImportParquet(tm, "t", bytes.NewReader(buf.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 1 || result.Rejected != 2 {
+		t.Fatalf("import: got %+v, want 1 inserted and 2 rejected", result)
+	}
+	if got := readInts(t, tm, "t"); len(got) != 1 || got[0] != int64(1<<53+1) {
+		t.Errorf("got %v, want [%d]", got, int64(1<<53+1))
+	}
+}
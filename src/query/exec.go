@@ -0,0 +1,285 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"rdbms/api/auth"
+	"rdbms/api/models"
+	"rdbms/src/storage"
+	"rdbms/utils"
+)
+
+// Result is the outcome of a statement. Rows hold values as returned by
+// TableI.GetAllData, in the order of Columns. Tag is the PostgreSQL
+// command tag, e.g. "SELECT 3".
+type Result struct {
+	Columns []storage.Column
+	Rows    [][]any
+	Tag     string
+}
+
+// Describe returns the columns the parameters of a statement are bound to,
+// indexed by parameter number minus one, and the columns of its result.
+// Parameters that are not bound to a column are described as varchar.
+func Describe(table storage.TableI, stmt Statement) (params []storage.Column, columns []storage.Column, err error) {
+	params = make([]storage.Column, Params(stmt))
+	for i := range params {
+		params[i] = storage.Column{Name: fmt.Sprintf("$%d", i+1), Type: storage.TypeVarchar}
+	}
+
+	switch s := stmt.(type) {
+	case *Select:
+		schema, err := tableSchema(table, s.Table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range s.Where {
+			column, err := schemaColumn(schema, c.Column)
+			if err != nil {
+				return nil, nil, err
+			}
+			if c.Value.Kind == ValueParam {
+				params[c.Value.Param-1] = column
+			}
+		}
+		columns, err = selectColumns(schema, s.Columns)
+		if err != nil {
+			return nil, nil, err
+		}
+	case *Insert:
+		schema, err := tableSchema(table, s.Table)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets, err := selectColumns(schema, s.Columns)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range s.Rows {
+			for i, v := range row {
+				if v.Kind == ValueParam && i < len(targets) {
+					params[v.Param-1] = targets[i]
+				}
+			}
+		}
+	}
+
+	return params, columns, nil
+}
+
+// Execute runs a statement. params holds the text form of the $n
+// parameters, nil for NULL.
+func Execute(table storage.TableI, stmt Statement, params []*string) (*Result, error) {
+	if n := Params(stmt); len(params) < n {
+		return nil, &Error{Code: CodeProtocolViolation, Message: fmt.Sprintf("statement has %d parameters, %d given", n, len(params))}
+	}
+
+	switch s := stmt.(type) {
+	case *Select:
+		return executeSelect(table, s, params)
+	case *Insert:
+		return executeInsert(table, s, params)
+	case *CreateTable:
+		return executeCreateTable(table, s)
+	case *Set:
+		return &Result{Tag: "SET"}, nil
+	}
+	return nil, &Error{Code: CodeFeatureNotSupported, Message: "unsupported statement"}
+}
+
+func executeSelect(table storage.TableI, s *Select, params []*string) (*Result, error) {
+	schema, err := tableSchema(table, s.Table)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := selectColumns(schema, s.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]storage.Filter, 0, len(s.Where))
+	for _, c := range s.Where {
+		column, err := schemaColumn(schema, c.Column)
+		if err != nil {
+			return nil, err
+		}
+		text, ok := valueText(c.Value, params)
+		if !ok {
+			return nil, &Error{Code: CodeFeatureNotSupported, Message: "comparison with NULL is not supported"}
+		}
+		value, err := utils.ParseTextValue(column, text)
+		if err != nil {
+			return nil, &Error{Code: CodeInvalidTextRepr, Message: err.Error()}
+		}
+		filters = append(filters, storage.Filter{Column: column.Name, Operator: c.Operator, Value: value})
+	}
+	filters, err = utils.SetFilterColumnIndexes(schema, filters)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidTextRepr, Message: err.Error()}
+	}
+
+	selected := storage.SelectedColumns{}
+	for _, column := range columns {
+		selected.Columns = append(selected.Columns, column.Name)
+	}
+	data, err := table.GetAllData(tableName(s.Table), filters, selected)
+	if err != nil {
+		return nil, err
+	}
+	if s.Limit >= 0 && len(data) > s.Limit {
+		data = data[:s.Limit]
+	}
+
+	result := &Result{Columns: columns, Rows: make([][]any, len(data))}
+	for i, row := range data {
+		values := make([]any, len(columns))
+		for j, column := range columns {
+			values[j] = row[column.Name]
+		}
+		result.Rows[i] = values
+	}
+	result.Tag = fmt.Sprintf("SELECT %d", len(result.Rows))
+	return result, nil
+}
+
+func executeInsert(table storage.TableI, s *Insert, params []*string) (*Result, error) {
+	schema, err := tableSchema(table, s.Table)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := selectColumns(schema, s.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]storage.Record, 0, len(s.Rows))
+	for r, row := range s.Rows {
+		if len(row) != len(targets) {
+			return nil, syntaxError("row %d has %d values for %d columns", r+1, len(row), len(targets))
+		}
+
+		values := make(map[string]any, len(row))
+		for i, v := range row {
+			text, ok := valueText(v, params)
+			if !ok {
+				continue
+			}
+			value, err := utils.ParseTextValue(targets[i], text)
+			if err != nil {
+				return nil, &Error{Code: CodeInvalidTextRepr, Message: err.Error()}
+			}
+			values[targets[i].Name] = value
+		}
+
+		record, err := utils.ToStorageRecord(schema, values)
+		if err != nil {
+			code := CodeInvalidTextRepr
+			if strings.HasPrefix(err.Error(), "missing column") {
+				code = CodeNotNullViolation
+			}
+			return nil, &Error{Code: code, Message: err.Error()}
+		}
+		records = append(records, record)
+	}
+
+	name := tableName(s.Table)
+	if len(records) == 1 {
+		if err := table.Insert(name, records[0]); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "INSERT 0 1"}, nil
+	}
+
+	rejected, err := table.InsertBatch(name, records)
+	if err != nil {
+		return nil, err
+	}
+	if len(rejected) > 0 {
+		for i := range records {
+			if err, ok := rejected[i]; ok {
+				return nil, fmt.Errorf("row %d: %w (%d of %d rows inserted)", i+1, err, len(records)-len(rejected), len(records))
+			}
+		}
+	}
+	return &Result{Tag: fmt.Sprintf("INSERT 0 %d", len(records))}, nil
+}
+
+func executeCreateTable(table storage.TableI, s *CreateTable) (*Result, error) {
+	req := models.CreateTableRequest{Name: tableName(s.Table)}
+	for _, c := range s.Columns {
+		column := models.CreateColumn{Name: c.Name, Type: int(c.Type)}
+		if c.Length > 0 {
+			length := c.Length
+			column.Length = &length
+		}
+		req.Columns = append(req.Columns, column)
+	}
+
+	schema, err := utils.ToStorageSchema(req)
+	if err != nil {
+		return nil, &Error{Code: CodeSyntaxError, Message: err.Error()}
+	}
+	if _, err := table.GetTableSchema(req.Name + ".schema"); err == nil {
+		return nil, &Error{Code: CodeDuplicateTable, Message: fmt.Sprintf("relation %q already exists", s.Table)}
+	}
+	if err := table.CreateTable(req.Name, &schema); err != nil {
+		return nil, err
+	}
+	return &Result{Tag: "CREATE TABLE"}, nil
+}
+
+// tableName drops the public schema qualifier clients like to add.
+func tableName(name string) string {
+	return strings.TrimPrefix(name, "public.")
+}
+
+func tableSchema(table storage.TableI, name string) (storage.Schema, error) {
+	schema, err := table.GetTableSchema(tableName(name) + ".schema")
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		return storage.Schema{}, err
+	}
+	if err != nil {
+		return storage.Schema{}, &Error{Code: CodeUndefinedTable, Message: fmt.Sprintf("relation %q does not exist", name)}
+	}
+	return schema, nil
+}
+
+func schemaColumn(schema storage.Schema, name string) (storage.Column, error) {
+	for _, column := range schema.Columns {
+		if column.Name == name {
+			return column, nil
+		}
+	}
+	return storage.Column{}, &Error{Code: CodeUndefinedColumn, Message: fmt.Sprintf("column %q does not exist", name)}
+}
+
+// selectColumns resolves the columns a statement names, or every column
+// when it names none.
+func selectColumns(schema storage.Schema, names []string) ([]storage.Column, error) {
+	if names == nil {
+		return schema.Columns, nil
+	}
+	columns := make([]storage.Column, len(names))
+	for i, name := range names {
+		column, err := schemaColumn(schema, name)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+func valueText(v Value, params []*string) (string, bool) {
+	switch v.Kind {
+	case ValueLiteral:
+		return v.Text, true
+	case ValueParam:
+		if p := params[v.Param-1]; p != nil {
+			return *p, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,589 @@
+// Package query implements the small SQL dialect shared by the PostgreSQL
+// wire protocol server and the database/sql driver:
+//
+//	SELECT * | col [, col ...] FROM table [WHERE col op value [AND ...]] [LIMIT n]
+//	INSERT INTO table [(col [, col ...])] VALUES (value [, value ...]) [, (...)]
+//	CREATE TABLE table (col type [, col type ...])
+//	SET name {= | TO} value
+//
+// where op is = or != (<> is accepted as well), a type is int, float,
+// varchar[(n)], date, timestamp or json, or one of their PostgreSQL aliases, and a value is a string
+// literal, a number, NULL or a parameter. Parameters are written $n, or ?
+// which takes the number after the previous ? of the statement. Unquoted identifiers are folded
+// to lower case like PostgreSQL does.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"rdbms/src/storage"
+)
+
+// Statement is a parsed SQL statement: *Select, *Insert, *CreateTable or
+// *Set.
+type Statement interface {
+	statement()
+}
+
+type Select struct {
+	Table string
+	// Columns is nil for SELECT *.
+	Columns []string
+	Where   []Condition
+	// Limit is -1 without a LIMIT clause.
+	Limit int
+}
+
+type Insert struct {
+	Table string
+	// Columns is nil when the statement does not name them, in which case
+	// values are given for every column in table order.
+	Columns []string
+	Rows    [][]Value
+}
+
+type CreateTable struct {
+	Table   string
+	Columns []ColumnDef
+}
+
+type ColumnDef struct {
+	Name string
+	Type storage.ColumnType
+	// Length is the declared varchar length, 0 if none was given.
+	Length int
+}
+
+// Set is accepted and ignored, since clients send SET statements for
+// session settings the engine does not have.
+type Set struct {
+	Name string
+}
+
+func (*Select) statement()      {}
+func (*Insert) statement()      {}
+func (*CreateTable) statement() {}
+func (*Set) statement()         {}
+
+type Condition struct {
+	Column   string
+	Operator string
+	Value    Value
+}
+
+type ValueKind int
+
+const (
+	ValueLiteral ValueKind = iota
+	ValueNull
+	ValueParam
+)
+
+// Value is a literal, NULL or a parameter. Literals keep their text form
+// and are converted once the column they are compared to or stored in is
+// known.
+type Value struct {
+	Kind  ValueKind
+	Text  string
+	Param int
+}
+
+// SQLSTATE codes of the errors Parse and Execute return.
+const (
+	CodeSyntaxError         = "42601"
+	CodeUndefinedTable      = "42P01"
+	CodeUndefinedColumn     = "42703"
+	CodeDuplicateTable      = "42P07"
+	CodeInvalidTextRepr     = "22P02"
+	CodeNotNullViolation    = "23502"
+	CodeFeatureNotSupported = "0A000"
+	CodeProtocolViolation   = "08P01"
+)
+
+// Error is a statement error with the SQLSTATE code a PostgreSQL client
+// expects for it.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func syntaxError(format string, args ...any) *Error {
+	return &Error{Code: CodeSyntaxError, Message: "syntax error: " + fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenParam
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// Split splits a query string into statements at semicolons outside of
+// quotes, dropping empty ones.
+func Split(sql string) []string {
+	var statements []string
+	start := 0
+	var quote rune
+	for i, r := range sql {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';':
+			statements = append(statements, sql[start:i])
+			start = i + 1
+		}
+	}
+	statements = append(statements, sql[start:])
+
+	nonEmpty := statements[:0]
+	for _, s := range statements {
+		if strings.TrimSpace(s) != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return nonEmpty
+}
+
+// Parse parses a single statement.
+func Parse(sql string) (Statement, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	var stmt Statement
+	switch {
+	case p.keyword("select"):
+		stmt, err = p.parseSelect()
+	case p.keyword("insert"):
+		stmt, err = p.parseInsert()
+	case p.keyword("create"):
+		stmt, err = p.parseCreateTable()
+	case p.keyword("set"):
+		stmt, err = p.parseSet()
+	default:
+		return nil, syntaxError("unsupported statement starting at %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.symbol(";")
+	if p.peek().kind != tokenEOF {
+		return nil, syntaxError("unexpected %q", p.peek().text)
+	}
+	return stmt, nil
+}
+
+// Params returns the highest $n parameter a statement uses.
+func Params(stmt Statement) int {
+	highest := 0
+	visit := func(v Value) {
+		if v.Kind == ValueParam && v.Param > highest {
+			highest = v.Param
+		}
+	}
+	switch s := stmt.(type) {
+	case *Select:
+		for _, c := range s.Where {
+			visit(c.Value)
+		}
+	case *Insert:
+		for _, row := range s.Rows {
+			for _, v := range row {
+				visit(v)
+			}
+		}
+	}
+	return highest
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenIdent && t.text == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) symbol(s string) bool {
+	if t := p.peek(); t.kind == tokenSymbol && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.keyword(word) {
+		return syntaxError("expected %s, got %q", strings.ToUpper(word), p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) expectSymbol(s string) error {
+	if !p.symbol(s) {
+		return syntaxError("expected %q, got %q", s, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	t := p.next()
+	if t.kind != tokenIdent && t.kind != tokenQuotedIdent {
+		return "", syntaxError("expected identifier, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *parser) identifierList() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.symbol(",") {
+			return names, nil
+		}
+	}
+}
+
+func (p *parser) value() (Value, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return Value{Kind: ValueLiteral, Text: t.text}, nil
+	case tokenParam:
+		n, err := strconv.Atoi(t.text)
+		if err != nil || n < 1 {
+			return Value{}, syntaxError("invalid parameter $%s", t.text)
+		}
+		return Value{Kind: ValueParam, Param: n}, nil
+	case tokenIdent:
+		switch t.text {
+		case "null":
+			return Value{Kind: ValueNull}, nil
+		case "true", "false":
+			return Value{Kind: ValueLiteral, Text: t.text}, nil
+		}
+	}
+	return Value{}, syntaxError("expected value, got %q", t.text)
+}
+
+func (p *parser) parseSelect() (*Select, error) {
+	stmt := &Select{Limit: -1}
+	if !p.symbol("*") {
+		columns, err := p.identifierList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = columns
+	}
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+
+	if p.keyword("where") {
+		for {
+			column, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			operator := p.next()
+			if operator.kind != tokenSymbol || (operator.text != "=" && operator.text != "!=" && operator.text != "<>") {
+				return nil, syntaxError("unsupported operator %q", operator.text)
+			}
+			if operator.text == "<>" {
+				operator.text = "!="
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Where = append(stmt.Where, Condition{Column: column, Operator: operator.text, Value: value})
+
+			if !p.keyword("and") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("limit") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || n < 0 {
+			return nil, syntaxError("invalid LIMIT %q", t.text)
+		}
+		stmt.Limit = n
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseInsert() (*Insert, error) {
+	if err := p.expectKeyword("into"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &Insert{Table: table}
+
+	if p.symbol("(") {
+		columns, err := p.identifierList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		stmt.Columns = columns
+	}
+
+	if err := p.expectKeyword("values"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var row []Value
+		for {
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		stmt.Rows = append(stmt.Rows, row)
+
+		if !p.symbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// typeNames maps the type names CREATE TABLE accepts to column types.
+var typeNames = map[string]storage.ColumnType{
+	"int":       storage.TypeInt,
+	"integer":   storage.TypeInt,
+	"bigint":    storage.TypeInt,
+	"int8":      storage.TypeInt,
+	"float":     storage.TypeFloat,
+	"float8":    storage.TypeFloat,
+	"double":    storage.TypeFloat,
+	"real":      storage.TypeFloat,
+	"varchar":   storage.TypeVarchar,
+	"text":      storage.TypeVarchar,
+	"date":      storage.TypeDate,
+	"timestamp": storage.TypeTimestamp,
+	"json":      storage.TypeJSON,
+	"jsonb":     storage.TypeJSON,
+}
+
+func (p *parser) parseCreateTable() (*CreateTable, error) {
+	if err := p.expectKeyword("table"); err != nil {
+		return nil, err
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &CreateTable{Table: table}
+
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		t := p.next()
+		typ, ok := typeNames[t.text]
+		if t.kind != tokenIdent || !ok {
+			return nil, syntaxError("unknown type %q for column %s", t.text, name)
+		}
+		if t.text == "double" {
+			p.keyword("precision")
+		}
+
+		column := ColumnDef{Name: name, Type: typ}
+		if typ == storage.TypeVarchar && p.symbol("(") {
+			n := p.next()
+			length, err := strconv.Atoi(n.text)
+			if n.kind != tokenNumber || err != nil || length < 0 {
+				return nil, syntaxError("invalid length %q for column %s", n.text, name)
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			column.Length = length
+		}
+		stmt.Columns = append(stmt.Columns, column)
+
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseSet() (*Set, error) {
+	p.keyword("session")
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	if !p.symbol("=") && !p.keyword("to") {
+		return nil, syntaxError("expected = or TO")
+	}
+	for p.peek().kind != tokenEOF && !(p.peek().kind == tokenSymbol && p.peek().text == ";") {
+		p.next()
+	}
+	return &Set{Name: name}, nil
+}
+
+func tokenize(sql string) ([]token, error) {
+	var tokens []token
+	positional := 0
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '\'' || r == '"':
+			text, next, err := quoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			kind := tokenString
+			if r == '"' {
+				kind = tokenQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: text})
+			i = next
+		case r == '$':
+			j := i + 1
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenParam, text: string(runes[i+1 : j])})
+			i = j
+		case r == '?':
+			positional++
+			tokens = append(tokens, token{kind: tokenParam, text: strconv.Itoa(positional)})
+			i++
+		case unicode.IsDigit(r) || ((r == '-' || r == '.') && i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.')):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'e' || runes[j] == 'E' ||
+				((runes[j] == '-' || runes[j] == '+') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: strings.ToLower(string(runes[i:j]))})
+			i = j
+		case r == '!' || r == '<':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, token{kind: tokenSymbol, text: string(runes[i : i+2])})
+				i += 2
+				continue
+			}
+			return nil, syntaxError("unexpected %q", r)
+		case strings.ContainsRune("(),*=;", r):
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(r)})
+			i++
+		default:
+			return nil, syntaxError("unexpected %q", r)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// quoted reads a quoted string or identifier starting at runes[start],
+// where a doubled quote stands for the quote itself.
+func quoted(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	var b strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] != quote {
+			b.WriteRune(runes[i])
+			continue
+		}
+		if i+1 < len(runes) && runes[i+1] == quote {
+			b.WriteRune(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, syntaxError("unterminated quoted string")
+}
@@ -4,6 +4,9 @@ import "rdbms/src/storage"
 
 type StorageI interface {
 	Table() storage.TableI
+	// Close flushes and closes the data files. The storage must not be
+	// used afterwards.
+	Close() error
 }
 
 type Storage struct {
@@ -21,3 +24,7 @@ func NewStorage(dataDir string) (StorageI, error) {
 func (s *Storage) Table() storage.TableI {
 	return s.table
 }
+
+func (s *Storage) Close() error {
+	return s.table.Close()
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+)
+
+// AddColumn appends a column to the table schema. Existing rows are not
+// rewritten: they keep their old schema version and get the column default
+// when they are read.
+func (tm *TableManager) AddColumn(tableName string, column Column) error {
+	if err := ValidateColumn(column); err != nil {
+		return err
+	}
+	return tm.alterSchema(tableName, func(schema *Schema, nextID uint16) error {
+		for _, c := range schema.Columns {
+			if c.Name == column.Name {
+				return errors.New("column already exists")
+			}
+		}
+
+		column.ID = nextID
+		schema.Columns = append(schema.Columns, column)
+		return nil
+	})
+}
+
+func (tm *TableManager) DropColumn(tableName string, columnName string) error {
+	return tm.alterSchema(tableName, func(schema *Schema, nextID uint16) error {
+		index := columnIndex(*schema, columnName)
+		if index < 0 {
+			return errors.New("column does not exist")
+		}
+		if len(schema.Columns) == 1 {
+			return errors.New("cannot drop the only column of a table")
+		}
+
+		schema.Columns = append(schema.Columns[:index], schema.Columns[index+1:]...)
+		return nil
+	})
+}
+
+func (tm *TableManager) RenameColumn(tableName string, columnName string, newName string) error {
+	return tm.alterSchema(tableName, func(schema *Schema, nextID uint16) error {
+		index := columnIndex(*schema, columnName)
+		if index < 0 {
+			return errors.New("column does not exist")
+		}
+		if columnIndex(*schema, newName) >= 0 {
+			return errors.New("column already exists")
+		}
+
+		renamed := schema.Columns[index]
+		renamed.Name = newName
+		if err := ValidateColumn(renamed); err != nil {
+			return err
+		}
+		schema.Columns[index] = renamed
+		return nil
+	})
+}
+
+// alterSchema appends a new schema version produced by change to the
+// table's schema history. nextID is a column ID that was never used by
+// any earlier version, so values of dropped columns are never picked up
+// by columns added later.
+func (tm *TableManager) alterSchema(tableName string, change func(schema *Schema, nextID uint16) error) error {
+	lock := tm.tableLock(tableName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := tm.checkNotRewriting(tableName); err != nil {
+		return err
+	}
+
+	history, err := tm.GetSchemaHistory(tableName + ".schema")
+	if err != nil {
+		return err
+	}
+
+	var nextID uint16
+	for _, version := range history {
+		for _, column := range version.Columns {
+			if column.ID > nextID {
+				nextID = column.ID
+			}
+		}
+	}
+	nextID++
+
+	current := history[len(history)-1]
+	schema := Schema{
+		Version: current.Version + 1,
+		Columns: append([]Column(nil), current.Columns...),
+	}
+	if err := change(&schema, nextID); err != nil {
+		return err
+	}
+
+	history = append(history, schema)
+	if err := tm.FileManager.WriteAll(tableName+".schema", SerializeSchemaHistory(history)); err != nil {
+		return err
+	}
+
+	return tm.Catalog.UpdateColumns(tableName, schema.Columns)
+}
+
+func columnIndex(schema Schema, name string) int {
+	for i, column := range schema.Columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRenameColumn checks names the schema file can not record are refused
+// before the schema changes.
+func TestRenameColumn(t *testing.T) {
+	tests := []struct {
+		name    string
+		newName string
+		wantErr string
+	}{
+		{"valid", "renamed", ""},
+		{"empty", "", "column name is required"},
+		{"too long", strings.Repeat("x", MaxVarcharLength+1), "too long"},
+		{"taken", "b", "column already exists"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTestTableManager(t)
+			schema := Schema{Columns: []Column{{Name: "a", Type: TypeInt}, {Name: "b", Type: TypeInt}}}
+			if err := tm.CreateTable("t", &schema); err != nil {
+				t.Fatal(err)
+			}
+
+			err := tm.RenameColumn("t", "a", tt.newName)
+			want := tt.newName
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				want = "a"
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			history, err := tm.GetSchemaHistory("t.schema")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := history[len(history)-1].Columns[0].Name; got != want {
+				t.Errorf("got column %.20q, want %.20q", got, want)
+			}
+		})
+	}
+}
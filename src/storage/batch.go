@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// InsertBatch inserts many records under one table lock. Records are packed
+// into pages in memory; every touched page is then written once, followed
+// by a single write of the FSM entries of those pages. Records that can not
+// be stored are skipped and reported by their index in records.
+func (tm *TableManager) InsertBatch(tableName string, records []Record) (map[int]error, error) {
+	lock := tm.tableLock(tableName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	schema, err := tm.GetTableSchema(tableName + ".schema")
+	if err != nil {
+		return nil, err
+	}
+
+	fsm, err := tm.loadFSM(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	rejected := make(map[int]error)
+	dirty := make(map[int][]byte)
+	inserted := 0
+
+	for i := range records {
+		if err := tm.toastRecord(tableName, schema, &records[i]); err != nil {
+			rejected[i] = err
+			continue
+		}
+		serialized := SerializeRecord(schema, records[i])
+		if len(serialized) > MaxRecordSize {
+			rejected[i] = errors.New("record is too large")
+			continue
+		}
+
+		pageIndex := fsm.find(len(serialized) + 4)
+		var page []byte
+		switch {
+		case pageIndex < 0:
+			pageIndex = fsm.pages()
+			page = make([]byte, PageSize)
+			binary.LittleEndian.PutUint16(page[2:4], uint16(PageHeaderSize))
+		case dirty[pageIndex] != nil:
+			page = dirty[pageIndex]
+		default:
+			page, err = tm.readPage(tableName, pageIndex+1)
+			if err != nil {
+				tm.forgetFSM(tableName)
+				return nil, err
+			}
+			if PageFreeSpace(page) != int(fsm.levels[0][pageIndex]) {
+				tm.forgetFSM(tableName)
+				return nil, errors.New("fsm and page free space mismatch")
+			}
+		}
+
+		fsm.set(pageIndex, uint16(addToPage(page, serialized)))
+		fsm.hint = pageIndex
+		dirty[pageIndex] = page
+		inserted++
+	}
+
+	if len(dirty) == 0 {
+		return rejected, nil
+	}
+
+	pages := make([]int, 0, len(dirty))
+	for pageIndex := range dirty {
+		pages = append(pages, pageIndex)
+	}
+	sort.Ints(pages)
+
+	for _, pageIndex := range pages {
+		if err := tm.writePage(tableName, pageIndex+1, dirty[pageIndex]); err != nil {
+			tm.forgetFSM(tableName)
+			return nil, err
+		}
+	}
+
+	first, last := pages[0], pages[len(pages)-1]
+	leaves := make([]byte, 0, (last-first+1)*2)
+	for _, free := range fsm.levels[0][first : last+1] {
+		leaves = binary.LittleEndian.AppendUint16(leaves, free)
+	}
+	if err := tm.FileManager.Write(tableName+".fsm", int64(first*2), leaves); err != nil {
+		tm.forgetFSM(tableName)
+		return nil, err
+	}
+
+	if err := tm.Catalog.RecordInsert(tableName, int64(inserted), int64(fsm.pages())); err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const CatalogFileName = "catalog"
+
+type IndexInfo struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+}
+
+type ConstraintInfo struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Columns []string `json:"columns"`
+}
+
+type TableInfo struct {
+	ID          uint32           `json:"id"`
+	Name        string           `json:"name"`
+	Columns     []Column         `json:"columns"`
+	Indexes     []IndexInfo      `json:"indexes"`
+	Constraints []ConstraintInfo `json:"constraints"`
+	CreatedAt   time.Time        `json:"created_at"`
+	RowCount    int64            `json:"row_count"`
+	PageCount   int64            `json:"page_count"`
+}
+
+// Catalog is the persistent list of tables known to the storage engine.
+// It is stored as JSON in the CatalogFileName file of the data directory.
+type Catalog struct {
+	mu          sync.RWMutex
+	fm          *FileManager
+	Format      int                   `json:"format"`
+	NextTableID uint32                `json:"next_table_id"`
+	LSN         uint64                `json:"lsn"`
+	Tables      map[string]*TableInfo `json:"tables"`
+}
+
+// LoadCatalog reads the catalog file, or builds a fresh one from the
+// `.schema` files already present in the data directory, migrating their
+// heaps from the baseline format first. A catalog of another DataFormat is
+// refused.
+func LoadCatalog(fm *FileManager) (*Catalog, error) {
+	catalog := &Catalog{fm: fm, NextTableID: 1, Tables: make(map[string]*TableInfo)}
+
+	if fm.FileExists(CatalogFileName) {
+		data, err := fm.ReadAll(CatalogFileName)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, catalog); err != nil {
+				return nil, err
+			}
+			if catalog.Tables == nil {
+				catalog.Tables = make(map[string]*TableInfo)
+			}
+			return catalog, catalog.checkDataFormat()
+		}
+	} else if _, err := fm.CreateFile(CatalogFileName); err != nil {
+		return nil, err
+	}
+
+	// the catalog is only saved once every heap is migrated, so an
+	// interrupted migration is picked up again on the next start
+	if err := migrateBaselineHeaps(fm); err != nil {
+		return nil, err
+	}
+	catalog.Format = DataFormat
+	if err := catalog.bootstrap(); err != nil {
+		return nil, err
+	}
+
+	return catalog, catalog.save()
+}
+
+func (c *Catalog) bootstrap() error {
+	for _, fileName := range c.fm.Files() {
+		if !strings.HasSuffix(fileName, ".schema") {
+			continue
+		}
+		name := strings.TrimSuffix(fileName, ".schema")
+
+		data, err := c.fm.ReadAll(fileName)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		history, err := DeserializeSchemaHistory(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		if len(history) == 0 {
+			continue
+		}
+		schema := history[len(history)-1]
+
+		info := &TableInfo{
+			ID:          c.NextTableID,
+			Name:        name,
+			Columns:     schema.Columns,
+			Indexes:     []IndexInfo{},
+			Constraints: []ConstraintInfo{},
+			CreatedAt:   time.Now().UTC(),
+		}
+		c.NextTableID++
+
+		if c.fm.FileExists(name + ".table") {
+			rows, pages, lsn, err := c.countRows(name + ".table")
+			if err != nil {
+				return err
+			}
+			info.RowCount = rows
+			info.PageCount = pages
+			c.LSN = max(c.LSN, lsn)
+		}
+
+		c.Tables[name] = info
+	}
+
+	return nil
+}
+
+// countRows sums the record counts of the pages of a heap, and finds the
+// highest LSN stamped on them.
+func (c *Catalog) countRows(tableFile string) (rows int64, pages int64, lsn uint64, err error) {
+	size, err := c.fm.GetFileSize(tableFile)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	pages = size / PageSize
+
+	for i := int64(0); i < pages; i++ {
+		header, err := c.fm.Read(tableFile, i*PageSize, PageHeaderSize)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		rows += int64(binary.LittleEndian.Uint16(header))
+		lsn = max(lsn, PageLSN(header))
+	}
+
+	return rows, pages, lsn, nil
+}
+
+// Recount rebuilds the row and page counts of every table, and the LSN,
+// from the heaps. Counts are only saved with other catalog changes and on
+// Close, so this is what brings them back after an unclean shutdown.
+func (c *Catalog) Recount() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, info := range c.Tables {
+		info.RowCount, info.PageCount = 0, 0
+		if !c.fm.FileExists(name + ".table") {
+			continue
+		}
+		rows, pages, lsn, err := c.countRows(name + ".table")
+		if err != nil {
+			return err
+		}
+		info.RowCount = rows
+		info.PageCount = pages
+		c.LSN = max(c.LSN, lsn)
+	}
+
+	return c.save()
+}
+
+// Save writes the catalog, including the row counts and LSN kept in
+// memory since the last change.
+func (c *Catalog) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.save()
+}
+
+func (c *Catalog) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.fm.WriteAll(CatalogFileName, data)
+}
+
+// NextLSN hands out the log sequence number stamped on the next page
+// write. It is persisted with the next catalog update, or found again by
+// Recount.
+func (c *Catalog) NextLSN() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.LSN++
+	return c.LSN
+}
+
+func (c *Catalog) Exists(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.Tables[name]
+	return ok
+}
+
+func (c *Catalog) AddTable(name string, schema *Schema) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Tables[name]; ok {
+		return errors.New("table already exists")
+	}
+
+	c.Tables[name] = &TableInfo{
+		ID:          c.NextTableID,
+		Name:        name,
+		Columns:     append([]Column(nil), schema.Columns...),
+		Indexes:     []IndexInfo{},
+		Constraints: []ConstraintInfo{},
+		CreatedAt:   time.Now().UTC(),
+	}
+	c.NextTableID++
+
+	return c.save()
+}
+
+// RecordInsert bumps the row count of a table after a successful insert
+// and keeps its page count in sync with the heap file. The counts are kept
+// in memory only, see Recount.
+func (c *Catalog) RecordInsert(name string, rows int64, pageCount int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, ok := c.Tables[name]
+	if !ok {
+		return errors.New("table does not exist")
+	}
+	info.RowCount += rows
+	if pageCount > info.PageCount {
+		info.PageCount = pageCount
+	}
+	return nil
+}
+
+func (c *Catalog) Get(name string) (TableInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	info, ok := c.Tables[name]
+	if !ok {
+		return TableInfo{}, errors.New("table does not exist")
+	}
+	return *info, nil
+}
+
+func (c *Catalog) List() []TableInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tables := make([]TableInfo, 0, len(c.Tables))
+	for _, info := range c.Tables {
+		tables = append(tables, *info)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].ID < tables[j].ID })
+
+	return tables
+}
+
+func (c *Catalog) RemoveTable(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Tables[name]; !ok {
+		return errors.New("table does not exist")
+	}
+	delete(c.Tables, name)
+
+	return c.save()
+}
+
+// RenameTable moves a table entry to a new name. Instead of saving, the new
+// catalog content is handed to commit so the caller can publish it in the
+// same step as the table files; the entry is restored if commit fails,
+// unless the error wraps ErrRenamePending: then the files may be renamed
+// already and the next start completes the rename, so the entry keeps its
+// new name.
+func (c *Catalog) RenameTable(oldName string, newName string, commit func(data []byte) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, ok := c.Tables[oldName]
+	if !ok {
+		return errors.New("table does not exist")
+	}
+	if _, ok := c.Tables[newName]; ok {
+		return errors.New("table already exists")
+	}
+
+	delete(c.Tables, oldName)
+	info.Name = newName
+	c.Tables[newName] = info
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err == nil {
+		err = commit(data)
+	}
+	if err != nil && !errors.Is(err, ErrRenamePending) {
+		delete(c.Tables, newName)
+		info.Name = oldName
+		c.Tables[oldName] = info
+	}
+
+	return err
+}
+
+func (c *Catalog) ResetCounts(name string) error {
+	return c.update(name, func(info *TableInfo) {
+		info.RowCount = 0
+		info.PageCount = 0
+	})
+}
+
+func (c *Catalog) UpdateColumns(name string, columns []Column) error {
+	return c.update(name, func(info *TableInfo) {
+		info.Columns = append([]Column(nil), columns...)
+	})
+}
+
+func (c *Catalog) SetPageCount(name string, pageCount int64) error {
+	return c.update(name, func(info *TableInfo) {
+		info.PageCount = pageCount
+	})
+}
+
+func (c *Catalog) update(name string, change func(info *TableInfo)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, ok := c.Tables[name]
+	if !ok {
+		return errors.New("table does not exist")
+	}
+	change(info)
+
+	return c.save()
+}
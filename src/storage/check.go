@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+// TableFiles holds the raw content of the files of one table, as read by an
+// offline tool that does not go through FileManager.
+type TableFiles struct {
+	Name   string
+	Schema []byte
+	Table  []byte
+	FSM    []byte
+	Toast  []byte
+}
+
+// TableCheck is the result of CheckTable.
+type TableCheck struct {
+	Table    string   `json:"table"`
+	Pages    int      `json:"pages"`
+	Records  int      `json:"records"`
+	Problems []string `json:"problems"`
+	// FSM is the free space map rebuilt from the pages. Pages that fail
+	// verification are recorded as full so nothing is inserted into them.
+	FSM []byte `json:"-"`
+	// FSMMismatch is set when the stored free space map differs from FSM.
+	FSMMismatch bool `json:"fsm_mismatch"`
+}
+
+func (c *TableCheck) problem(format string, args ...any) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// PageFreeSpace is the free space of a heap page as the FSM records it: the
+// room left between the records and the slot array, minus one more slot.
+func PageFreeSpace(page []byte) int {
+	count := int(binary.LittleEndian.Uint16(page[0:2]))
+	free_space_pointer := int(binary.LittleEndian.Uint16(page[2:4]))
+	return PageSize - ((count+1)*4 + free_space_pointer)
+}
+
+// CheckTable validates the files of a table: the schema history decodes,
+// the heap is made of whole pages that pass VerifyPage, the FSM has one
+// entry per page matching the free space of the page, and every record
+// decodes under the schema version it was written with.
+func CheckTable(files TableFiles) TableCheck {
+	check := TableCheck{Table: files.Name, Problems: []string{}}
+
+	history, err := checkSchemaHistory(files.Schema)
+	if err != nil {
+		check.problem("schema does not deserialize: %v", err)
+	}
+	versions := make(map[uint16]Schema, len(history))
+	for _, version := range history {
+		versions[version.Version] = version
+	}
+
+	if len(files.Table)%PageSize != 0 {
+		check.problem(".table size %d is not a multiple of the page size %d", len(files.Table), PageSize)
+	}
+	check.Pages = len(files.Table) / PageSize
+
+	if len(files.FSM)%2 != 0 {
+		check.problem(".fsm size %d is not a multiple of 2", len(files.FSM))
+	}
+	fsm := DeserializeFSM(files.FSM)
+	if len(fsm) != check.Pages {
+		check.problem(".fsm has %d entries for %d pages", len(fsm), check.Pages)
+	}
+
+	toast := ToastReaderFromBytes(files.Toast)
+
+	check.FSM = make([]byte, 2*check.Pages)
+	for i := 0; i < check.Pages; i++ {
+		pageNo := i + 1
+		page := files.Table[i*PageSize : (i+1)*PageSize]
+
+		if err := VerifyPage(page); err != nil {
+			check.problem("page %d: %v", pageNo, err)
+			if i < len(fsm) && fsm[i] != 0 {
+				check.FSMMismatch = true
+			}
+			continue
+		}
+
+		free := PageFreeSpace(page)
+		binary.LittleEndian.PutUint16(check.FSM[i*2:], uint16(free))
+		if i < len(fsm) && int(fsm[i]) != free {
+			check.problem("page %d: fsm records %d free bytes, page has %d", pageNo, fsm[i], free)
+			check.FSMMismatch = true
+		}
+
+		if history == nil {
+			continue
+		}
+		for slot, data := range pageRecords(page) {
+			check.Records++
+			if err := checkRecord(versions, data, toast); err != nil {
+				check.problem("page %d slot %d: %v", pageNo, slot, err)
+			}
+		}
+	}
+	if len(fsm) != check.Pages {
+		check.FSMMismatch = true
+	}
+
+	return check
+}
+
+// ToastReaderFromBytes reads toasted values from the whole content of a
+// `.toast` file held in memory.
+func ToastReaderFromBytes(toast []byte) ToastReader {
+	return func(pointer ToastPointer) ([]byte, error) {
+		start := int64(pointer.Offset)
+		end := start + toastStoredSize(pointer)
+		if end > int64(len(toast)) {
+			return nil, fmt.Errorf("toast value at %d runs past the end of the toast file", start)
+		}
+		return decodeToastValue(toast[start:end], pointer)
+	}
+}
+
+func checkRecord(versions map[uint16]Schema, data []byte, toast ToastReader) error {
+	_, err := decodeFullRecord(versions, data, toast)
+	return err
+}
+
+// decodeFullRecord decodes every column of a record under the schema
+// version it was written with. Malformed records produce an error instead
+// of a panic.
+func decodeFullRecord(versions map[uint16]Schema, data []byte, toast ToastReader) (values map[string]any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			values, err = nil, fmt.Errorf("record does not decode: %v", r)
+		}
+	}()
+
+	// cap the slice so reading past the record panics instead of silently
+	// decoding the bytes of the next one
+	data = data[:len(data):len(data)]
+
+	version := RecordSchemaVersion(data)
+	schema, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+
+	projection := make(map[int]ColumnProjection, len(schema.Columns))
+	for i, column := range schema.Columns {
+		projection[i] = ColumnProjection{Name: column.Name, Index: i, IsProjected: true, MustExtract: true}
+	}
+	rec, err := DeserializeRecord(schema, data, projection, toast)
+	if err != nil {
+		return nil, err
+	}
+
+	values = make(map[string]any, len(schema.Columns))
+	if rec != nil {
+		for i, column := range schema.Columns {
+			values[column.Name] = rec.Items[i].Literal
+		}
+	}
+	return values, nil
+}
+
+func checkSchemaHistory(data []byte) ([]Schema, error) {
+	history, err := DeserializeSchemaHistory(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("no schema versions")
+	}
+	return history, nil
+}
+
+// repairFreeSpaceMaps rewrites the `.fsm` files that disagree with their
+// heap, as fsck -repair-fsm does. A page and its free space map entry are
+// written separately, so a crash between the two leaves the map behind.
+func (tm *TableManager) repairFreeSpaceMaps() error {
+	for _, info := range tm.Catalog.List() {
+		files := TableFiles{Name: info.Name}
+		targets := map[string]*[]byte{
+			".schema": &files.Schema,
+			".table":  &files.Table,
+			".fsm":    &files.FSM,
+			".toast":  &files.Toast,
+		}
+		for ext, target := range targets {
+			if !tm.FileManager.FileExists(info.Name + ext) {
+				continue
+			}
+			data, err := tm.FileManager.ReadAll(info.Name + ext)
+			if err != nil {
+				return err
+			}
+			*target = data
+		}
+
+		check := CheckTable(files)
+		if !check.FSMMismatch {
+			continue
+		}
+		log.Printf("storage: rebuilding the free space map of %s after an unclean shutdown", info.Name)
+		if !tm.FileManager.FileExists(info.Name + ".fsm") {
+			if _, err := tm.FileManager.CreateFile(info.Name + ".fsm"); err != nil {
+				return err
+			}
+		}
+		if err := tm.FileManager.WriteAll(info.Name+".fsm", check.FSM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
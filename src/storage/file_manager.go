@@ -1,33 +1,109 @@
 package storage
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// JournalSuffix marks rename journals written by RenameFiles. A journal
+// left in the data directory means a crash interrupted the renames; they
+// are replayed by NewFileManager before any file is opened.
+const JournalSuffix = ".journal"
+
+// CleanShutdownFileName is the marker Close leaves in the data directory
+// once every file is synced and closed. NewFileManager removes it, so a
+// data directory without it was not closed by the last process using it.
+const CleanShutdownFileName = "clean_shutdown"
+
+// tempSuffix marks the file WriteAll writes before renaming it over the
+// target. One left in the data directory is from a write a crash
+// interrupted and is removed by NewFileManager.
+const tempSuffix = ".tmp"
+
+// LockFileName is the file of the data directory NewFileManager locks, so
+// only one process at a time replays journals, removes orphans and writes
+// pages in it.
+const LockFileName = "lock"
+
+// ErrClosed is returned by file operations after Close.
+var ErrClosed = errors.New("storage is closed")
+
+// ErrRenamePending is wrapped by the errors RenameFiles returns once its
+// journal is written: the renames it could not do are left to the next
+// start, and the files they name must be kept for it.
+var ErrRenamePending = errors.New("the renames are completed on the next start")
+
+// ErrLocked is returned by NewFileManager when another process has the
+// data directory open.
+var ErrLocked = errors.New("data directory is in use by another process")
+
 type FileManager struct {
-	root  string
-	files map[string]*os.File
+	root   string
+	mu     sync.RWMutex
+	files  map[string]*os.File
+	closed bool
+	// unclean is set when the data directory had files but no clean
+	// shutdown marker.
+	unclean bool
+	// lock holds the lock on the data directory until Close.
+	lock *os.File
 }
 
-func NewFileManager(root string) (*FileManager, error) {
+// NewFileManager opens every file of a data directory, creating it if
+// needed. It fails with ErrLocked if another process has it open.
+func NewFileManager(root string) (_ *FileManager, err error) {
 	if _, err := os.Stat(root); os.IsNotExist(err) {
 		if mkErr := os.MkdirAll(root, 0o755); mkErr != nil {
 			return nil, mkErr
 		}
 	}
 
-	entries, err := os.ReadDir(root)
+	lock, err := LockDataDir(root)
 	if err != nil {
 		return nil, err
 	}
-
 	files := make(map[string]*os.File)
+	defer func() {
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			lock.Close()
+		}
+	}()
+
+	replayed, err := replayJournals(root)
+	if err != nil {
+		return nil, err
+	}
+
+	marker := filepath.Join(root, CleanShutdownFileName)
+	_, err = os.Stat(marker)
+	clean := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, e := range entries {
-		if !e.IsDir() {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), tempSuffix) {
+			if err := os.Remove(filepath.Join(root, e.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !e.IsDir() && e.Name() != CleanShutdownFileName && e.Name() != LockFileName {
 			fullPath := filepath.Join(root, e.Name())
 			f, err := os.OpenFile(fullPath, os.O_RDWR, 0666)
 			if err != nil {
@@ -40,14 +116,48 @@ func NewFileManager(root string) (*FileManager, error) {
 		}
 	}
 
-	return &FileManager{root: root, files: files}, nil
+	// the marker goes until Close, so a crash from here on leaves none
+	if clean {
+		if err := os.Remove(marker); err != nil {
+			return nil, err
+		}
+		if err := syncDir(root); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileManager{root: root, files: files, unclean: (!clean || replayed) && len(files) > 0, lock: lock}, nil
+}
+
+// UncleanShutdown reports whether the data directory was left by a process
+// that did not Close it, crashed before Close finished, or left renames
+// for replayJournals to complete.
+func (fm *FileManager) UncleanShutdown() bool {
+	return fm.unclean
+}
+
+func (fm *FileManager) file(fileName string) (*os.File, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	if fm.closed {
+		return nil, ErrClosed
+	}
+	file, ok := fm.files[fileName]
+	if !ok {
+		return nil, errors.New("file does not exist")
+	}
+	return file, nil
 }
 
 func (fm *FileManager) Write(fileName string, offset int64, data []byte) error {
 
-	file := fm.files[fileName]
+	file, err := fm.file(fileName)
+	if err != nil {
+		return err
+	}
 
-	_, err := file.WriteAt(data, offset)
+	_, err = file.WriteAt(data, offset)
 
 	if err != nil {
 		return err
@@ -58,7 +168,10 @@ func (fm *FileManager) Write(fileName string, offset int64, data []byte) error {
 
 func (fm *FileManager) Read(fileName string, offset int64, size int64) ([]byte, error) {
 	data := make([]byte, size)
-	file := fm.files[fileName]
+	file, err := fm.file(fileName)
+	if err != nil {
+		return nil, err
+	}
 	n, err := file.ReadAt(data, offset)
 	if err != nil {
 		return nil, err
@@ -68,33 +181,188 @@ func (fm *FileManager) Read(fileName string, offset int64, size int64) ([]byte,
 
 func (fm *FileManager) ReadAll(fileName string) ([]byte, error) {
 
-	file := fm.files[fileName]
+	file, err := fm.file(fileName)
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
+	info, err := file.Stat()
+	if err != nil {
 		return nil, err
 	}
 
-	return io.ReadAll(file)
+	return io.ReadAll(io.NewSectionReader(file, 0, info.Size()))
+}
+
+// WriteAll replaces the content of a file. The data is written and synced
+// to a temporary file that is renamed over the old one, so a crash leaves
+// either the old content or the new, never a truncated file.
+func (fm *FileManager) WriteAll(fileName string, data []byte) error {
+	if _, err := fm.file(fileName); err != nil {
+		return err
+	}
+
+	path := filepath.Join(fm.root, fileName)
+	if err := writeFileSync(path+tempSuffix, data); err != nil {
+		os.Remove(path + tempSuffix)
+		return err
+	}
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.closed {
+		os.Remove(path + tempSuffix)
+		return ErrClosed
+	}
+	if err := os.Rename(path+tempSuffix, path); err != nil {
+		os.Remove(path + tempSuffix)
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	if old, ok := fm.files[fileName]; ok {
+		old.Close()
+	}
+	fm.files[fileName] = file
+
+	return syncDir(fm.root)
+}
+
+// TruncateFile cuts a file down to zero bytes while keeping it open.
+func (fm *FileManager) TruncateFile(fileName string) error {
+	file, err := fm.file(fileName)
+	if err != nil {
+		return err
+	}
+
+	return file.Truncate(0)
+}
+
+func (fm *FileManager) SyncFile(fileName string) error {
+	file, err := fm.file(fileName)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func (fm *FileManager) Files() []string {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	names := make([]string, 0, len(fm.files))
+	for name := range fm.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func (fm *FileManager) FileExists(name string) bool {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
 
 	_, ok := fm.files[name]
 	return ok
 }
 
 func (fm *FileManager) CreateFile(name string) (*os.File, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.closed {
+		return nil, ErrClosed
+	}
+
 	full := filepath.Join(fm.root, name)
 	file, err := os.Create(full)
 	if err != nil {
 		return nil, err
 	}
+
+	if old, ok := fm.files[name]; ok {
+		old.Close()
+	}
 	fm.files[name] = file
 	return file, nil
 }
 
+// DeleteFile closes the open handle of a file, if any, and removes it
+// from disk.
 func (fm *FileManager) DeleteFile(name string) error {
-	return os.Remove(filepath.Join(fm.root, name))
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if file, ok := fm.files[name]; ok {
+		if err := file.Close(); err != nil {
+			return err
+		}
+		delete(fm.files, name)
+	}
+
+	err := os.Remove(filepath.Join(fm.root, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Close syncs and closes every open file, then writes the clean shutdown
+// marker and releases the lock on the data directory. Later file
+// operations fail with ErrClosed.
+func (fm *FileManager) Close() error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.closed {
+		return nil
+	}
+	defer fm.lock.Close()
+
+	if err := fm.closeFiles(); err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(fm.root, CleanShutdownFileName), nil); err != nil {
+		return err
+	}
+	return syncDir(fm.root)
+}
+
+// abandon closes the files and releases the data directory without leaving
+// the clean shutdown marker, for when opening the storage on top of the
+// FileManager failed.
+func (fm *FileManager) abandon() {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.closed {
+		return
+	}
+	fm.closeFiles()
+	fm.lock.Close()
+}
+
+// closeFiles syncs and closes every file and marks the FileManager closed.
+func (fm *FileManager) closeFiles() error {
+	fm.closed = true
+
+	var firstErr error
+	for name, file := range fm.files {
+		if err := file.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(fm.files, name)
+	}
+	return firstErr
 }
 
 func (fm *FileManager) OpenFile(name string) (*os.File, error) {
@@ -102,10 +370,9 @@ func (fm *FileManager) OpenFile(name string) (*os.File, error) {
 }
 
 func (fm *FileManager) GetFileSize(fileName string) (int64, error) {
-	file := fm.files[fileName]
-
-	if !fm.FileExists(fileName) {
-		return 0, errors.New("file does not exist")
+	file, err := fm.file(fileName)
+	if err != nil {
+		return 0, err
 	}
 
 	info, err := file.Stat()
@@ -116,3 +383,134 @@ func (fm *FileManager) GetFileSize(fileName string) (int64, error) {
 
 	return info.Size(), nil
 }
+
+// RenameFiles renames a group of files as one unit. The renames are first
+// written to a journal, so if the process dies half way the remaining ones
+// are completed on the next start instead of leaving a mix of old and new
+// files. Targets that already exist are replaced. Errors after the journal
+// is written wrap ErrRenamePending.
+func (fm *FileManager) RenameFiles(journal string, renames [][2]string) error {
+	data, err := json.Marshal(renames)
+	if err != nil {
+		return err
+	}
+
+	journalPath := filepath.Join(fm.root, journal+JournalSuffix)
+	if err := writeFileSync(journalPath, data); err != nil {
+		os.Remove(journalPath)
+		return err
+	}
+	pending := func(err error) error {
+		return fmt.Errorf("%w; %w", err, ErrRenamePending)
+	}
+	if err := syncDir(fm.root); err != nil {
+		return pending(err)
+	}
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	for _, rename := range renames {
+		from, to := rename[0], rename[1]
+		if file, ok := fm.files[from]; ok {
+			file.Close()
+			delete(fm.files, from)
+		}
+		if file, ok := fm.files[to]; ok {
+			file.Close()
+			delete(fm.files, to)
+		}
+
+		if err := os.Rename(filepath.Join(fm.root, from), filepath.Join(fm.root, to)); err != nil {
+			return pending(err)
+		}
+
+		file, err := os.OpenFile(filepath.Join(fm.root, to), os.O_RDWR, 0666)
+		if err != nil {
+			return pending(err)
+		}
+		fm.files[to] = file
+	}
+
+	if err := syncDir(fm.root); err != nil {
+		return pending(err)
+	}
+
+	if err := os.Remove(journalPath); err != nil {
+		return pending(err)
+	}
+	return nil
+}
+
+// replayJournals completes the renames of the journals RenameFiles left
+// behind, and reports whether there were any.
+func replayJournals(root string) (replayed bool, err error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), JournalSuffix) {
+			continue
+		}
+
+		journalPath := filepath.Join(root, e.Name())
+		data, err := os.ReadFile(journalPath)
+		if err != nil {
+			return replayed, err
+		}
+
+		var renames [][2]string
+		if err := json.Unmarshal(data, &renames); err != nil {
+			// the journal itself was not fully written, so no rename started
+			if err := os.Remove(journalPath); err != nil {
+				return replayed, err
+			}
+			continue
+		}
+
+		replayed = true
+		for _, rename := range renames {
+			from := filepath.Join(root, rename[0])
+			if _, err := os.Stat(from); os.IsNotExist(err) {
+				continue
+			}
+			if err := os.Rename(from, filepath.Join(root, rename[1])); err != nil {
+				return replayed, err
+			}
+		}
+
+		if err := syncDir(root); err != nil {
+			return replayed, err
+		}
+		if err := os.Remove(journalPath); err != nil {
+			return replayed, err
+		}
+	}
+
+	return replayed, nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
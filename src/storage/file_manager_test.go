@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReplayJournal checks a rename journal left by a crash is completed
+// on the next start whichever of its renames were done, and that a journal
+// cut short while being written renames nothing.
+func TestReplayJournal(t *testing.T) {
+	renames := [][2]string{
+		{"t~rewrite.schema", "t.schema"},
+		{"t~rewrite.table", "t.table"},
+		{"t~rewrite.fsm", "t.fsm"},
+		{"t~rewrite.toast", "t.toast"},
+	}
+	complete, err := json.Marshal(renames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		journal []byte
+		done    int
+		clean   bool
+		// renamed tells whether the targets end up with the new content
+		renamed bool
+		unclean bool
+	}{
+		{"crash before the first rename", complete, 0, false, true, true},
+		{"crash between renames", complete, 2, false, true, true},
+		{"crash before the journal is removed", complete, len(renames), false, true, true},
+		{"journal after a clean shutdown", complete, 1, true, true, true},
+		{"torn journal", complete[:len(complete)/2], 0, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			write := func(name, content string) {
+				t.Helper()
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, rename := range renames {
+				if i < tt.done {
+					write(rename[1], "new "+rename[1])
+					continue
+				}
+				write(rename[0], "new "+rename[1])
+				write(rename[1], "old "+rename[1])
+			}
+			write("t~rewrite"+JournalSuffix, string(tt.journal))
+			if tt.clean {
+				write(CleanShutdownFileName, "")
+			}
+
+			fm, err := NewFileManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fm.Close()
+
+			if fm.UncleanShutdown() != tt.unclean {
+				t.Errorf("UncleanShutdown: got %v, want %v", fm.UncleanShutdown(), tt.unclean)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "t~rewrite"+JournalSuffix)); !os.IsNotExist(err) {
+				t.Errorf("journal is left behind: %v", err)
+			}
+			for _, rename := range renames {
+				want := "new " + rename[1]
+				if !tt.renamed {
+					want = "old " + rename[1]
+				}
+				data, err := fm.ReadAll(rename[1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s: got %q, want %q", rename[1], data, want)
+				}
+				if tt.renamed && fm.FileExists(rename[0]) {
+					t.Errorf("%s is left behind", rename[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// DataFormat is the on-disk layout of the heap pages and records this build
+// reads and writes, stamped in the catalog. Format 1 is the original
+// layout: a 4-byte page header without checksum or LSN, records without a
+// schema version prefix and no catalog file. Format 2 added the 16-byte
+// page header and the record header.
+const DataFormat = 2
+
+// migrateSuffix marks the files a heap migration writes before they are
+// swapped in.
+const migrateSuffix = "~migrate"
+
+// migrateSchemaFiles rewrites the schema files of a data directory that
+// predate schemaMagic in the current format. Empty files, which a
+// CreateTable interrupted before writing its schema leaves behind, are
+// left to removeOrphanFiles.
+func migrateSchemaFiles(fm *FileManager) error {
+	for _, fileName := range fm.Files() {
+		if !strings.HasSuffix(fileName, ".schema") {
+			continue
+		}
+
+		data, err := fm.ReadAll(fileName)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 || !IsLegacySchema(data) {
+			continue
+		}
+
+		history, err := DeserializeSchemaHistory(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		if err := fm.WriteAll(fileName, SerializeSchemaHistory(history)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBaselineHeaps rewrites the heaps of a data directory in the
+// baseline format into the current one, along with their free space maps.
+// Heaps whose first page already verifies were migrated by an earlier,
+// interrupted run and are left alone, so the migration can simply be run
+// again until the catalog is stamped.
+func migrateBaselineHeaps(fm *FileManager) error {
+	for _, fileName := range fm.Files() {
+		if !strings.HasSuffix(fileName, ".table") || strings.Contains(fileName, "~") {
+			continue
+		}
+		name := strings.TrimSuffix(fileName, ".table")
+
+		data, err := fm.ReadAll(fileName)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 || (len(data) >= PageSize && VerifyPage(data[:PageSize]) == nil) {
+			continue
+		}
+		if len(data)%PageSize != 0 {
+			return fmt.Errorf("table %s: heap of %d bytes is not made of whole pages", name, len(data))
+		}
+
+		if err := migrateBaselineHeap(fm, name, data); err != nil {
+			return fmt.Errorf("table %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// migrateBaselineHeap repacks the records of a baseline heap into pages
+// with the current header, prefixing each with the latest schema version,
+// and swaps the new heap and free space map in through a rename journal.
+func migrateBaselineHeap(fm *FileManager, name string, data []byte) error {
+	schemaData, err := fm.ReadAll(name + ".schema")
+	if err != nil {
+		return err
+	}
+	history, err := DeserializeSchemaHistory(schemaData)
+	if err != nil {
+		return fmt.Errorf("table schema: %w", err)
+	}
+	if len(history) == 0 {
+		return fmt.Errorf("table schema is empty")
+	}
+	version := uint16(history[len(history)-1].Version)
+
+	var pages [][]byte
+	var page []byte
+	for pageNo := 1; pageNo*PageSize <= len(data); pageNo++ {
+		old := data[(pageNo-1)*PageSize : pageNo*PageSize]
+		count := int(binary.LittleEndian.Uint16(old[0:2]))
+		free_space_pointer := int(binary.LittleEndian.Uint16(old[2:4]))
+		if free_space_pointer < 4 || free_space_pointer+count*4 > PageSize {
+			return fmt.Errorf("page %d: invalid baseline header: %d records, free space pointer %d", pageNo, count, free_space_pointer)
+		}
+
+		for slot := 0; slot < count; slot++ {
+			pointer := PageSize - (slot+1)*4
+			length := int(binary.LittleEndian.Uint16(old[pointer : pointer+2]))
+			offset := int(binary.LittleEndian.Uint16(old[pointer+2 : pointer+4]))
+			if offset < 4 || offset+length > free_space_pointer {
+				return fmt.Errorf("page %d: slot %d points outside the page: offset %d, length %d", pageNo, slot, offset, length)
+			}
+
+			record := make([]byte, RecordHeaderSize+length)
+			binary.LittleEndian.PutUint16(record, version)
+			copy(record[RecordHeaderSize:], old[offset:offset+length])
+			if len(record) > MaxRecordSize {
+				return fmt.Errorf("page %d: record %d no longer fits a page with the larger page header", pageNo, slot)
+			}
+
+			if page == nil || PageFreeSpace(page) < len(record)+4 {
+				page = make([]byte, PageSize)
+				binary.LittleEndian.PutUint16(page[2:4], uint16(PageHeaderSize))
+				pages = append(pages, page)
+			}
+			addToPage(page, record)
+		}
+	}
+
+	heap := make([]byte, 0, len(pages)*PageSize)
+	fsm := make([]byte, 0, len(pages)*2)
+	for _, page := range pages {
+		binary.LittleEndian.PutUint32(page[4:8], PageChecksum(page))
+		heap = append(heap, page...)
+		fsm = binary.LittleEndian.AppendUint16(fsm, uint16(PageFreeSpace(page)))
+	}
+
+	tmp := name + migrateSuffix
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{tmp + ".table", heap}, {tmp + ".fsm", fsm}} {
+		if _, err := fm.CreateFile(file.name); err != nil {
+			return err
+		}
+		if err := fm.Write(file.name, 0, file.data); err != nil {
+			return err
+		}
+		if err := fm.SyncFile(file.name); err != nil {
+			return err
+		}
+	}
+
+	return fm.RenameFiles(tmp, [][2]string{
+		{tmp + ".table", name + ".table"},
+		{tmp + ".fsm", name + ".fsm"},
+	})
+}
+
+// checkDataFormat accepts a catalog written before the format was stamped
+// only if every heap page verifies under the current layout, and stamps it.
+func (c *Catalog) checkDataFormat() error {
+	if c.Format == DataFormat {
+		return nil
+	}
+	if c.Format != 0 {
+		return fmt.Errorf("data directory has format %d, this build supports format %d", c.Format, DataFormat)
+	}
+
+	for name := range c.Tables {
+		fileName := name + ".table"
+		if !c.fm.FileExists(fileName) {
+			continue
+		}
+		data, err := c.fm.ReadAll(fileName)
+		if err != nil {
+			return err
+		}
+		for pageNo := 1; pageNo*PageSize <= len(data); pageNo++ {
+			if err := VerifyPage(data[(pageNo-1)*PageSize : pageNo*PageSize]); err != nil {
+				return fmt.Errorf("data directory has no format stamp and table %s page %d does not match format %d: %v", name, pageNo, DataFormat, err)
+			}
+		}
+	}
+
+	c.Format = DataFormat
+	return c.save()
+}
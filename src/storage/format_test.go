@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// baselineSchema encodes a schema in the layout of the first releases: a
+// column count, then the name, type and length of each column.
+func baselineSchema(columns []Column) []byte {
+	data := binary.LittleEndian.AppendUint16(nil, uint16(len(columns)))
+	for _, column := range columns {
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(column.Name)))
+		data = append(data, column.Name...)
+		data = binary.LittleEndian.AppendUint16(data, uint16(column.Type))
+		data = binary.LittleEndian.AppendUint16(data, uint16(column.Length))
+	}
+	return data
+}
+
+// baselineHeap packs records into baseline pages, with a 4-byte header of
+// record count and free space pointer and no record header, and returns
+// the heap and its free space map.
+func baselineHeap(schema Schema, records []Record) (heap []byte, fsm []byte) {
+	var page []byte
+	flush := func() {
+		count := int(binary.LittleEndian.Uint16(page[0:2]))
+		free := int(binary.LittleEndian.Uint16(page[2:4]))
+		heap = append(heap, page...)
+		fsm = binary.LittleEndian.AppendUint16(fsm, uint16(PageSize-free-count*4))
+	}
+
+	for _, record := range records {
+		data := SerializeRecord(schema, record)[RecordHeaderSize:]
+		if page != nil {
+			count := int(binary.LittleEndian.Uint16(page[0:2]))
+			free := int(binary.LittleEndian.Uint16(page[2:4]))
+			if free+len(data)+(count+1)*4 > PageSize {
+				flush()
+				page = nil
+			}
+		}
+		if page == nil {
+			page = make([]byte, PageSize)
+			binary.LittleEndian.PutUint16(page[2:4], 4)
+		}
+		addToPage(page, data)
+	}
+	if page != nil {
+		flush()
+	}
+	return heap, fsm
+}
+
+// TestMigrateBaseline checks a data directory of the first releases, with
+// no catalog, baseline heap pages and either legacy schema layout, opens
+// with every row readable and is left in the current format.
+func TestMigrateBaseline(t *testing.T) {
+	columns := []Column{
+		{ID: 1, Name: "name", Type: TypeVarchar, Length: 40},
+		{ID: 2, Name: "born", Type: TypeDate},
+		{ID: 3, Name: "score", Type: TypeFloat},
+	}
+	schema := Schema{Version: 1, Columns: columns}
+	history := SerializeSchemaHistory([]Schema{schema})
+
+	tests := []struct {
+		name   string
+		schema []byte
+		rows   int
+	}{
+		{"baseline schema, one page", baselineSchema(columns), 10},
+		{"baseline schema, several pages", baselineSchema(columns), 400},
+		{"unversioned history, several pages", history[len(schemaMagic)+1:], 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := make([]Record, tt.rows)
+			for i := range records {
+				records[i] = Record{Items: []Item{
+					{Literal: fmt.Sprintf("person %03d with a longer name", i)},
+					{Literal: fmt.Sprintf("19%02d-01-%02d", i%100, i%28+1)},
+					{Literal: float64(i) / 4},
+				}}
+			}
+			heap, fsm := baselineHeap(schema, records)
+			if tt.rows > 100 && len(heap) < 2*PageSize {
+				t.Fatalf("heap has %d bytes, want several pages", len(heap))
+			}
+
+			dir := t.TempDir()
+			for name, data := range map[string][]byte{"people.schema": tt.schema, "people.table": heap, "people.fsm": fsm} {
+				if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tm, err := NewTableManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPeople(t, tm, records)
+			if err := tm.Insert("people", records[0]); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "people.schema"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if IsLegacySchema(data) {
+				t.Error("schema file is still in a legacy layout")
+			}
+
+			// a second start finds nothing to migrate
+			tm, err = NewTableManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tm.Close()
+			if tm.Catalog.Format != DataFormat {
+				t.Errorf("catalog format %d, want %d", tm.Catalog.Format, DataFormat)
+			}
+			checkPeople(t, tm, append(records, records[0]))
+		})
+	}
+}
+
+// checkPeople compares the rows of the people table, its row count and its
+// pages with records.
+func checkPeople(t *testing.T, tm *TableManager, records []Record) {
+	t.Helper()
+
+	info, err := tm.DescribeTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RowCount != int64(len(records)) {
+		t.Errorf("catalog counts %d rows, want %d", info.RowCount, len(records))
+	}
+
+	size, err := tm.FileManager.GetFileSize("people.table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pageNo := 1; pageNo <= int(size/PageSize); pageNo++ {
+		if _, err := tm.readPage("people", pageNo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := tm.GetAllData("people", nil, SelectedColumns{Columns: []string{"name", "born", "score"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(records) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(records))
+	}
+	for i, row := range rows {
+		items := records[i].Items
+		if row["name"] != items[0].Literal || row["born"] != items[1].Literal || row["score"] != items[2].Literal {
+			t.Errorf("row %d: got %v, want %v", i, row, items)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FSMFanout is the number of children of every inner node of the free
+// space map tree.
+const FSMFanout = 16
+
+// freeSpaceMap is a tree of max-free-space values over the pages of a heap,
+// in the spirit of PostgreSQL's FSM. levels[0] holds one entry per page and
+// is what the `.fsm` file stores; every entry of levels[l+1] is the largest
+// of FSMFanout entries of levels[l], up to a single root. Finding a page
+// with room for a record therefore descends the tree instead of scanning
+// every page. The inner levels are rebuilt from the file when a table is
+// first written to after start and kept in memory from then on.
+type freeSpaceMap struct {
+	levels [][]uint16
+	// hint is the page that took the last insert. Bulk loads keep filling
+	// the same page, so checking it first usually avoids the descent.
+	hint int
+}
+
+func newFreeSpaceMap(leaves []uint16) *freeSpaceMap {
+	fsm := &freeSpaceMap{levels: [][]uint16{leaves}, hint: -1}
+	for level := leaves; len(level) > 1; {
+		parent := make([]uint16, (len(level)+FSMFanout-1)/FSMFanout)
+		for i := range parent {
+			parent[i] = maxFree(level, i)
+		}
+		fsm.levels = append(fsm.levels, parent)
+		level = parent
+	}
+	return fsm
+}
+
+func maxFree(level []uint16, parent int) uint16 {
+	var largest uint16
+	for _, free := range level[parent*FSMFanout : min((parent+1)*FSMFanout, len(level))] {
+		largest = max(largest, free)
+	}
+	return largest
+}
+
+func (f *freeSpaceMap) pages() int {
+	return len(f.levels[0])
+}
+
+// find returns the index of a page with at least need bytes free, or -1.
+func (f *freeSpaceMap) find(need int) int {
+	if f.hint >= 0 && f.hint < f.pages() && int(f.levels[0][f.hint]) >= need {
+		return f.hint
+	}
+
+	top := len(f.levels) - 1
+	if len(f.levels[top]) == 0 || int(f.levels[top][0]) < need {
+		return -1
+	}
+
+	index := 0
+	for level := top; level > 0; level-- {
+		children := f.levels[level-1]
+		for child := index * FSMFanout; child < min((index+1)*FSMFanout, len(children)); child++ {
+			if int(children[child]) >= need {
+				index = child
+				break
+			}
+		}
+	}
+	return index
+}
+
+// set records the free space of a page and updates its ancestors. Setting
+// the page right after the last one appends it to the map.
+func (f *freeSpaceMap) set(page int, free uint16) {
+	if page == f.pages() {
+		f.levels[0] = append(f.levels[0], free)
+	} else {
+		f.levels[0][page] = free
+	}
+
+	index := page
+	for level := 0; len(f.levels[level]) > 1; level++ {
+		parent := index / FSMFanout
+		if level+1 == len(f.levels) {
+			f.levels = append(f.levels, []uint16{})
+		}
+		if parent == len(f.levels[level+1]) {
+			f.levels[level+1] = append(f.levels[level+1], 0)
+		}
+		f.levels[level+1][parent] = maxFree(f.levels[level], parent)
+		index = parent
+	}
+}
+
+// loadFSM returns the free space map tree of a table, building it from the
+// `.fsm` file the first time.
+func (tm *TableManager) loadFSM(tableName string) (*freeSpaceMap, error) {
+	tm.fsmMu.Lock()
+	defer tm.fsmMu.Unlock()
+
+	if fsm, ok := tm.fsms[tableName]; ok {
+		return fsm, nil
+	}
+
+	fsm_size, err := tm.FileManager.GetFileSize(tableName + ".fsm")
+	if err != nil {
+		return nil, err
+	}
+	fsm_binary_data, err := tm.FileManager.Read(tableName+".fsm", 0, fsm_size)
+	if err != nil {
+		return nil, err
+	}
+
+	table_size, err := tm.FileManager.GetFileSize(tableName + ".table")
+	if err != nil {
+		return nil, err
+	}
+	if int(table_size/PageSize) != len(fsm_binary_data)/2 {
+		return nil, errors.New("fsm data is not compatible with table")
+	}
+
+	fsm := newFreeSpaceMap(DeserializeFSM(fsm_binary_data))
+	tm.fsms[tableName] = fsm
+	return fsm, nil
+}
+
+// forgetFSM drops the cached tree of a table whose `.fsm` file was
+// replaced or removed.
+func (tm *TableManager) forgetFSM(tableName string) {
+	tm.fsmMu.Lock()
+	defer tm.fsmMu.Unlock()
+
+	delete(tm.fsms, tableName)
+}
+
+// setPageFree writes the free space of a page to the `.fsm` file and the tree.
+func (tm *TableManager) setPageFree(tableName string, fsm *freeSpaceMap, page int, free int) error {
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, uint16(free))
+	if err := tm.FileManager.Write(tableName+".fsm", int64(page*2), buf); err != nil {
+		return err
+	}
+
+	fsm.set(page, uint16(free))
+	return nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// firstFit is the page find should return: the first one with enough room.
+func firstFit(leaves []uint16, need int) int {
+	for page, free := range leaves {
+		if int(free) >= need {
+			return page
+		}
+	}
+	return -1
+}
+
+// TestFreeSpaceMapFind checks the tree descent finds the same page as a
+// scan of every page, for heaps that fill one node, spill over into a new
+// one and need more than two levels.
+func TestFreeSpaceMapFind(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages int
+	}{
+		{"one page", 1},
+		{"full node", FSMFanout},
+		{"one past a node", FSMFanout + 1},
+		{"full two levels", FSMFanout * FSMFanout},
+		{"three levels", FSMFanout*FSMFanout + 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(int64(tt.pages)))
+			leaves := make([]uint16, tt.pages)
+			for i := range leaves {
+				leaves[i] = uint16(r.Intn(EmptyPageFree))
+			}
+			fsm := newFreeSpaceMap(append([]uint16(nil), leaves...))
+
+			for _, need := range []int{0, 1, EmptyPageFree / 2, EmptyPageFree - 100, EmptyPageFree} {
+				if got, want := fsm.find(need), firstFit(leaves, need); got != want {
+					t.Errorf("find(%d): got page %d, want %d", need, got, want)
+				}
+			}
+
+			// filling every page but the last moves the only fit to the
+			// far end of the tree
+			for page := 0; page < tt.pages-1; page++ {
+				fsm.set(page, 0)
+				leaves[page] = 0
+			}
+			fsm.set(tt.pages-1, EmptyPageFree)
+			leaves[tt.pages-1] = EmptyPageFree
+			if got := fsm.find(1); got != tt.pages-1 {
+				t.Errorf("find(1) after filling: got page %d, want %d", got, tt.pages-1)
+			}
+			if got := fsm.find(EmptyPageFree + 1); got != -1 {
+				t.Errorf("find(%d): got page %d, want none", EmptyPageFree+1, got)
+			}
+		})
+	}
+}
+
+// TestFreeSpaceMapGrow checks pages appended one at a time with set keep
+// the inner levels in step, including when a new level is needed.
+func TestFreeSpaceMapGrow(t *testing.T) {
+	fsm := newFreeSpaceMap([]uint16{})
+	var leaves []uint16
+	for page := 0; page < FSMFanout*FSMFanout+FSMFanout+1; page++ {
+		free := uint16(page % 7)
+		fsm.set(page, free)
+		leaves = append(leaves, free)
+
+		for _, need := range []int{1, 6} {
+			if got, want := fsm.find(need), firstFit(leaves, need); got != want {
+				t.Fatalf("after %d pages, find(%d): got page %d, want %d", page+1, need, got, want)
+			}
+		}
+	}
+
+	rebuilt := newFreeSpaceMap(leaves)
+	if len(fsm.levels) != len(rebuilt.levels) {
+		t.Fatalf("got %d levels, want %d", len(fsm.levels), len(rebuilt.levels))
+	}
+	for level := range rebuilt.levels {
+		for i, free := range rebuilt.levels[level] {
+			if fsm.levels[level][i] != free {
+				t.Errorf("level %d entry %d: got %d, want %d", level, i, fsm.levels[level][i], free)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type SlotInspection struct {
+	Slot          int            `json:"slot"`
+	Offset        int            `json:"offset"`
+	Length        int            `json:"length"`
+	SchemaVersion uint16         `json:"schema_version"`
+	Values        map[string]any `json:"values,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// PageInspection describes the raw content of a heap page. It is filled in
+// as far as possible even for pages that fail verification.
+type PageInspection struct {
+	Table            string           `json:"table"`
+	Page             int              `json:"page"`
+	RecordCount      int              `json:"record_count"`
+	FreeSpacePointer int              `json:"free_space_pointer"`
+	FreeSpace        int              `json:"free_space"`
+	FSMFreeSpace     *int             `json:"fsm_free_space,omitempty"`
+	Checksum         uint32           `json:"checksum"`
+	ComputedChecksum uint32           `json:"computed_checksum"`
+	LSN              uint64           `json:"lsn"`
+	VerifyError      string           `json:"verify_error,omitempty"`
+	Slots            []SlotInspection `json:"slots"`
+	Hex              string           `json:"hex,omitempty"`
+}
+
+// InspectPage decodes the header, slot array and records of a heap page.
+// fsm is the free space the FSM records for the page, if known.
+func InspectPage(tableName string, pageNo int, page []byte, fsm *int, history []Schema, toast ToastReader, withHex bool) PageInspection {
+	inspection := PageInspection{
+		Table:            tableName,
+		Page:             pageNo,
+		RecordCount:      int(binary.LittleEndian.Uint16(page[0:2])),
+		FreeSpacePointer: int(binary.LittleEndian.Uint16(page[2:4])),
+		FreeSpace:        PageFreeSpace(page),
+		FSMFreeSpace:     fsm,
+		Checksum:         binary.LittleEndian.Uint32(page[4:8]),
+		ComputedChecksum: PageChecksum(page),
+		LSN:              PageLSN(page),
+		Slots:            []SlotInspection{},
+	}
+	if err := VerifyPage(page); err != nil {
+		inspection.VerifyError = err.Error()
+	}
+	if withHex {
+		inspection.Hex = hex.Dump(page)
+	}
+
+	versions := make(map[uint16]Schema, len(history))
+	for _, version := range history {
+		versions[version.Version] = version
+	}
+
+	for slot := 0; slot < inspection.RecordCount; slot++ {
+		pointer := PageSize - (slot+1)*4
+		if pointer < PageHeaderSize {
+			break
+		}
+
+		s := SlotInspection{
+			Slot:   slot,
+			Length: int(binary.LittleEndian.Uint16(page[pointer : pointer+2])),
+			Offset: int(binary.LittleEndian.Uint16(page[pointer+2 : pointer+4])),
+		}
+		if s.Offset < PageHeaderSize || s.Offset+s.Length > PageSize || s.Length < RecordHeaderSize {
+			s.Error = "slot points outside the page"
+			inspection.Slots = append(inspection.Slots, s)
+			continue
+		}
+
+		data := page[s.Offset : s.Offset+s.Length]
+		s.SchemaVersion = RecordSchemaVersion(data)
+		values, err := decodeFullRecord(versions, data, toast)
+		if err != nil {
+			s.Error = err.Error()
+		}
+		s.Values = values
+		inspection.Slots = append(inspection.Slots, s)
+	}
+
+	return inspection
+}
+
+// InspectPage reads heap page pageNo (1-based) of a table without verifying
+// it and describes its content.
+func (tm *TableManager) InspectPage(tableName string, pageNo int, withHex bool) (PageInspection, error) {
+	lock := tm.tableLock(tableName)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	history, err := tm.GetSchemaHistory(tableName + ".schema")
+	if err != nil {
+		return PageInspection{}, err
+	}
+
+	if pageNo < 1 {
+		return PageInspection{}, errors.New("page numbers start at 1")
+	}
+	page, err := tm.FileManager.Read(tableName+".table", int64(pageNo-1)*PageSize, PageSize)
+	if errors.Is(err, io.EOF) {
+		return PageInspection{}, fmt.Errorf("table %s has no page %d", tableName, pageNo)
+	}
+	if err != nil {
+		return PageInspection{}, err
+	}
+
+	var fsm *int
+	if entry, err := tm.FileManager.Read(tableName+".fsm", int64(pageNo-1)*2, 2); err == nil {
+		free := int(binary.LittleEndian.Uint16(entry))
+		fsm = &free
+	}
+
+	return InspectPage(tableName, pageNo, page, fsm, history, tm.toastReader(tableName), withHex), nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// LockDataDir creates the LockFileName file of a data directory. Without
+// flock other processes are not kept out; only the check of package db
+// within one process applies.
+func LockDataDir(root string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(root, LockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+}
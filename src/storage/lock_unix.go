@@ -0,0 +1,30 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockDataDir takes an exclusive lock on the LockFileName file of a data
+// directory, failing at once with ErrLocked when another process holds it.
+// Closing the returned file releases the lock.
+func LockDataDir(root string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(root, LockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, root)
+		}
+		return nil, err
+	}
+	return file, nil
+}
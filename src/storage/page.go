@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// PageHeaderSize is the size of the header at the start of every heap page:
+// record count (2), free space pointer (2), CRC32C checksum (4) and the
+// LSN of the last write (8).
+const PageHeaderSize = 16
+
+// EmptyPageFree is the free space the FSM records for a page without records.
+const EmptyPageFree = PageSize - PageHeaderSize - 4
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptPageError is returned when a heap page fails verification, which
+// means its content can not be trusted to locate records.
+type CorruptPageError struct {
+	Table  string `json:"table"`
+	Page   int    `json:"page"`
+	Reason string `json:"reason"`
+}
+
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("table %s page %d is corrupted: %s", e.Table, e.Page, e.Reason)
+}
+
+// PageChecksum computes the CRC32C of a page with its checksum field zeroed.
+func PageChecksum(page []byte) uint32 {
+	crc := crc32.Update(0, crc32c, page[:4])
+	crc = crc32.Update(crc, crc32c, []byte{0, 0, 0, 0})
+	return crc32.Update(crc, crc32c, page[8:])
+}
+
+func PageLSN(page []byte) uint64 {
+	return binary.LittleEndian.Uint64(page[8:16])
+}
+
+// VerifyPage checks the checksum of a page and that its header and slot
+// array only point inside the page, so records can be sliced out safely.
+func VerifyPage(page []byte) error {
+	if len(page) != PageSize {
+		return fmt.Errorf("page has %d bytes instead of %d", len(page), PageSize)
+	}
+
+	stored := binary.LittleEndian.Uint32(page[4:8])
+	if computed := PageChecksum(page); stored != computed {
+		return fmt.Errorf("checksum mismatch: stored %08x, computed %08x", stored, computed)
+	}
+
+	count := int(binary.LittleEndian.Uint16(page[0:2]))
+	free_space_pointer := int(binary.LittleEndian.Uint16(page[2:4]))
+	if free_space_pointer < PageHeaderSize || free_space_pointer+count*4 > PageSize {
+		return fmt.Errorf("invalid header: %d records, free space pointer %d", count, free_space_pointer)
+	}
+
+	for slot := 0; slot < count; slot++ {
+		pointer := PageSize - (slot+1)*4
+		length := int(binary.LittleEndian.Uint16(page[pointer : pointer+2]))
+		offset := int(binary.LittleEndian.Uint16(page[pointer+2 : pointer+4]))
+		if offset < PageHeaderSize || offset+length > free_space_pointer || length < RecordHeaderSize {
+			return fmt.Errorf("slot %d points outside the page: offset %d, length %d", slot, offset, length)
+		}
+	}
+
+	return nil
+}
+
+// readPage reads heap page pageNo (1-based) of a table and verifies it.
+func (tm *TableManager) readPage(tableName string, pageNo int) ([]byte, error) {
+	page, err := tm.FileManager.Read(tableName+".table", int64(pageNo-1)*PageSize, PageSize)
+	if errors.Is(err, io.EOF) {
+		return nil, &CorruptPageError{Table: tableName, Page: pageNo, Reason: "page is truncated"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := VerifyPage(page); err != nil {
+		return nil, &CorruptPageError{Table: tableName, Page: pageNo, Reason: err.Error()}
+	}
+
+	return page, nil
+}
+
+// writePage stamps a heap page with a new LSN and its checksum and writes
+// it as page pageNo (1-based) of a table.
+func (tm *TableManager) writePage(tableName string, pageNo int, page []byte) error {
+	binary.LittleEndian.PutUint64(page[8:16], tm.Catalog.NextLSN())
+	binary.LittleEndian.PutUint32(page[4:8], PageChecksum(page))
+
+	return tm.FileManager.Write(tableName+".table", int64(pageNo-1)*PageSize, page)
+}
+
+// addToPage appends a record to a heap page that has room for it, adding
+// its slot to the slot array, and returns the free space left.
+func addToPage(page []byte, record []byte) int {
+	record_count := int(binary.LittleEndian.Uint16(page[0:2]))
+	free_space_pointer := int(binary.LittleEndian.Uint16(page[2:4]))
+
+	slot_beginning_address := PageSize - (record_count+1)*4
+	copy(page[free_space_pointer:], record)
+	binary.LittleEndian.PutUint16(page[slot_beginning_address:slot_beginning_address+2], uint16(len(record)))
+	binary.LittleEndian.PutUint16(page[slot_beginning_address+2:slot_beginning_address+4], uint16(free_space_pointer))
+
+	binary.LittleEndian.PutUint16(page[0:2], uint16(record_count+1))
+	binary.LittleEndian.PutUint16(page[2:4], uint16(free_space_pointer+len(record)))
+
+	return max(PageFreeSpace(page), 0)
+}
+
+// pageRecords returns the records stored in a heap page in slot order.
+// Slot i lives at PageSize-(i+1)*4 and holds the record length followed by
+// the record offset.
+func pageRecords(page []byte) [][]byte {
+	count := int(binary.LittleEndian.Uint16(page[0:2]))
+	records := make([][]byte, 0, count)
+	for slot := 0; slot < count; slot++ {
+		pointer := PageSize - (slot+1)*4
+		length := binary.LittleEndian.Uint16(page[pointer : pointer+2])
+		offset := binary.LittleEndian.Uint16(page[pointer+2 : pointer+4])
+		records = append(records, page[offset:offset+length])
+	}
+	return records
+}
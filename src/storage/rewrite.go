@@ -0,0 +1,434 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// rewriteSuffix is appended to the table name for the heap a rewrite is
+// building. These files have no catalog entry, so the ones left behind by
+// a crash are removed by removeOrphanFiles on the next start.
+const rewriteSuffix = "~rewrite"
+
+// RewriteJob reports the progress of an ALTER COLUMN TYPE table rewrite.
+type RewriteJob struct {
+	Table      string     `json:"table"`
+	Column     string     `json:"column"`
+	FromType   string     `json:"from_type"`
+	ToType     string     `json:"to_type"`
+	Status     string     `json:"status"`
+	PagesTotal int        `json:"pages_total"`
+	PagesDone  int        `json:"pages_done"`
+	RowsCopied int64      `json:"rows_copied"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type rewrite struct {
+	job         *RewriteJob
+	tempName    string
+	versions    map[uint16]Schema
+	current     Schema
+	target      Schema
+	columnIndex int
+}
+
+// AlterColumnType changes the type of a column. Every record is decoded,
+// converted and written into a new heap file in the background while the
+// table stays readable and writable; the new heap is swapped in at the end.
+// The returned job can be polled with GetRewriteJob.
+func (tm *TableManager) AlterColumnType(tableName string, columnName string, newType ColumnType, length int) (RewriteJob, error) {
+	lock := tm.tableLock(tableName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := tm.checkNotRewriting(tableName); err != nil {
+		return RewriteJob{}, err
+	}
+
+	history, err := tm.GetSchemaHistory(tableName + ".schema")
+	if err != nil {
+		return RewriteJob{}, err
+	}
+	current := history[len(history)-1]
+
+	index := columnIndex(current, columnName)
+	if index < 0 {
+		return RewriteJob{}, errors.New("column does not exist")
+	}
+
+	from := current.Columns[index]
+	to := from
+	to.Type = newType
+	to.Length = length
+	if err := ValidateColumn(to); err != nil {
+		return RewriteJob{}, err
+	}
+	if err := checkConvertible(from, to); err != nil {
+		return RewriteJob{}, err
+	}
+
+	if from.Default != nil {
+		value, err := convertValue(from.DefaultLiteral(), to)
+		if err != nil {
+			return RewriteJob{}, fmt.Errorf("default value: %w", err)
+		}
+		if n, ok := value.(int64); ok {
+			value = float64(n)
+		}
+		to.Default = value
+	}
+
+	target := Schema{Version: current.Version + 1, Columns: append([]Column(nil), current.Columns...)}
+	target.Columns[index] = to
+
+	tableSize, err := tm.FileManager.GetFileSize(tableName + ".table")
+	if err != nil {
+		return RewriteJob{}, err
+	}
+
+	tempName := tableName + rewriteSuffix
+	for _, ext := range []string{".table", ".fsm", ".toast"} {
+		if _, err := tm.FileManager.CreateFile(tempName + ext); err != nil {
+			return RewriteJob{}, err
+		}
+	}
+
+	versions := make(map[uint16]Schema, len(history))
+	for _, version := range history {
+		versions[version.Version] = version
+	}
+
+	job := &RewriteJob{
+		Table:      tableName,
+		Column:     columnName,
+		FromType:   from.Type.String(),
+		ToType:     to.Type.String(),
+		Status:     JobRunning,
+		PagesTotal: int(tableSize / PageSize),
+		StartedAt:  time.Now().UTC(),
+	}
+
+	tm.jobsMu.Lock()
+	tm.jobs[tableName] = job
+	tm.jobsMu.Unlock()
+
+	tm.rewrites.Add(1)
+	go tm.runRewrite(&rewrite{
+		job:         job,
+		tempName:    tempName,
+		versions:    versions,
+		current:     current,
+		target:      target,
+		columnIndex: index,
+	})
+
+	return *job, nil
+}
+
+func (tm *TableManager) GetRewriteJob(tableName string) (RewriteJob, error) {
+	tm.jobsMu.Lock()
+	defer tm.jobsMu.Unlock()
+
+	job, ok := tm.jobs[tableName]
+	if !ok {
+		return RewriteJob{}, errors.New("no rewrite job for table")
+	}
+	return *job, nil
+}
+
+func (tm *TableManager) checkNotRewriting(tableName string) error {
+	tm.jobsMu.Lock()
+	defer tm.jobsMu.Unlock()
+
+	if job, ok := tm.jobs[tableName]; ok && job.Status == JobRunning {
+		return errors.New("table is being rewritten")
+	}
+	return nil
+}
+
+// forgetJob drops the finished rewrite job of a table that was dropped or
+// renamed, so a later table of the same name does not report it.
+func (tm *TableManager) forgetJob(tableName string) {
+	tm.jobsMu.Lock()
+	defer tm.jobsMu.Unlock()
+
+	delete(tm.jobs, tableName)
+}
+
+func (tm *TableManager) runRewrite(rw *rewrite) {
+	defer tm.rewrites.Done()
+	err := tm.rewriteTable(rw)
+
+	tm.jobsMu.Lock()
+	defer tm.jobsMu.Unlock()
+
+	finished := time.Now().UTC()
+	rw.job.FinishedAt = &finished
+	if err != nil {
+		rw.job.Status = JobFailed
+		rw.job.Error = err.Error()
+		// once the swap is journaled the next start finishes it from the
+		// rewritten files, so they stay
+		if !errors.Is(err, ErrRenamePending) {
+			for _, ext := range tableFileExtensions {
+				tm.FileManager.DeleteFile(rw.tempName + ext)
+			}
+		}
+		tm.forgetFSM(rw.tempName)
+		return
+	}
+	rw.job.Status = JobDone
+}
+
+// rewriteTable copies the pages that existed when the job started under a
+// short read lock per page, then takes the table lock once to copy the
+// records inserted meanwhile and swap the files. Records are never removed
+// from a page and new slots are only appended, so remembering how many
+// slots of each page were copied is enough to catch up.
+func (tm *TableManager) rewriteTable(rw *rewrite) error {
+	tableName := rw.job.Table
+	lock := tm.tableLock(tableName)
+
+	copied := make([]int, 0, rw.job.PagesTotal)
+	for i := 0; i < rw.job.PagesTotal; i++ {
+		lock.RLock()
+		page, err := tm.readPage(tableName, i+1)
+		lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		n, err := tm.copyRecords(rw, page, 0)
+		if err != nil {
+			return err
+		}
+		copied = append(copied, n)
+
+		tm.jobsMu.Lock()
+		rw.job.PagesDone++
+		rw.job.RowsCopied += int64(n)
+		tm.jobsMu.Unlock()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	tableSize, err := tm.FileManager.GetFileSize(tableName + ".table")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(tableSize/PageSize); i++ {
+		page, err := tm.readPage(tableName, i+1)
+		if err != nil {
+			return err
+		}
+
+		from := 0
+		if i < len(copied) {
+			from = copied[i]
+		}
+		n, err := tm.copyRecords(rw, page, from)
+		if err != nil {
+			return err
+		}
+
+		tm.jobsMu.Lock()
+		rw.job.RowsCopied += int64(n)
+		tm.jobsMu.Unlock()
+	}
+
+	history, err := tm.GetSchemaHistory(tableName + ".schema")
+	if err != nil {
+		return err
+	}
+	history = append(history, rw.target)
+
+	if _, err := tm.FileManager.CreateFile(rw.tempName + ".schema"); err != nil {
+		return err
+	}
+	if err := tm.FileManager.WriteAll(rw.tempName+".schema", SerializeSchemaHistory(history)); err != nil {
+		return err
+	}
+
+	renames := make([][2]string, 0, len(tableFileExtensions))
+	for _, ext := range tableFileExtensions {
+		if err := tm.FileManager.SyncFile(rw.tempName + ext); err != nil {
+			return err
+		}
+		renames = append(renames, [2]string{rw.tempName + ext, tableName + ext})
+	}
+	if err := tm.FileManager.RenameFiles(rw.tempName, renames); err != nil {
+		return err
+	}
+	tm.forgetFSM(tableName)
+	tm.forgetFSM(rw.tempName)
+
+	newSize, err := tm.FileManager.GetFileSize(tableName + ".table")
+	if err != nil {
+		return err
+	}
+	if err := tm.Catalog.UpdateColumns(tableName, rw.target.Columns); err != nil {
+		return err
+	}
+	return tm.Catalog.SetPageCount(tableName, newSize/PageSize)
+}
+
+// copyRecords converts the records of page starting at slot from and
+// appends them to the rewrite heap. It returns how many were copied.
+func (tm *TableManager) copyRecords(rw *rewrite, page []byte, from int) (int, error) {
+	records := pageRecords(page)
+	for _, data := range records[from:] {
+		version := RecordSchemaVersion(data)
+		old, ok := rw.versions[version]
+		if !ok {
+			return 0, fmt.Errorf("unknown schema version %d in table %s", version, rw.job.Table)
+		}
+
+		values, err := storedValues(old, data, tm.toastReader(rw.job.Table))
+		if err != nil {
+			return 0, err
+		}
+
+		// only the altered column is converted; the stored text of json
+		// values is copied as is instead of being decoded and re-encoded
+		record := Record{Items: make([]Item, len(rw.target.Columns))}
+		for i, column := range rw.target.Columns {
+			value, stored := values[column.ID]
+			if !stored {
+				value = rw.current.Columns[i].DefaultLiteral()
+			}
+			if i == rw.columnIndex {
+				converted, err := convertValue(value, column)
+				if err != nil {
+					return 0, err
+				}
+				value = converted
+			} else if stored && column.Type == TypeJSON {
+				record.Items[i] = Item{Literal: value}
+				continue
+			}
+
+			literal, err := serializableLiteral(column, value)
+			if err != nil {
+				return 0, err
+			}
+			record.Items[i] = Item{Literal: literal}
+		}
+
+		if err := tm.toastRecord(rw.tempName, rw.target, &record); err != nil {
+			return 0, err
+		}
+
+		serialized := SerializeRecord(rw.target, record)
+		newPage, page_order, err := tm.FindOrCreatePage(rw.tempName, serialized)
+		if err != nil {
+			return 0, err
+		}
+		if err := tm.writePage(rw.tempName, page_order, newPage); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records) - from, nil
+}
+
+// checkConvertible reports whether every value of column from can be
+// represented under column to. Conversions to varchar may still fail for
+// individual values that do not fit the new length.
+func checkConvertible(from, to Column) error {
+	if from.Type == to.Type {
+		if from.Type == TypeVarchar {
+			if to.Length < from.Length {
+				return errors.New("cannot shrink varchar column")
+			}
+			return nil
+		}
+		return errors.New("column already has type " + to.Type.String())
+	}
+
+	allowed := map[ColumnType][]ColumnType{
+		TypeInt:       {TypeFloat, TypeVarchar},
+		TypeFloat:     {TypeVarchar},
+		TypeDate:      {TypeTimestamp, TypeVarchar},
+		TypeTimestamp: {TypeVarchar},
+		TypeJSON:      {TypeVarchar},
+	}
+	for _, t := range allowed[from.Type] {
+		if t == to.Type {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot convert column from %s to %s", from.Type, to.Type)
+}
+
+// convertValue converts a value as produced by DeserializeRecord to the
+// representation DeserializeRecord would produce for column to.
+func convertValue(value any, to Column) (any, error) {
+	switch to.Type {
+	case TypeFloat:
+		if n, ok := value.(int64); ok {
+			return float64(n), nil
+		}
+	case TypeTimestamp:
+		if s, ok := value.(string); ok {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				return nil, err
+			}
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+	case TypeVarchar:
+		var s string
+		switch v := value.(type) {
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'g', -1, 64)
+		case string:
+			s = v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			s = string(b)
+		}
+		if len(s) > to.Length {
+			return nil, fmt.Errorf("value %q of column %s exceeds length %d", s, to.Name, to.Length)
+		}
+		return s, nil
+	}
+
+	return value, nil
+}
+
+// serializableLiteral turns a value as produced by DeserializeRecord back
+// into the literal SerializeRecord expects for the column.
+func serializableLiteral(column Column, value any) (any, error) {
+	switch column.Type {
+	case TypeInt:
+		if n, ok := value.(int64); ok {
+			return int(n), nil
+		}
+	case TypeJSON:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestTableManager opens a table manager on a fresh data directory and
+// closes it when the test ends.
+func newTestTableManager(t *testing.T) *TableManager {
+	t.Helper()
+
+	tm, err := NewTableManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tm.Close() })
+	return tm
+}
+
+// heapValues returns the stored values of every record of a table, in heap
+// order, under the latest schema.
+func heapValues(t *testing.T, tm *TableManager, table string) (Schema, []map[uint16]any) {
+	t.Helper()
+
+	schema, err := tm.GetTableSchema(table + ".schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := tm.FileManager.GetFileSize(table + ".table")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []map[uint16]any
+	for pageNo := 1; pageNo <= int(size/PageSize); pageNo++ {
+		page, err := tm.readPage(table, pageNo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range pageRecords(page) {
+			if RecordSchemaVersion(data) != schema.Version {
+				t.Fatalf("record of schema version %d, want %d", RecordSchemaVersion(data), schema.Version)
+			}
+			values, err := storedValues(schema, data, tm.toastReader(table))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, values)
+		}
+	}
+	return schema, rows
+}
+
+// TestRewriteKeepsOtherColumns checks a column type rewrite converts only
+// the altered column and copies json values byte for byte, including
+// toasted ones, rather than decoding and re-encoding them.
+func TestRewriteKeepsOtherColumns(t *testing.T) {
+	docs := []string{
+		`{"z": 1, "a": 9007199254740993}`,
+		`[1.50, "x" ,null]`,
+		`{"pad": "` + strings.Repeat("y", 2*ToastThreshold) + `", "n": 9007199254740993}`,
+	}
+
+	tm := newTestTableManager(t)
+	schema := Schema{Columns: []Column{{Name: "id", Type: TypeInt}, {Name: "doc", Type: TypeJSON}}}
+	if err := tm.CreateTable("t", &schema); err != nil {
+		t.Fatal(err)
+	}
+	for i, doc := range docs {
+		if err := tm.Insert("t", Record{Items: []Item{{Literal: i}, {Literal: doc}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := tm.AlterColumnType("t", "id", TypeFloat, 0); err != nil {
+		t.Fatal(err)
+	}
+	tm.rewrites.Wait()
+	if job, _ := tm.GetRewriteJob("t"); job.Status != JobDone {
+		t.Fatalf("rewrite: got %s %s", job.Status, job.Error)
+	}
+
+	schema, rows := heapValues(t, tm, "t")
+	if len(rows) != len(docs) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(docs))
+	}
+	for i, row := range rows {
+		if got := row[schema.Columns[0].ID]; got != float64(i) {
+			t.Errorf("row %d: id %v, want %v", i, got, float64(i))
+		}
+		if got := row[schema.Columns[1].ID]; got != docs[i] {
+			t.Errorf("row %d: doc %.60q, want %.60q", i, got, docs[i])
+		}
+	}
+}
+
+// TestRewriteConcurrentInserts checks rows inserted while a rewrite copies
+// the heap, and while it catches up under the table lock, all end up in
+// the new heap exactly once.
+func TestRewriteConcurrentInserts(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  int
+		writers int
+		during  int
+	}{
+		{"empty table", 0, 1, 200},
+		{"one writer", 2000, 1, 500},
+		{"several writers", 2000, 4, 250},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTestTableManager(t)
+			schema := Schema{Columns: []Column{{Name: "name", Type: TypeVarchar, Length: 16}}}
+			if err := tm.CreateTable("t", &schema); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.before; i++ {
+				if err := tm.Insert("t", Record{Items: []Item{{Literal: fmt.Sprintf("before %d", i)}}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// widening the varchar rewrites the heap while string
+			// literals stay valid under either schema
+			if _, err := tm.AlterColumnType("t", "name", TypeVarchar, 32); err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			errs := make(chan error, tt.writers)
+			for w := 0; w < tt.writers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < tt.during; i++ {
+						if err := tm.Insert("t", Record{Items: []Item{{Literal: fmt.Sprintf("writer %d row %d", w, i)}}}); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			tm.rewrites.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+			if job, _ := tm.GetRewriteJob("t"); job.Status != JobDone {
+				t.Fatalf("rewrite: got %s %s", job.Status, job.Error)
+			}
+
+			want := make(map[string]bool)
+			for i := 0; i < tt.before; i++ {
+				want[fmt.Sprintf("before %d", i)] = true
+			}
+			for w := 0; w < tt.writers; w++ {
+				for i := 0; i < tt.during; i++ {
+					want[fmt.Sprintf("writer %d row %d", w, i)] = true
+				}
+			}
+
+			schema, rows := heapValues(t, tm, "t")
+			if schema.Columns[0].Length != 32 {
+				t.Errorf("got length %d, want 32", schema.Columns[0].Length)
+			}
+			seen := make(map[string]bool, len(rows))
+			for _, row := range rows {
+				name := row[schema.Columns[0].ID].(string)
+				if seen[name] {
+					t.Errorf("row %q copied twice", name)
+				}
+				seen[name] = true
+				if !want[name] {
+					t.Errorf("unexpected row %q", name)
+				}
+			}
+			if len(seen) != len(want) {
+				t.Errorf("got %d rows, want %d", len(seen), len(want))
+			}
+			if info, _ := tm.DescribeTable("t"); info.RowCount != int64(len(want)) {
+				t.Errorf("catalog counts %d rows, want %d", info.RowCount, len(want))
+			}
+		})
+	}
+}
+
+// TestRewriteJobForgotten checks the job of a finished rewrite is not
+// reported for a table created later under the same name.
+func TestRewriteJobForgotten(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(tm *TableManager) error
+	}{
+		{"drop", func(tm *TableManager) error { return tm.DropTable("t") }},
+		{"rename", func(tm *TableManager) error { return tm.RenameTable("t", "u") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTestTableManager(t)
+			schema := Schema{Columns: []Column{{Name: "id", Type: TypeInt}}}
+			if err := tm.CreateTable("t", &schema); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tm.AlterColumnType("t", "id", TypeFloat, 0); err != nil {
+				t.Fatal(err)
+			}
+			tm.rewrites.Wait()
+
+			if err := tt.remove(tm); err != nil {
+				t.Fatal(err)
+			}
+			schema = Schema{Columns: []Column{{Name: "id", Type: TypeInt}}}
+			if err := tm.CreateTable("t", &schema); err != nil {
+				t.Fatal(err)
+			}
+			for _, table := range []string{"t", "u"} {
+				if job, err := tm.GetRewriteJob(table); err == nil {
+					t.Errorf("%s: got job %+v, want none", table, job)
+				}
+			}
+		})
+	}
+}
@@ -4,26 +4,39 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"time"
 )
 
 func SerializeSchema(schema *Schema) []byte {
-	size := 2
-	for _, col := range schema.Columns {
+	defaults := make([][]byte, len(schema.Columns))
+	size := 4
+	for i, col := range schema.Columns {
+		if col.Default != nil {
+			defaults[i], _ = json.Marshal(col.Default)
+		}
+		size += 2
 		size += 2 + len(col.Name)
 		size += 2
 		size += 2
+		size += 2 + len(defaults[i])
 	}
 
 	buf := make([]byte, size)
 	offset := 0
 
+	binary.LittleEndian.PutUint16(buf[offset:], schema.Version)
+	offset += 2
+
 	binary.LittleEndian.PutUint16(buf[offset:], uint16(len(schema.Columns)))
 	offset += 2
 
-	for _, col := range schema.Columns {
+	for i, col := range schema.Columns {
+		binary.LittleEndian.PutUint16(buf[offset:], col.ID)
+		offset += 2
+
 		binary.LittleEndian.PutUint16(buf[offset:], uint16(len(col.Name)))
 		offset += 2
 
@@ -35,40 +48,220 @@ func SerializeSchema(schema *Schema) []byte {
 
 		binary.LittleEndian.PutUint16(buf[offset:], uint16(col.Length))
 		offset += 2
+
+		binary.LittleEndian.PutUint16(buf[offset:], uint16(len(defaults[i])))
+		offset += 2
+
+		copy(buf[offset:], defaults[i])
+		offset += len(defaults[i])
 	}
 
 	return buf
 }
 
-func DeserializeSchema(schema []byte) Schema {
-	columns := []Column{}
-	offset := 2
-	column_count := binary.LittleEndian.Uint16(schema[:offset])
+// DeserializeSchema decodes a schema encoded by SerializeSchema.
+func DeserializeSchema(data []byte) (Schema, error) {
+	schema, _, err := deserializeSchemaAt(data, 0)
+	return schema, err
+}
 
-	for column_count > 0 {
-		name_length := binary.LittleEndian.Uint16(schema[offset : offset+2])
-		offset += 2
+// errSchemaTruncated is returned for schema data that ends in the middle of
+// a field.
+var errSchemaTruncated = errors.New("schema data is truncated")
 
-		column_name := schema[offset : uint16(offset)+name_length]
-		offset += int(name_length)
+// readUint16 reads the field at *offset and advances past it.
+func readUint16(data []byte, offset *int) (uint16, error) {
+	if *offset+2 > len(data) {
+		return 0, errSchemaTruncated
+	}
+	v := binary.LittleEndian.Uint16(data[*offset:])
+	*offset += 2
+	return v, nil
+}
 
-		column_type := binary.LittleEndian.Uint16(schema[offset : offset+2])
-		offset += 2
+// readBytes reads the n bytes at *offset and advances past them.
+func readBytes(data []byte, offset *int, n int) ([]byte, error) {
+	if *offset+n > len(data) {
+		return nil, errSchemaTruncated
+	}
+	b := data[*offset : *offset+n]
+	*offset += n
+	return b, nil
+}
 
-		column_capacity := binary.LittleEndian.Uint16(schema[offset : offset+2])
-		offset += 2
+func deserializeSchemaAt(data []byte, offset int) (Schema, int, error) {
+	version, err := readUint16(data, &offset)
+	if err != nil {
+		return Schema{}, offset, err
+	}
+	column_count, err := readUint16(data, &offset)
+	if err != nil {
+		return Schema{}, offset, err
+	}
+
+	columns := make([]Column, 0, column_count)
+	for i := 0; i < int(column_count); i++ {
+		var column Column
+		var name_length, column_type, column_capacity, default_length uint16
+		var name, column_default []byte
+
+		column.ID, err = readUint16(data, &offset)
+		if err == nil {
+			name_length, err = readUint16(data, &offset)
+		}
+		if err == nil {
+			name, err = readBytes(data, &offset, int(name_length))
+		}
+		if err == nil {
+			column_type, err = readUint16(data, &offset)
+		}
+		if err == nil {
+			column_capacity, err = readUint16(data, &offset)
+		}
+		if err == nil {
+			default_length, err = readUint16(data, &offset)
+		}
+		if err == nil {
+			column_default, err = readBytes(data, &offset, int(default_length))
+		}
+		if err != nil {
+			return Schema{}, offset, err
+		}
+
+		column.Name = string(name)
+		column.Type = ColumnType(column_type)
+		column.Length = int(column_capacity)
+		if len(column_default) > 0 {
+			if err := json.Unmarshal(column_default, &column.Default); err != nil {
+				return Schema{}, offset, fmt.Errorf("default of column %s: %w", column.Name, err)
+			}
+		}
+		columns = append(columns, column)
+	}
+
+	return Schema{Version: version, Columns: columns}, offset, nil
+}
+
+// schemaMagic starts every schema file, followed by a byte giving the
+// format of the rest, so the layout can change without old files being
+// misread. Files without it are legacy, see DeserializeSchemaHistory.
+var schemaMagic = []byte("RSCH")
+
+// schemaFormat is the format byte of schema files: a uint16 count of
+// versions followed by each version encoded by SerializeSchema.
+const schemaFormat = 1
+
+// SerializeSchemaHistory encodes every version a table schema went through,
+// oldest first, so rows written under an old version can still be decoded.
+func SerializeSchemaHistory(history []Schema) []byte {
+	var buf bytes.Buffer
+	buf.Write(schemaMagic)
+	buf.WriteByte(schemaFormat)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(history)))
+	for i := range history {
+		buf.Write(SerializeSchema(&history[i]))
+	}
+	return buf.Bytes()
+}
+
+// DeserializeSchemaHistory decodes a schema file. It also reads the two
+// legacy layouts without schemaMagic: the history alone, and the single
+// schema without version, column IDs or defaults of the first releases,
+// which becomes version 1 with IDs numbered from 1 as CreateTable assigns
+// them. IsLegacySchema tells those apart so they can be rewritten.
+func DeserializeSchemaHistory(data []byte) ([]Schema, error) {
+	if !IsLegacySchema(data) {
+		if len(data) < len(schemaMagic)+1 {
+			return nil, errSchemaTruncated
+		}
+		if format := data[len(schemaMagic)]; format != schemaFormat {
+			return nil, fmt.Errorf("unknown schema format %d", format)
+		}
+		history, offset, err := deserializeHistoryAt(data, len(schemaMagic)+1)
+		if err != nil {
+			return nil, err
+		}
+		if offset != len(data) {
+			return nil, fmt.Errorf("%d bytes after the schema", len(data)-offset)
+		}
+		return history, nil
+	}
 
-		columns = append(columns, Column{Name: string(column_name), Type: ColumnType(column_type), Length: int(column_capacity)})
-		column_count--
+	if history, offset, err := deserializeHistoryAt(data, 0); err == nil && offset == len(data) && len(history) > 0 {
+		return history, nil
 	}
+	if schema, offset, err := deserializeBaselineSchema(data); err == nil && offset == len(data) {
+		return []Schema{schema}, nil
+	}
+	return nil, errors.New("unrecognized schema file")
+}
 
-	return Schema{columns}
+// IsLegacySchema reports whether schema file data predates schemaMagic.
+func IsLegacySchema(data []byte) bool {
+	return !bytes.HasPrefix(data, schemaMagic)
+}
+
+func deserializeHistoryAt(data []byte, offset int) ([]Schema, int, error) {
+	count, err := readUint16(data, &offset)
+	if err != nil {
+		return nil, offset, err
+	}
+	history := make([]Schema, 0, count)
+	for i := 0; i < int(count); i++ {
+		var schema Schema
+		schema, offset, err = deserializeSchemaAt(data, offset)
+		if err != nil {
+			return nil, offset, err
+		}
+		history = append(history, schema)
+	}
+	return history, offset, nil
+}
+
+// deserializeBaselineSchema decodes the schema files of the first
+// releases: a column count, then the name, type and length of each column.
+func deserializeBaselineSchema(data []byte) (Schema, int, error) {
+	offset := 0
+	column_count, err := readUint16(data, &offset)
+	if err != nil {
+		return Schema{}, offset, err
+	}
+
+	schema := Schema{Version: 1, Columns: make([]Column, 0, column_count)}
+	for i := 0; i < int(column_count); i++ {
+		var name_length, column_type, column_capacity uint16
+		var name []byte
+
+		name_length, err = readUint16(data, &offset)
+		if err == nil {
+			name, err = readBytes(data, &offset, int(name_length))
+		}
+		if err == nil {
+			column_type, err = readUint16(data, &offset)
+		}
+		if err == nil {
+			column_capacity, err = readUint16(data, &offset)
+		}
+		if err != nil {
+			return Schema{}, offset, err
+		}
+
+		schema.Columns = append(schema.Columns, Column{
+			ID:     uint16(i + 1),
+			Name:   string(name),
+			Type:   ColumnType(column_type),
+			Length: int(column_capacity),
+		})
+	}
+	return schema, offset, nil
 }
 
 func SerializeRecord(schema Schema, record Record) []byte {
 	column_count := len(schema.Columns)
 	var buf bytes.Buffer
 
+	binary.Write(&buf, binary.LittleEndian, schema.Version)
+
 	for i := 0; i < column_count; i++ {
 		switch schema.Columns[i].Type {
 		case TypeInt: // integer
@@ -80,7 +273,9 @@ func SerializeRecord(schema Schema, record Record) []byte {
 			}
 		case TypeVarchar: // varchar
 			literal, ok := record.Items[i].Literal.(string)
-			if ok {
+			if pointer, isToasted := record.Items[i].Literal.(ToastPointer); isToasted {
+				writeToastPointer(&buf, pointer)
+			} else if ok {
 				literal_length := len(literal)
 				binary.Write(&buf, binary.LittleEndian, int16(literal_length))
 				buf.Write([]byte(literal))
@@ -118,7 +313,9 @@ func SerializeRecord(schema Schema, record Record) []byte {
 			}
 		case TypeJSON:
 			s, ok := record.Items[i].Literal.(string)
-			if ok {
+			if pointer, isToasted := record.Items[i].Literal.(ToastPointer); isToasted {
+				writeToastPointer(&buf, pointer)
+			} else if ok {
 				binary.Write(&buf, binary.LittleEndian, int16(len(s)))
 				buf.WriteString(s)
 			} else {
@@ -130,9 +327,8 @@ func SerializeRecord(schema Schema, record Record) []byte {
 	return buf.Bytes()
 }
 
-// TODO: Extract filtering logic into a separate method
-func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]ColumnProjection) *Record {
-	offset := 0
+func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]ColumnProjection, toast ToastReader) (*Record, error) {
+	offset := RecordHeaderSize
 	items := make([]Item, 0, len(schema.Columns))
 
 	for i := 0; i < len(schema.Columns); i++ {
@@ -144,22 +340,8 @@ func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]Colu
 		case TypeInt:
 			if must_extract {
 				val := int64(binary.LittleEndian.Uint64(data[offset : offset+8]))
-				if is_filtered {
-					filterValue, ok := columnProjection[i].FilterValue.(int64)
-					if !ok {
-						return nil
-					}
-					operator := columnProjection[i].FilterOperator
-					matches := false
-					switch operator {
-					case string(OpEq):
-						matches = (val == filterValue)
-					case string(OpNe):
-						matches = (val != filterValue)
-					}
-					if !matches {
-						return nil
-					}
+				if is_filtered && !matchFilters(val, columnProjection[i].Filters) {
+					return nil, nil
 				}
 				if is_projected {
 					items = append(items, Item{Literal: val})
@@ -167,53 +349,26 @@ func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]Colu
 			}
 			offset += 8
 		case TypeVarchar: // varchar
-			strlen := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
-			offset += 2
+			raw, next, err := readVarlena(data, offset, must_extract, toast)
+			if err != nil {
+				return nil, err
+			}
+			offset = next
 			if must_extract {
-				str := string(data[offset : offset+strlen])
-				if is_filtered {
-					filterValue, ok := columnProjection[i].FilterValue.(string)
-					if !ok {
-						return nil
-					}
-					operator := columnProjection[i].FilterOperator
-					matches := false
-					switch operator {
-					case string(OpEq):
-						matches = (str == filterValue)
-					case string(OpNe):
-						matches = (str != filterValue)
-					}
-					if !matches {
-						return nil
-					}
+				str := string(raw)
+				if is_filtered && !matchFilters(str, columnProjection[i].Filters) {
+					return nil, nil
 				}
 				if is_projected {
 					items = append(items, Item{Literal: str})
 				}
 			}
-
-			offset += strlen
 		case TypeDate: // date
 			if must_extract {
 				v := int32(binary.LittleEndian.Uint32(data[offset : offset+4]))
 				dateStr := dateStringFromDays(v)
-				if is_filtered {
-					filterValue, ok := columnProjection[i].FilterValue.(string)
-					if !ok {
-						return nil
-					}
-					operator := columnProjection[i].FilterOperator
-					matches := false
-					switch operator {
-					case string(OpEq):
-						matches = (dateStr == filterValue)
-					case string(OpNe):
-						matches = (dateStr != filterValue)
-					}
-					if !matches {
-						return nil
-					}
+				if is_filtered && !matchFilters(dateStr, columnProjection[i].Filters) {
+					return nil, nil
 				}
 				if is_projected {
 					items = append(items, Item{Literal: dateStr})
@@ -224,22 +379,8 @@ func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]Colu
 			if must_extract {
 				v := int64(binary.LittleEndian.Uint64(data[offset : offset+8]))
 				timestampStr := timestampStringFromMicros(v)
-				if is_filtered {
-					filterValue, ok := columnProjection[i].FilterValue.(string)
-					if !ok {
-						return nil
-					}
-					operator := columnProjection[i].FilterOperator
-					matches := false
-					switch operator {
-					case string(OpEq):
-						matches = (timestampStr == filterValue)
-					case string(OpNe):
-						matches = (timestampStr != filterValue)
-					}
-					if !matches {
-						return nil
-					}
+				if is_filtered && !matchFilters(timestampStr, columnProjection[i].Filters) {
+					return nil, nil
 				}
 				if is_projected {
 					items = append(items, Item{Literal: timestampStr})
@@ -250,22 +391,8 @@ func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]Colu
 			if must_extract {
 				bits := binary.LittleEndian.Uint64(data[offset : offset+8])
 				f := math.Float64frombits(bits)
-				if is_filtered {
-					filterValue, ok := columnProjection[i].FilterValue.(float64)
-					if !ok {
-						return nil
-					}
-					operator := columnProjection[i].FilterOperator
-					matches := false
-					switch operator {
-					case string(OpEq):
-						matches = (f == filterValue)
-					case string(OpNe):
-						matches = (f != filterValue)
-					}
-					if !matches {
-						return nil
-					}
+				if is_filtered && !matchFilters(f, columnProjection[i].Filters) {
+					return nil, nil
 				}
 				if is_projected {
 					items = append(items, Item{Literal: f})
@@ -273,10 +400,12 @@ func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]Colu
 			}
 			offset += 8
 		case TypeJSON: // json
-			strlen := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
-			offset += 2
+			raw, next, err := readVarlena(data, offset, is_projected, toast)
+			if err != nil {
+				return nil, err
+			}
+			offset = next
 			if is_projected {
-				raw := data[offset : offset+strlen]
 				var v any
 				if err := json.Unmarshal(raw, &v); err != nil {
 					items = append(items, Item{Literal: string(raw)})
@@ -284,15 +413,128 @@ func DeserializeRecord(schema Schema, data []byte, columnProjection map[int]Colu
 					items = append(items, Item{Literal: v})
 				}
 			}
-			offset += strlen
 		}
 	}
 
 	if len(items) == 0 {
-		return nil
+		return nil, nil
+	}
+
+	return &Record{Items: items}, nil
+}
+
+// matchFilters reports whether a column value passes every filter on its
+// column. A filter value of another type than the column's never matches.
+func matchFilters[T comparable](value T, filters []Filter) bool {
+	for _, filter := range filters {
+		filterValue, ok := filter.Value.(T)
+		if !ok {
+			return false
+		}
+		switch filter.Operator {
+		case string(OpEq):
+			if value != filterValue {
+				return false
+			}
+		case string(OpNe):
+			if value == filterValue {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RecordHeaderSize is the size of the schema version every record starts with.
+const RecordHeaderSize = 2
+
+func RecordSchemaVersion(data []byte) uint16 {
+	return binary.LittleEndian.Uint16(data[:RecordHeaderSize])
+}
+
+// UpgradeRecord decodes a record written under an older schema version and
+// lays its values out in the order of the current schema. Columns added
+// since then take their default value, dropped columns are discarded and
+// renamed ones are matched by column ID.
+func UpgradeRecord(old Schema, current Schema, data []byte, toast ToastReader) (Record, error) {
+	projection := make(map[int]ColumnProjection, len(old.Columns))
+	for i, column := range old.Columns {
+		projection[i] = ColumnProjection{Name: column.Name, Index: i, IsProjected: true, MustExtract: true}
+	}
+
+	values := make(map[uint16]any, len(old.Columns))
+	rec, err := DeserializeRecord(old, data, projection, toast)
+	if err != nil {
+		return Record{}, err
+	}
+	if rec != nil {
+		for i, column := range old.Columns {
+			values[column.ID] = rec.Items[i].Literal
+		}
+	}
+
+	items := make([]Item, len(current.Columns))
+	for i, column := range current.Columns {
+		if v, ok := values[column.ID]; ok {
+			items[i] = Item{Literal: v}
+		} else {
+			items[i] = Item{Literal: column.DefaultLiteral()}
+		}
+	}
+
+	return Record{Items: items}, nil
+}
+
+// storedValues decodes every value of a record by column ID, like
+// DeserializeRecord, except that json values are kept as the text they
+// were stored as, so copying them into another record leaves them as they
+// were written.
+func storedValues(schema Schema, data []byte, toast ToastReader) (map[uint16]any, error) {
+	values := make(map[uint16]any, len(schema.Columns))
+	offset := RecordHeaderSize
+	fixed := func(size int) ([]byte, error) {
+		if offset+size > len(data) {
+			return nil, errors.New("record is truncated")
+		}
+		offset += size
+		return data[offset-size : offset], nil
+	}
+
+	for _, column := range schema.Columns {
+		switch column.Type {
+		case TypeInt, TypeTimestamp, TypeFloat:
+			b, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			bits := binary.LittleEndian.Uint64(b)
+			switch column.Type {
+			case TypeInt:
+				values[column.ID] = int64(bits)
+			case TypeTimestamp:
+				values[column.ID] = timestampStringFromMicros(int64(bits))
+			default:
+				values[column.ID] = math.Float64frombits(bits)
+			}
+		case TypeDate:
+			b, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			values[column.ID] = dateStringFromDays(int32(binary.LittleEndian.Uint32(b)))
+		case TypeVarchar, TypeJSON:
+			raw, next, err := readVarlena(data, offset, true, toast)
+			if err != nil {
+				return nil, err
+			}
+			offset = next
+			values[column.ID] = string(raw)
+		}
 	}
 
-	return &Record{Items: items}
+	return values, nil
 }
 
 func DeserializeFSM(data []byte) []uint16 {
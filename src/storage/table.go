@@ -4,6 +4,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
 )
 
 type ColumnType int
@@ -17,6 +21,24 @@ const (
 	TypeJSON
 )
 
+func (t ColumnType) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeVarchar:
+		return "varchar"
+	case TypeDate:
+		return "date"
+	case TypeTimestamp:
+		return "timestamp"
+	case TypeFloat:
+		return "float"
+	case TypeJSON:
+		return "json"
+	}
+	return fmt.Sprintf("ColumnType(%d)", int(t))
+}
+
 type FilterOperator string
 
 const (
@@ -31,12 +53,43 @@ const (
 )
 
 type Column struct {
-	Name   string
-	Type   ColumnType
-	Length int
+	ID      uint16     `json:"id"`
+	Name    string     `json:"name"`
+	Type    ColumnType `json:"type"`
+	Length  int        `json:"length"`
+	Default any        `json:"default,omitempty"`
+}
+
+// DefaultLiteral returns the column default in the same representation
+// DeserializeRecord produces for the column type, falling back to the zero
+// value of the type when the column has no default.
+func (c Column) DefaultLiteral() any {
+	switch c.Type {
+	case TypeInt:
+		n, _ := c.Default.(float64)
+		return int64(n)
+	case TypeFloat:
+		f, _ := c.Default.(float64)
+		return f
+	case TypeVarchar:
+		s, _ := c.Default.(string)
+		return s
+	case TypeDate:
+		if s, ok := c.Default.(string); ok {
+			return s
+		}
+		return dateStringFromDays(0)
+	case TypeTimestamp:
+		if s, ok := c.Default.(string); ok {
+			return s
+		}
+		return timestampStringFromMicros(0)
+	}
+	return c.Default
 }
 
 type Schema struct {
+	Version uint16
 	Columns []Column
 }
 
@@ -57,13 +110,13 @@ type SelectedColumns struct {
 }
 
 type ColumnProjection struct {
-	Name           string
-	Index          int
-	IsFiltered     bool
-	IsProjected    bool
-	MustExtract    bool
-	FilterValue    any
-	FilterOperator string
+	Name        string
+	Index       int
+	IsFiltered  bool
+	IsProjected bool
+	MustExtract bool
+	// Filters are all the filters on the column; a value must pass each.
+	Filters []Filter
 }
 
 type Item struct {
@@ -72,13 +125,40 @@ type Item struct {
 
 type TableManager struct {
 	FileManager *FileManager
+	Catalog     *Catalog
+
+	// CompressToast compresses out-of-line values when that makes them smaller.
+	CompressToast bool
+
+	locksMu sync.Mutex
+	locks   map[string]*sync.RWMutex
+
+	jobsMu   sync.Mutex
+	jobs     map[string]*RewriteJob
+	rewrites sync.WaitGroup
+
+	fsmMu sync.Mutex
+	fsms  map[string]*freeSpaceMap
 }
 
 type TableI interface {
 	CreateTable(name string, schema *Schema) error
 	Insert(tableName string, record Record) error
+	InsertBatch(tableName string, records []Record) (map[int]error, error)
 	GetAllData(tableName string, filters []Filter, selectedColumns SelectedColumns) ([]map[string]any, error)
 	GetTableSchema(schemaName string) (Schema, error)
+	ListTables() []TableInfo
+	DescribeTable(name string) (TableInfo, error)
+	DropTable(name string) error
+	Truncate(name string) error
+	RenameTable(oldName string, newName string) error
+	AddColumn(tableName string, column Column) error
+	DropColumn(tableName string, columnName string) error
+	RenameColumn(tableName string, columnName string, newName string) error
+	AlterColumnType(tableName string, columnName string, newType ColumnType, length int) (RewriteJob, error)
+	GetRewriteJob(tableName string) (RewriteJob, error)
+	InspectPage(tableName string, pageNo int, withHex bool) (PageInspection, error)
+	Close() error
 }
 
 const PageSize = 8192
@@ -86,6 +166,8 @@ const PageSize = 8192
 type PageHeader struct {
 	RecordCount      uint16
 	FreeSpacePointer uint16
+	Checksum         uint32
+	LSN              uint64
 }
 
 type ItemPointer struct {
@@ -99,31 +181,190 @@ type Page struct {
 	Items  []ItemPointer
 }
 
-func NewTableManager(dataDir string) (*TableManager, error) {
+func NewTableManager(dataDir string) (tm *TableManager, err error) {
 	fileManager, err := NewFileManager(dataDir)
 	if err != nil {
 		return nil, err
 	}
-	return &TableManager{FileManager: fileManager}, nil
+	defer func() {
+		if err != nil {
+			fileManager.abandon()
+		}
+	}()
+	if err := migrateSchemaFiles(fileManager); err != nil {
+		return nil, err
+	}
+	catalog, err := LoadCatalog(fileManager)
+	if err != nil {
+		return nil, err
+	}
+	tm = &TableManager{
+		FileManager:   fileManager,
+		Catalog:       catalog,
+		CompressToast: true,
+		locks:         make(map[string]*sync.RWMutex),
+		jobs:          make(map[string]*RewriteJob),
+		fsms:          make(map[string]*freeSpaceMap),
+	}
+	if err := tm.removeOrphanFiles(); err != nil {
+		return nil, err
+	}
+
+	// tables created before out-of-line storage existed have no toast file
+	for _, info := range catalog.List() {
+		if !fileManager.FileExists(info.Name + ".toast") {
+			if _, err := fileManager.CreateFile(info.Name + ".toast"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if fileManager.UncleanShutdown() {
+		log.Printf("storage: %s was not shut down cleanly, checking free space maps and row counts", dataDir)
+		if err := tm.repairFreeSpaceMaps(); err != nil {
+			return nil, err
+		}
+		if err := tm.syncCatalogColumns(); err != nil {
+			return nil, err
+		}
+		if err := catalog.Recount(); err != nil {
+			return nil, err
+		}
+	}
+
+	return tm, nil
 }
 
-func (tm *TableManager) CreateTable(name string, schema *Schema) error {
-	schema_exist := tm.FileManager.FileExists(name + ".schema")
+// Close waits for running column type rewrites to finish, saves the row
+// counts of the catalog, then syncs and closes the files of the data
+// directory. Calls made after Close fail with ErrClosed; calls still
+// running when it is called may fail as well.
+func (tm *TableManager) Close() error {
+	tm.rewrites.Wait()
+	if err := tm.Catalog.Save(); err != nil && !errors.Is(err, ErrClosed) {
+		tm.FileManager.Close()
+		return err
+	}
+	return tm.FileManager.Close()
+}
 
-	if schema_exist {
-		return errors.New("schema already exists")
+// syncCatalogColumns copies the columns of the latest schema of every table
+// into the catalog, which still has the old ones when a column type
+// rewrite was interrupted after swapping the files.
+func (tm *TableManager) syncCatalogColumns() error {
+	for _, info := range tm.Catalog.List() {
+		if !tm.FileManager.FileExists(info.Name + ".schema") {
+			continue
+		}
+		history, err := tm.GetSchemaHistory(info.Name + ".schema")
+		if err != nil {
+			return err
+		}
+		if err := tm.Catalog.UpdateColumns(info.Name, history[len(history)-1].Columns); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	table_exist := tm.FileManager.FileExists(name + ".table")
+// tableFileExtensions lists the files every table owns in the data directory.
+var tableFileExtensions = []string{".schema", ".table", ".fsm", ".toast"}
 
-	if table_exist {
-		return errors.New("table already exists")
+// removeOrphanFiles deletes table files that have no catalog entry, which is
+// what a DropTable interrupted after its catalog update leaves behind.
+func (tm *TableManager) removeOrphanFiles() error {
+	for _, fileName := range tm.FileManager.Files() {
+		for _, ext := range tableFileExtensions {
+			if !strings.HasSuffix(fileName, ext) {
+				continue
+			}
+			if tm.Catalog.Exists(strings.TrimSuffix(fileName, ext)) {
+				continue
+			}
+			if err := tm.FileManager.DeleteFile(fileName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (tm *TableManager) tableLock(name string) *sync.RWMutex {
+	tm.locksMu.Lock()
+	defer tm.locksMu.Unlock()
+
+	lock, ok := tm.locks[name]
+	if !ok {
+		lock = &sync.RWMutex{}
+		tm.locks[name] = lock
+	}
+	return lock
+}
+
+// reservedTableSuffixes end the names of the files the storage engine
+// stages next to a table's own, so a table named like one would have its
+// files taken for them.
+var reservedTableSuffixes = []string{rewriteSuffix, migrateSuffix, JournalSuffix}
+
+// ValidateTableName checks that a table name can be used to name the files
+// of the table in the data directory.
+func ValidateTableName(name string) error {
+	if name == "" {
+		return errors.New("table name is required")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid table name %q: path separators and .. are not allowed", name)
+	}
+	for _, suffix := range reservedTableSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return fmt.Errorf("invalid table name %q: the %s suffix is reserved", name, suffix)
+		}
+	}
+	return nil
+}
+
+// MaxVarcharLength is the largest varchar length the schema file can
+// record.
+const MaxVarcharLength = math.MaxUint16
+
+// ValidateColumn checks that a column definition fits the schema file.
+func ValidateColumn(column Column) error {
+	if column.Name == "" {
+		return errors.New("column name is required")
+	}
+	if len(column.Name) > math.MaxUint16 {
+		return fmt.Errorf("column name of %d bytes is too long", len(column.Name))
+	}
+	if column.Type == TypeVarchar && (column.Length < 0 || column.Length > MaxVarcharLength) {
+		return fmt.Errorf("column %s: varchar length %d is out of range, the maximum is %d", column.Name, column.Length, MaxVarcharLength)
+	}
+	return nil
+}
+
+// CreateTable holds the table lock from the existence check until the
+// catalog entry is added, so two creates of the same name can not both
+// pass the check and overwrite each other's files.
+func (tm *TableManager) CreateTable(name string, schema *Schema) error {
+	if err := ValidateTableName(name); err != nil {
+		return err
+	}
+	for _, column := range schema.Columns {
+		if err := ValidateColumn(column); err != nil {
+			return err
+		}
 	}
 
-	fsm_exist := tm.FileManager.FileExists(name + ".fsm")
+	lock := tm.tableLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if tm.Catalog.Exists(name) {
+		return errors.New("table already exists")
+	}
 
-	if fsm_exist {
-		return errors.New("fsm file already exists")
+	schema.Version = 1
+	for i := range schema.Columns {
+		schema.Columns[i].ID = uint16(i + 1)
 	}
 
 	schema_file, err := tm.FileManager.CreateFile(name + ".schema")
@@ -144,33 +385,171 @@ func (tm *TableManager) CreateTable(name string, schema *Schema) error {
 		return err
 	}
 
-	serialized_schema := SerializeSchema(schema)
+	_, err = tm.FileManager.CreateFile(name + ".toast")
+
+	if err != nil {
+		return err
+	}
+
+	serialized_schema := SerializeSchemaHistory([]Schema{*schema})
 	_, err = schema_file.Write(serialized_schema)
 
 	if err != nil {
 		return err
 	}
 
+	return tm.Catalog.AddTable(name, schema)
+}
+
+func (tm *TableManager) ListTables() []TableInfo {
+	return tm.Catalog.List()
+}
+
+func (tm *TableManager) DescribeTable(name string) (TableInfo, error) {
+	return tm.Catalog.Get(name)
+}
+
+// DropTable removes a table from the catalog first and only then deletes its
+// files, so a crash in between leaves orphans that are swept on next start
+// rather than a half-dropped table.
+func (tm *TableManager) DropTable(name string) error {
+	lock := tm.tableLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := tm.checkNotRewriting(name); err != nil {
+		return err
+	}
+
+	if err := tm.Catalog.RemoveTable(name); err != nil {
+		return err
+	}
+	tm.forgetFSM(name)
+	tm.forgetJob(name)
+
+	for _, ext := range tableFileExtensions {
+		if err := tm.FileManager.DeleteFile(name + ext); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// RenameTable renames the files of a table and its catalog entry as a single
+// journaled step, so after a crash the table is found either entirely under
+// the old name or entirely under the new one.
+func (tm *TableManager) RenameTable(oldName string, newName string) error {
+	if err := ValidateTableName(newName); err != nil {
+		return err
+	}
+	if oldName == newName {
+		return errors.New("table already has this name")
+	}
+
+	first, second := tm.tableLock(oldName), tm.tableLock(newName)
+	if newName < oldName {
+		first, second = second, first
+	}
+	first.Lock()
+	defer first.Unlock()
+	second.Lock()
+	defer second.Unlock()
+
+	if err := tm.checkNotRewriting(oldName); err != nil {
+		return err
+	}
+
+	return tm.Catalog.RenameTable(oldName, newName, func(data []byte) error {
+		staged := CatalogFileName + ".next"
+		if _, err := tm.FileManager.CreateFile(staged); err != nil {
+			return err
+		}
+		if err := tm.FileManager.WriteAll(staged, data); err != nil {
+			return err
+		}
+
+		renames := make([][2]string, 0, len(tableFileExtensions)+1)
+		for _, ext := range tableFileExtensions {
+			renames = append(renames, [2]string{oldName + ext, newName + ext})
+		}
+		renames = append(renames, [2]string{staged, CatalogFileName})
+
+		err := tm.FileManager.RenameFiles("rename~"+oldName, renames)
+		if err == nil || errors.Is(err, ErrRenamePending) {
+			tm.forgetFSM(oldName)
+			tm.forgetFSM(newName)
+			tm.forgetJob(oldName)
+			tm.forgetJob(newName)
+		}
+		return err
+	})
+}
+
+func (tm *TableManager) Truncate(name string) error {
+	lock := tm.tableLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !tm.Catalog.Exists(name) {
+		return errors.New("table does not exist")
+	}
+
+	if err := tm.checkNotRewriting(name); err != nil {
+		return err
+	}
+
+	if err := tm.FileManager.TruncateFile(name + ".fsm"); err != nil {
+		return err
+	}
+	tm.forgetFSM(name)
+	if err := tm.FileManager.TruncateFile(name + ".table"); err != nil {
+		return err
+	}
+	if err := tm.FileManager.TruncateFile(name + ".toast"); err != nil {
+		return err
+	}
+
+	return tm.Catalog.ResetCounts(name)
+}
+
 func (tm *TableManager) GetTableSchema(schemaName string) (schema Schema, err error) {
 	schema = Schema{}
 
+	history, err := tm.GetSchemaHistory(schemaName)
+	if err != nil {
+		return schema, err
+	}
+
+	return history[len(history)-1], nil
+}
+
+// GetSchemaHistory returns every version of a table schema, oldest first.
+func (tm *TableManager) GetSchemaHistory(schemaName string) ([]Schema, error) {
 	if !tm.FileManager.FileExists(schemaName) {
-		return schema, errors.New("table does not exist")
+		return nil, errors.New("table does not exist")
 	}
 
 	data, err := tm.FileManager.ReadAll(schemaName)
 	if err != nil {
-		return schema, err
+		return nil, err
+	}
+
+	history, err := DeserializeSchemaHistory(data)
+	if err != nil {
+		return nil, fmt.Errorf("table schema: %w", err)
+	}
+	if len(history) == 0 {
+		return nil, errors.New("table schema is empty")
 	}
-	schema = DeserializeSchema(data)
 
-	return schema, nil
+	return history, nil
 }
 
 func (tm *TableManager) Insert(tableName string, record Record) error {
+	lock := tm.tableLock(tableName)
+	lock.Lock()
+	defer lock.Unlock()
 
 	schema, err := tm.GetTableSchema(tableName + ".schema")
 
@@ -180,6 +559,10 @@ func (tm *TableManager) Insert(tableName string, record Record) error {
 		return err
 	}
 
+	if err := tm.toastRecord(tableName, schema, &record); err != nil {
+		return err
+	}
+
 	serialized_record := SerializeRecord(schema, record)
 
 	page, page_order, err := tm.FindOrCreatePage(tableName, serialized_record)
@@ -190,7 +573,7 @@ func (tm *TableManager) Insert(tableName string, record Record) error {
 		return err
 	}
 
-	err = tm.FileManager.Write(tableName+".table", (int64(page_order)-1)*8192, page)
+	err = tm.writePage(tableName, page_order, page)
 
 	if err != nil {
 		fmt.Println("page section")
@@ -198,14 +581,23 @@ func (tm *TableManager) Insert(tableName string, record Record) error {
 		return err
 	}
 
-	return nil
+	return tm.Catalog.RecordInsert(tableName, 1, int64(page_order))
 }
 
 func (tm *TableManager) GetAllData(tableName string, filters []Filter, selectedColumns SelectedColumns) ([]map[string]any, error) {
-	schema, err := tm.GetTableSchema(tableName + ".schema")
+	lock := tm.tableLock(tableName)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	history, err := tm.GetSchemaHistory(tableName + ".schema")
 	if err != nil {
 		return nil, err
 	}
+	schema := history[len(history)-1]
+	versions := make(map[uint16]Schema, len(history))
+	for _, version := range history {
+		versions[version.Version] = version
+	}
 
 	data := make([]map[string]any, 0)
 
@@ -220,7 +612,9 @@ func (tm *TableManager) GetAllData(tableName string, filters []Filter, selectedC
 	fsm_data := DeserializeFSM(fsm_binary_data)
 	pages_count := len(fsm_data)
 
-	empty_free := PageSize - 8
+	empty_free := EmptyPageFree
+	columnProjection := BuildColumnProjection(schema, filters, selectedColumns)
+	toast := tm.toastReader(tableName)
 
 	for i := 1; i <= pages_count; i++ {
 		fsm_free := int(fsm_data[i-1])
@@ -228,8 +622,7 @@ func (tm *TableManager) GetAllData(tableName string, filters []Filter, selectedC
 			continue
 		}
 
-		offsetBytes := int64((i - 1) * PageSize)
-		page, err := tm.FileManager.Read(tableName+".table", offsetBytes, int64(PageSize))
+		page, err := tm.readPage(tableName, i)
 		if err != nil {
 			return nil, err
 		}
@@ -240,8 +633,26 @@ func (tm *TableManager) GetAllData(tableName string, filters []Filter, selectedC
 			record_offset := uint16(binary.LittleEndian.Uint16(page[offset-2 : offset]))
 			offset -= 2
 			record_length := uint16(binary.LittleEndian.Uint16(page[offset-2 : offset]))
-			columnProjection := BuildColumnProjection(schema, filters, selectedColumns)
-			rec := DeserializeRecord(schema, page[record_offset:record_offset+record_length], columnProjection)
+			record_data := page[record_offset : record_offset+record_length]
+
+			var rec *Record
+			if version := RecordSchemaVersion(record_data); version == schema.Version {
+				rec, err = DeserializeRecord(schema, record_data, columnProjection, toast)
+				if err != nil {
+					return nil, err
+				}
+			} else if old, ok := versions[version]; ok {
+				upgraded, err := UpgradeRecord(old, schema, record_data, toast)
+				if err != nil {
+					return nil, err
+				}
+				if recordFilter(upgraded, filters, schema) {
+					rec = projectRecord(upgraded, columnProjection)
+				}
+			} else {
+				return nil, fmt.Errorf("unknown schema version %d in table %s", version, tableName)
+			}
+
 			if rec != nil {
 				row := make(map[string]any)
 				itemIndex := 0
@@ -262,6 +673,23 @@ func (tm *TableManager) GetAllData(tableName string, filters []Filter, selectedC
 
 }
 
+// projectRecord keeps only the projected items of a fully decoded record,
+// mirroring what DeserializeRecord returns for the current schema version.
+func projectRecord(record Record, columnProjection map[int]ColumnProjection) *Record {
+	items := make([]Item, 0, len(record.Items))
+	for i, item := range record.Items {
+		if columnProjection[i].IsProjected {
+			items = append(items, item)
+		}
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	return &Record{Items: items}
+}
+
 func recordFilter(record Record, filters []Filter, schema Schema) bool {
 	for _, filter := range filters {
 		recordValue := record.Items[filter.ColumnIndex].Literal
@@ -306,101 +734,55 @@ func compareEqual(a, b interface{}, colType ColumnType) bool {
 	return false
 }
 
+// FindOrCreatePage adds a record to a page with enough free space, or to a
+// new page appended to the heap, and returns the page for the caller to
+// write. The FSM is updated right away.
 func (tm *TableManager) FindOrCreatePage(tableName string, record []byte) (page []byte, page_order int, err error) {
-	record_size := uint16(len(record))
-	fsm_size, err := tm.FileManager.GetFileSize(tableName + ".fsm")
-
-	if err != nil {
-		return nil, 0, err
-	}
-
-	fsm_binary_data, err := tm.FileManager.Read(tableName+".fsm", 0, fsm_size)
-
-	if err != nil {
-		return nil, 0, err
+	if len(record) > MaxRecordSize {
+		return nil, 0, errors.New("record is too large")
 	}
 
-	pages_count := int(len(fsm_binary_data) / 2)
-	table_size, err := tm.FileManager.GetFileSize(tableName + ".table")
+	fsm, err := tm.loadFSM(tableName)
 	if err != nil {
 		return nil, 0, err
 	}
-	table_pages := int(table_size / PageSize)
-	if table_pages != pages_count {
-		return nil, 0, errors.New("fsm data is not compatible with table")
-	}
 
-	fsm_data := DeserializeFSM(fsm_binary_data)
-
-	for i := 1; i <= pages_count; i++ {
-		fsm_free := int(fsm_data[i-1])
-		if fsm_free < int(record_size)+4 {
-			continue
-		}
-
-		offset := int64((i - 1) * PageSize)
-		page, err = tm.FileManager.Read(tableName+".table", offset, int64(PageSize))
+	if i := fsm.find(len(record) + 4); i >= 0 {
+		page, err = tm.readPage(tableName, i+1)
 		if err != nil {
 			return nil, 0, err
 		}
 
-		record_count := uint16(binary.LittleEndian.Uint16(page[:2]))
-		free_space_pointer := uint16(binary.LittleEndian.Uint16(page[2:4]))
-		actual_free := PageSize - ((int(record_count)+1)*4 + int(free_space_pointer))
-
-		if actual_free != fsm_free {
+		if PageFreeSpace(page) != int(fsm.levels[0][i]) {
 			return nil, 0, errors.New("fsm and page free space mismatch")
 		}
 
-		slot_beginning_address := (PageSize - int(record_count)*4) - 4
-		record_count++
-		binary.LittleEndian.PutUint16(page[:2], uint16(record_count))
-		copy(page[free_space_pointer:], record)
-		binary.LittleEndian.PutUint16(page[slot_beginning_address+2:slot_beginning_address+4], uint16(free_space_pointer))
-		binary.LittleEndian.PutUint16(page[slot_beginning_address:slot_beginning_address+2], record_size)
-
-		free_space_pointer += record_size
-		binary.LittleEndian.PutUint16(page[2:4], uint16(free_space_pointer))
-
-		new_free := fsm_free - (int(record_size) + 4)
-		if new_free < 0 {
-			new_free = 0
-		}
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, uint16(new_free))
-		if err := tm.FileManager.Write(tableName+".fsm", int64((i-1)*2), buf); err != nil {
+		new_free := addToPage(page, record)
+		if err := tm.setPageFree(tableName, fsm, i, new_free); err != nil {
 			return nil, 0, err
 		}
 
-		page_order = i
-		return page, page_order, nil
+		fsm.hint = i
+		return page, i + 1, nil
 	}
 
 	page = make([]byte, PageSize)
-	binary.LittleEndian.PutUint16(page[:2], uint16(1))
-	binary.LittleEndian.PutUint16(page[2:4], uint16(4+len(record)))
-	copy(page[4:], record)
-	binary.LittleEndian.PutUint16(page[PageSize-4:PageSize-2], uint16(len(record)))
-	binary.LittleEndian.PutUint16(page[PageSize-2:PageSize], uint16(4))
-
-	remaining_free := PageSize - (12 + len(record))
-	buf := make([]byte, 2)
-	binary.LittleEndian.PutUint16(buf, uint16(remaining_free))
-	if err := tm.FileManager.Write(tableName+".fsm", int64(len(fsm_binary_data)), buf); err != nil {
+	binary.LittleEndian.PutUint16(page[2:4], uint16(PageHeaderSize))
+	remaining_free := addToPage(page, record)
+
+	pages_count := fsm.pages()
+	if err := tm.setPageFree(tableName, fsm, pages_count, remaining_free); err != nil {
 		return nil, 0, err
 	}
 
+	fsm.hint = pages_count
 	return page, pages_count + 1, nil
 }
 
 func BuildColumnProjection(schema Schema, filters []Filter, selectedColumns SelectedColumns) map[int]ColumnProjection {
-	filteredCols := make(map[string]bool)
-	filterValues := make(map[string]any)
-	filterOperator := make(map[string]string)
+	columnFilters := make(map[string][]Filter)
 	for _, filter := range filters {
-		filteredCols[filter.Column] = true
-		filterValues[filter.Column] = filter.Value
-		filterOperator[filter.Column] = filter.Operator
+		columnFilters[filter.Column] = append(columnFilters[filter.Column], filter)
 	}
 
 	projectedCols := make(map[string]bool)
@@ -410,17 +792,16 @@ func BuildColumnProjection(schema Schema, filters []Filter, selectedColumns Sele
 
 	projection := make(map[int]ColumnProjection)
 	for i, column := range schema.Columns {
-		isFiltered := filteredCols[column.Name]
+		isFiltered := len(columnFilters[column.Name]) > 0
 		isProjected := projectedCols[column.Name]
 
 		projection[i] = ColumnProjection{
-			Name:           column.Name,
-			Index:          i,
-			IsFiltered:     isFiltered,
-			IsProjected:    isProjected,
-			MustExtract:    isFiltered || isProjected,
-			FilterValue:    filterValues[column.Name],
-			FilterOperator: filterOperator[column.Name],
+			Name:        column.Name,
+			Index:       i,
+			IsFiltered:  isFiltered,
+			IsProjected: isProjected,
+			MustExtract: isFiltered || isProjected,
+			Filters:     columnFilters[column.Name],
 		}
 	}
 
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestCreateTableConcurrent checks only one of several creates of the same
+// table succeeds and the schema it wrote is the one left on disk.
+func TestCreateTableConcurrent(t *testing.T) {
+	const creators = 8
+
+	for round := 0; round < 20; round++ {
+		tm := newTestTableManager(t)
+
+		var wg sync.WaitGroup
+		created := make(chan int, creators)
+		for i := 0; i < creators; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				schema := Schema{Columns: []Column{{Name: "id", Type: TypeInt}, {Name: "creator", Type: TypeVarchar, Length: i + 1}}}
+				if err := tm.CreateTable("t", &schema); err == nil {
+					created <- i
+				}
+			}()
+		}
+		wg.Wait()
+		close(created)
+
+		var winners []int
+		for i := range created {
+			winners = append(winners, i)
+		}
+		if len(winners) != 1 {
+			t.Fatalf("round %d: %d creates succeeded, want 1", round, len(winners))
+		}
+
+		schema, err := tm.GetTableSchema("t.schema")
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if got := schema.Columns[1].Length; got != winners[0]+1 {
+			t.Errorf("round %d: schema file has length %d, the create that succeeded wrote %d", round, got, winners[0]+1)
+		}
+	}
+}
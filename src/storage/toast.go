@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// ToastThreshold is the size above which varchar and json values are
+	// moved out of the record into the table's `.toast` file.
+	ToastThreshold = 2000
+	// ToastChunkSize is the largest piece a toasted value is split into.
+	ToastChunkSize = 2000
+	// MaxRecordSize is the largest record that fits into an empty page next
+	// to the page header and its slot.
+	MaxRecordSize = PageSize - PageHeaderSize - 8
+
+	// toastMarker replaces the inline length of a varchar or json value
+	// when the record holds a ToastPointer instead of the value itself.
+	toastMarker      = 0xFFFF
+	toastPointerSize = 1 + 8 + 4 + 4
+	toastCompressed  = 1
+)
+
+// ToastPointer locates a value stored out of line in the `.toast` file.
+type ToastPointer struct {
+	Offset       uint64
+	RawLength    uint32
+	StoredLength uint32
+	Compressed   bool
+}
+
+// ToastReader loads the raw bytes of a toasted value.
+type ToastReader func(pointer ToastPointer) ([]byte, error)
+
+func writeToastPointer(buf *bytes.Buffer, pointer ToastPointer) {
+	binary.Write(buf, binary.LittleEndian, uint16(toastMarker))
+	var flags uint8
+	if pointer.Compressed {
+		flags |= toastCompressed
+	}
+	buf.WriteByte(flags)
+	binary.Write(buf, binary.LittleEndian, pointer.Offset)
+	binary.Write(buf, binary.LittleEndian, pointer.RawLength)
+	binary.Write(buf, binary.LittleEndian, pointer.StoredLength)
+}
+
+func readToastPointer(data []byte) ToastPointer {
+	return ToastPointer{
+		Compressed:   data[0]&toastCompressed != 0,
+		Offset:       binary.LittleEndian.Uint64(data[1:9]),
+		RawLength:    binary.LittleEndian.Uint32(data[9:13]),
+		StoredLength: binary.LittleEndian.Uint32(data[13:17]),
+	}
+}
+
+// readVarlena reads a length prefixed varchar or json value starting at
+// offset, following a ToastPointer when the value is stored out of line.
+// The value is only materialized when fetch is set; the returned offset
+// points past the value either way.
+func readVarlena(data []byte, offset int, fetch bool, toast ToastReader) ([]byte, int, error) {
+	if offset+2 > len(data) {
+		return nil, 0, errors.New("record is truncated")
+	}
+	length := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+
+	if length == toastMarker {
+		if offset+toastPointerSize > len(data) {
+			return nil, 0, errors.New("record is truncated")
+		}
+		pointer := readToastPointer(data[offset : offset+toastPointerSize])
+		offset += toastPointerSize
+		if !fetch {
+			return nil, offset, nil
+		}
+		if toast == nil {
+			return nil, 0, errors.New("record has a toasted value but no toast reader")
+		}
+		value, err := toast(pointer)
+		return value, offset, err
+	}
+
+	if offset+length > len(data) {
+		return nil, 0, errors.New("record is truncated")
+	}
+	return data[offset : offset+length], offset + length, nil
+}
+
+func (tm *TableManager) toastReader(tableName string) ToastReader {
+	return func(pointer ToastPointer) ([]byte, error) {
+		return tm.readToast(tableName, pointer)
+	}
+}
+
+// toastRecord moves varchar and json values out of the record when they
+// are larger than ToastThreshold, and then keeps moving the largest
+// remaining ones until the serialized record fits into a page.
+func (tm *TableManager) toastRecord(tableName string, schema Schema, record *Record) error {
+	record.Items = append([]Item(nil), record.Items...)
+
+	for i, column := range schema.Columns {
+		if column.Type != TypeVarchar && column.Type != TypeJSON {
+			continue
+		}
+		if s, ok := record.Items[i].Literal.(string); ok && len(s) > ToastThreshold {
+			pointer, err := tm.writeToast(tableName, []byte(s))
+			if err != nil {
+				return err
+			}
+			record.Items[i].Literal = pointer
+		}
+	}
+
+	for len(SerializeRecord(schema, *record)) > MaxRecordSize {
+		largest := -1
+		for i, column := range schema.Columns {
+			if column.Type != TypeVarchar && column.Type != TypeJSON {
+				continue
+			}
+			s, ok := record.Items[i].Literal.(string)
+			if !ok || len(s) <= toastPointerSize {
+				continue
+			}
+			if largest < 0 || len(s) > len(record.Items[largest].Literal.(string)) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			return errors.New("record is too large")
+		}
+
+		pointer, err := tm.writeToast(tableName, []byte(record.Items[largest].Literal.(string)))
+		if err != nil {
+			return err
+		}
+		record.Items[largest].Literal = pointer
+	}
+
+	return nil
+}
+
+// writeToast appends a value to the `.toast` file as a run of chunks, each
+// prefixed with its length, compressing it first when that saves space.
+func (tm *TableManager) writeToast(tableName string, value []byte) (ToastPointer, error) {
+	pointer := ToastPointer{RawLength: uint32(len(value))}
+
+	stored := value
+	if tm.CompressToast {
+		var compressed bytes.Buffer
+		w, err := flate.NewWriter(&compressed, flate.BestSpeed)
+		if err != nil {
+			return ToastPointer{}, err
+		}
+		w.Write(value)
+		if err := w.Close(); err != nil {
+			return ToastPointer{}, err
+		}
+		if compressed.Len() < len(value) {
+			stored = compressed.Bytes()
+			pointer.Compressed = true
+		}
+	}
+	pointer.StoredLength = uint32(len(stored))
+
+	var buf bytes.Buffer
+	for start := 0; start < len(stored); start += ToastChunkSize {
+		end := min(start+ToastChunkSize, len(stored))
+		binary.Write(&buf, binary.LittleEndian, uint16(end-start))
+		buf.Write(stored[start:end])
+	}
+
+	offset, err := tm.FileManager.GetFileSize(tableName + ".toast")
+	if err != nil {
+		return ToastPointer{}, err
+	}
+	if err := tm.FileManager.Write(tableName+".toast", offset, buf.Bytes()); err != nil {
+		return ToastPointer{}, err
+	}
+	pointer.Offset = uint64(offset)
+
+	return pointer, nil
+}
+
+func (tm *TableManager) readToast(tableName string, pointer ToastPointer) ([]byte, error) {
+	data, err := tm.FileManager.Read(tableName+".toast", int64(pointer.Offset), toastStoredSize(pointer))
+	if err != nil {
+		return nil, fmt.Errorf("reading toast value of table %s: %w", tableName, err)
+	}
+
+	value, err := decodeToastValue(data, pointer)
+	if err != nil {
+		return nil, fmt.Errorf("toast value of table %s: %w", tableName, err)
+	}
+	return value, nil
+}
+
+// toastStoredSize is the number of bytes a toasted value takes in the
+// `.toast` file, chunk length prefixes included.
+func toastStoredSize(pointer ToastPointer) int64 {
+	chunks := (int64(pointer.StoredLength) + ToastChunkSize - 1) / ToastChunkSize
+	return int64(pointer.StoredLength) + chunks*2
+}
+
+// decodeToastValue reassembles a toasted value from the bytes the pointer
+// refers to.
+func decodeToastValue(data []byte, pointer ToastPointer) ([]byte, error) {
+	stored := make([]byte, 0, pointer.StoredLength)
+	for offset := 0; offset < len(data); {
+		if offset+2 > len(data) {
+			return nil, errors.New("value is truncated")
+		}
+		length := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+		offset += 2
+		if length > ToastChunkSize || offset+length > len(data) {
+			return nil, errors.New("value is corrupted")
+		}
+		stored = append(stored, data[offset:offset+length]...)
+		offset += length
+	}
+
+	if !pointer.Compressed {
+		return stored, nil
+	}
+
+	value, err := io.ReadAll(flate.NewReader(bytes.NewReader(stored)))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing value: %w", err)
+	}
+	if len(value) != int(pointer.RawLength) {
+		return nil, errors.New("value has wrong length")
+	}
+	return value, nil
+}
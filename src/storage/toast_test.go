@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// TestToastRoundTrip checks varchar and json values around and above
+// ToastThreshold read back unchanged, compressed or not, and that only the
+// ones above it go to the toast file.
+func TestToastRoundTrip(t *testing.T) {
+	random := func(n int) string {
+		r := rand.New(rand.NewSource(int64(n)))
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte('a' + r.Intn(26))
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		compress bool
+		toasted  bool
+	}{
+		// the json value is the text in quotes, so exactly at the threshold
+		{"at threshold", strings.Repeat("a", ToastThreshold-2), true, false},
+		{"above threshold", strings.Repeat("a", ToastThreshold+1), true, true},
+		{"several chunks", strings.Repeat("abc", 3*ToastChunkSize), true, true},
+		{"incompressible", random(3*ToastChunkSize + 7), true, true},
+		{"uncompressed", strings.Repeat("abc", 3*ToastChunkSize), false, true},
+		{"larger than a page", random(2 * PageSize), false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTestTableManager(t)
+			tm.CompressToast = tt.compress
+			schema := Schema{Columns: []Column{
+				{Name: "text", Type: TypeVarchar, Length: MaxVarcharLength},
+				{Name: "doc", Type: TypeJSON},
+			}}
+			if err := tm.CreateTable("t", &schema); err != nil {
+				t.Fatal(err)
+			}
+
+			doc, _ := json.Marshal(tt.text)
+			if err := tm.Insert("t", Record{Items: []Item{{Literal: tt.text}, {Literal: string(doc)}}}); err != nil {
+				t.Fatal(err)
+			}
+
+			size, err := tm.FileManager.GetFileSize("t.toast")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if toasted := size > 0; toasted != tt.toasted {
+				t.Errorf("toast file has %d bytes, want toasted %v", size, tt.toasted)
+			}
+
+			rows, err := tm.GetAllData("t", nil, SelectedColumns{Columns: []string{"text", "doc"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			if got := rows[0]["text"]; got != tt.text {
+				t.Errorf("text: got %.40q, want %.40q", got, tt.text)
+			}
+			if got := rows[0]["doc"]; got != tt.text {
+				t.Errorf("doc: got %.40q, want %.40q", got, tt.text)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package utils
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"rdbms/api/models"
 	"rdbms/src/storage"
@@ -15,8 +18,8 @@ var (
 )
 
 func ToStorageSchema(req models.CreateTableRequest) (storage.Schema, error) {
-	if req.Name == "" {
-		return storage.Schema{}, errors.New("table name is required")
+	if err := storage.ValidateTableName(req.Name); err != nil {
+		return storage.Schema{}, err
 	}
 	if len(req.Columns) == 0 {
 		return storage.Schema{}, errors.New("at least one column is required")
@@ -25,53 +28,213 @@ func ToStorageSchema(req models.CreateTableRequest) (storage.Schema, error) {
 	columns := make([]storage.Column, 0, len(req.Columns))
 
 	for _, c := range req.Columns {
-		colName := c.Name
-		if colName == "" {
-			return storage.Schema{}, errors.New("column name is required")
-		}
-
-		var column_type storage.ColumnType
-		var length int
-		switch c.Type {
-		case 0:
-			column_type = storage.TypeInt
-			length = 0
-		case 1:
-			column_type = storage.TypeVarchar
-			if c.Length == nil {
-				length = 255
-			} else {
-				length = *c.Length
-				if length < 0 {
-					return storage.Schema{}, errors.New("invalid length for varchar column")
-				}
-			}
-		case 2:
-			column_type = storage.TypeDate
-			length = 0
-		case 3:
-			column_type = storage.TypeTimestamp
-			length = 0
-		case 4:
-			column_type = storage.TypeFloat
-			length = 0
-		case 5:
-			column_type = storage.TypeJSON
-			length = 0
-		default:
-			return storage.Schema{}, errors.New("unsupported type")
-		}
-
-		columns = append(columns, storage.Column{
-			Name:   colName,
-			Type:   column_type,
-			Length: length,
-		})
+		column, err := ToStorageColumn(c)
+		if err != nil {
+			return storage.Schema{}, err
+		}
+		columns = append(columns, column)
 	}
 
 	return storage.Schema{Columns: columns}, nil
 }
 
+func ToStorageColumn(c models.CreateColumn) (storage.Column, error) {
+	colName := c.Name
+	if colName == "" {
+		return storage.Column{}, errors.New("column name is required")
+	}
+
+	var column_type storage.ColumnType
+	var length int
+	switch c.Type {
+	case 0:
+		column_type = storage.TypeInt
+		length = 0
+	case 1:
+		column_type = storage.TypeVarchar
+		if c.Length == nil {
+			length = 255
+		} else {
+			length = *c.Length
+			if length < 0 {
+				return storage.Column{}, errors.New("invalid length for varchar column")
+			}
+		}
+	case 2:
+		column_type = storage.TypeDate
+		length = 0
+	case 3:
+		column_type = storage.TypeTimestamp
+		length = 0
+	case 4:
+		column_type = storage.TypeFloat
+		length = 0
+	case 5:
+		column_type = storage.TypeJSON
+		length = 0
+	default:
+		return storage.Column{}, errors.New("unsupported type")
+	}
+
+	column := storage.Column{
+		Name:   colName,
+		Type:   column_type,
+		Length: length,
+	}
+	if err := storage.ValidateColumn(column); err != nil {
+		return storage.Column{}, err
+	}
+	return column, nil
+}
+
+// ToStorageItem validates a JSON decoded value against a column and converts
+// it to the literal SerializeRecord expects for the column type.
+func ToStorageItem(col storage.Column, v any) (storage.Item, error) {
+	switch col.Type {
+	case storage.TypeInt:
+		n, ok := intValue(v)
+		if !ok {
+			return storage.Item{}, errors.New("column " + col.Name + " must be integer")
+		}
+		return storage.Item{Literal: int(n)}, nil
+	case storage.TypeVarchar:
+		s, ok := v.(string)
+		if !ok {
+			return storage.Item{}, errors.New("column " + col.Name + " must be string")
+		}
+		if len(s) > col.Length {
+			return storage.Item{}, errors.New("column " + col.Name + " exceeds length")
+		}
+		return storage.Item{Literal: s}, nil
+	case storage.TypeFloat:
+		f, ok := v.(float64)
+		if !ok {
+			return storage.Item{}, errors.New("column " + col.Name + " must be number")
+		}
+		return storage.Item{Literal: f}, nil
+	case storage.TypeJSON:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return storage.Item{}, errors.New("invalid json for " + col.Name)
+		}
+		return storage.Item{Literal: string(b)}, nil
+	case storage.TypeDate:
+		s, ok := v.(string)
+		if !ok {
+			return storage.Item{}, errors.New("column " + col.Name + " must be date string YYYY-MM-DD")
+		}
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return storage.Item{}, errors.New("invalid date format for " + col.Name)
+		}
+		return storage.Item{Literal: s}, nil
+	case storage.TypeTimestamp:
+		s, ok := v.(string)
+		if !ok {
+			return storage.Item{}, errors.New("column " + col.Name + " must be RFC3339 timestamp string")
+		}
+		if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			return storage.Item{}, errors.New("invalid timestamp format for " + col.Name)
+		}
+		return storage.Item{Literal: s}, nil
+	}
+
+	return storage.Item{}, errors.New("unsupported type for column " + col.Name)
+}
+
+// intValue accepts the float64 of a JSON decoded number holding an integer,
+// and Go integers, which keep their full 64-bit precision.
+func intValue(v any) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), n == float64(int64(n))
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// ToStorageRecord builds a record in schema column order from JSON decoded
+// values. Columns missing from values fall back to their default, if any.
+func ToStorageRecord(schema storage.Schema, values map[string]any) (storage.Record, error) {
+	items := make([]storage.Item, 0, len(schema.Columns))
+	for _, col := range schema.Columns {
+		v, ok := values[col.Name]
+		if !ok {
+			if col.Default == nil {
+				return storage.Record{}, errors.New("missing column: " + col.Name)
+			}
+			v = col.Default
+		}
+
+		item, err := ToStorageItem(col, v)
+		if err != nil {
+			return storage.Record{}, err
+		}
+		items = append(items, item)
+	}
+
+	return storage.Record{Items: items}, nil
+}
+
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "02.01.2006"}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z07",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTextValue converts the text form of a value, as found in a CSV
+// field or a SQL literal, to the JSON decoded value ToStorageItem accepts
+// for the column. Dates and timestamps are accepted in a few common layouts
+// and normalized; timestamps without a zone are taken as UTC.
+func ParseTextValue(column storage.Column, field string) (any, error) {
+	s := strings.TrimSpace(field)
+
+	switch column.Type {
+	case storage.TypeInt:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %q is not an integer", column.Name, field)
+		}
+		return n, nil
+	case storage.TypeFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %q is not a number", column.Name, field)
+		}
+		return f, nil
+	case storage.TypeDate:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.Format("2006-01-02"), nil
+			}
+		}
+		return nil, fmt.Errorf("column %s: %q is not a date", column.Name, field)
+	case storage.TypeTimestamp:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.UTC().Format(time.RFC3339Nano), nil
+			}
+		}
+		return nil, fmt.Errorf("column %s: %q is not a timestamp", column.Name, field)
+	case storage.TypeJSON:
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("column %s: invalid json: %v", column.Name, err)
+		}
+		return v, nil
+	}
+
+	return field, nil
+}
+
 func SetFilterColumnIndexes(schema storage.Schema, filters []storage.Filter) ([]storage.Filter, error) {
 	schemaMap := make(map[string]int)
 	for idx, column := range schema.Columns {
@@ -101,12 +264,12 @@ func SetFilterColumnIndexes(schema storage.Schema, filters []storage.Filter) ([]
 		var filterValue interface{}
 		switch colType {
 		case storage.TypeInt:
-			n, ok := f.Value.(float64)
-			if !ok || n != float64(int64(n)) {
+			n, ok := intValue(f.Value)
+			if !ok {
 				typeErrors = append(typeErrors, fmt.Sprintf("%s: expected integer", f.Column))
 				continue
 			}
-			filterValue = int64(n)
+			filterValue = n
 		case storage.TypeFloat:
 			n, ok := f.Value.(float64)
 			if !ok {